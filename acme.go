@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ACME_SERVICE_NAME   = "docker-flow-proxy-acme"
+	ACME_CHALLENGE_PATH = "/.well-known/acme-challenge/"
+)
+
+type Acmeable interface {
+	Watch() error
+	Stop()
+	ServeHTTP(w http.ResponseWriter, req *http.Request)
+}
+
+type Acme struct {
+	BaseReconfigure
+	Directory   string
+	Email       string
+	CACert      string
+	RenewBefore time.Duration
+	Interval    time.Duration
+	address     string
+	replicas    Replicable
+	client      *acme.Client
+	tokens      map[string]string
+	mu          *sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// NewAcme creates an ACME client. Obtained certificates are propagated through replicas unless it is nil.
+var NewAcme = func(baseData BaseReconfigure, directory, email, caCert, address string, replicas Replicable) Acmeable {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Acme{
+		BaseReconfigure: baseData,
+		Directory:       directory,
+		Email:           email,
+		CACert:          caCert,
+		RenewBefore:     30 * 24 * time.Hour,
+		Interval:        12 * time.Hour,
+		address:         address,
+		replicas:        replicas,
+		tokens:          map[string]string{},
+		mu:              &sync.Mutex{},
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+func (m *Acme) Watch() error {
+	if err := m.addChallengeRoute(); err != nil {
+		return err
+	}
+	logPrintf("Obtaining certificates from %s", m.Directory)
+	for {
+		if err := m.update(); err != nil {
+			logPrintf("Could not obtain certificates\n%s", err.Error())
+		}
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-time.After(m.Interval):
+		}
+	}
+}
+
+func (m *Acme) Stop() {
+	m.cancel()
+}
+
+func (m *Acme) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.URL.Path, ACME_CHALLENGE_PATH)
+	m.mu.Lock()
+	response, ok := m.tokens[token]
+	m.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	httpWriterSetContentType(w, "text/plain")
+	w.Write([]byte(response))
+}
+
+// Challenges reach the proxy on port 80 so they are routed to the server API either through the shared frontend
+// or, with per-service frontends, through a frontend of their own.
+func (m *Acme) addChallengeRoute() error {
+	mu.Lock()
+	defer mu.Unlock()
+	rules := fmt.Sprintf(`	acl url_%s path_beg %s
+	use_backend %s-be if url_%s`, ACME_SERVICE_NAME, ACME_CHALLENGE_PATH, ACME_SERVICE_NAME, ACME_SERVICE_NAME)
+	backend := fmt.Sprintf(`backend %s-be
+    server %s %s`, ACME_SERVICE_NAME, ACME_SERVICE_NAME, m.address)
+	frontendDest := fmt.Sprintf("%s/%s.fe", m.TemplatesPath, ACME_SERVICE_NAME)
+	if m.PerServiceFrontends {
+		backend = fmt.Sprintf(`frontend %s-fe
+	bind *:80
+	option http-server-close
+%s
+
+%s`, ACME_SERVICE_NAME, rules, backend)
+		osRemove(frontendDest)
+	} else if err := writeServiceConfigFile(frontendDest, []byte(rules), 0664); err != nil {
+		return fmt.Errorf("Could not write the file %s\n%s", frontendDest, err.Error())
+	}
+	backendDest := fmt.Sprintf("%s/%s.cfg", m.TemplatesPath, ACME_SERVICE_NAME)
+	if err := writeServiceConfigFile(backendDest, []byte(backend), 0664); err != nil {
+		return fmt.Errorf("Could not write the file %s\n%s", backendDest, err.Error())
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		return err
+	}
+	return proxy.Reload()
+}
+
+func (m *Acme) update() error {
+	domains, err := m.getDomains()
+	if err != nil {
+		return err
+	}
+	cert := NewCert(m.BaseReconfigure)
+	certs, err := cert.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if m.isCovered(certs, domain) {
+			continue
+		}
+		logPrintf("Obtaining a certificate for %s", domain)
+		content, err := m.obtain(domain)
+		if err != nil {
+			logPrintf("Could not obtain a certificate for %s\n%s", domain, err.Error())
+			continue
+		}
+		if err := cert.Put(domain, content); err != nil {
+			logPrintf("Could not store the certificate for %s\n%s", domain, err.Error())
+			continue
+		}
+		if m.replicas != nil {
+			if err := m.replicas.Publish("putCert", domain); err != nil {
+				logPrintf("Could not propagate the certificate for %s to other replicas\n%s", domain, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Wildcard domains cannot be validated through the http-01 challenge.
+func (m *Acme) getDomains() ([]string, error) {
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return nil, err
+	}
+	services, err := registry.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	unique := map[string]bool{}
+	for _, service := range services {
+		value, ok := registry.GetServiceAttribute(service, DOMAIN_KEY)
+		if !ok {
+			continue
+		}
+		for _, domain := range strings.Split(value, ",") {
+			if len(domain) > 0 && !strings.Contains(domain, "*") {
+				unique[strings.ToLower(domain)] = true
+			}
+		}
+	}
+	domains := []string{}
+	for domain := range unique {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+func (m *Acme) isCovered(certs []CertInfo, domain string) bool {
+	for _, c := range certs {
+		if time.Until(c.NotAfter) <= m.RenewBefore {
+			continue
+		}
+		for _, name := range append(c.DNSNames, c.CommonName) {
+			if strings.EqualFold(name, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Acme) obtain(domain string) ([]byte, error) {
+	client, err := m.getClient()
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(m.ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create the order\n%s", err.Error())
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(client, url); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(m.ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("The order was not authorized\n%s", err.Error())
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create the certificate request\n%s", err.Error())
+	}
+	ders, _, err := client.CreateOrderCert(m.ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("Could not finalize the order\n%s", err.Error())
+	}
+	content := []byte{}
+	for _, der := range ders {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return append(content, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...), nil
+}
+
+func (m *Acme) authorize(client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(m.ctx, url)
+	if err != nil {
+		return fmt.Errorf("Could not retrieve the authorization %s\n%s", url, err.Error())
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("The ACME server did not offer the http-01 challenge for %s", authz.Identifier.Value)
+	}
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.tokens[challenge.Token] = response
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.tokens, challenge.Token)
+		m.mu.Unlock()
+	}()
+	if _, err := client.Accept(m.ctx, challenge); err != nil {
+		return fmt.Errorf("Could not accept the challenge for %s\n%s", authz.Identifier.Value, err.Error())
+	}
+	if _, err := client.WaitAuthorization(m.ctx, authz.URI); err != nil {
+		return fmt.Errorf("The domain %s was not authorized\n%s", authz.Identifier.Value, err.Error())
+	}
+	return nil
+}
+
+func (m *Acme) getClient() (*acme.Client, error) {
+	if m.client != nil {
+		return m.client, nil
+	}
+	key, err := m.getAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	httpClient := http.DefaultClient
+	if len(m.CACert) > 0 {
+		caCert, err := readConfigsFile(m.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the file %s\n%s", m.CACert, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("The file %s does not contain any certificates", m.CACert)
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.Directory, HTTPClient: httpClient}
+	account := &acme.Account{}
+	if len(m.Email) > 0 {
+		account.Contact = []string{fmt.Sprintf("mailto:%s", m.Email)}
+	}
+	if _, err := client.Register(m.ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("Could not register the account with %s\n%s", m.Directory, err.Error())
+	}
+	m.client = client
+	return client, nil
+}
+
+// The account key is kept so that the proxy does not register a new account every time it starts.
+func (m *Acme) getAccountKey() (crypto.Signer, error) {
+	path := fmt.Sprintf("%s/acme/account.pem", m.ConfigsPath)
+	if content, err := readConfigsFile(path); err == nil {
+		if block, _ := pem.Decode(content); block != nil {
+			if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+				return key, nil
+			}
+		}
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	if err := os.MkdirAll(fmt.Sprintf("%s/acme", m.ConfigsPath), 0700); err != nil {
+		return nil, fmt.Errorf("Could not create the directory %s/acme\n%s", m.ConfigsPath, err.Error())
+	}
+	if err := writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("Could not write the file %s\n%s", path, err.Error())
+	}
+	return key, nil
+}
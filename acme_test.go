@@ -0,0 +1,414 @@
+// +build !integration
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type AcmeTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Server     *httptest.Server
+	CAKey      *ecdsa.PrivateKey
+	CACert     *x509.Certificate
+	mu         *sync.Mutex
+	acme       *Acme
+	registry   *RegistryMock
+	Validated  []string
+	Orders     []string
+	Issued     string
+	origWrite  func(filename string, data []byte, perm os.FileMode) error
+	origReg    func(baseData BaseReconfigure) (Registry, error)
+	origProxy  Proxy
+}
+
+func (s *AcmeTestSuite) SetupTest() {
+	s.ConfigsPath, _ = ioutil.TempDir("", "acme")
+	s.TemplatesPath = "test_configs/tmpl"
+	s.mu.Lock()
+	s.Validated = []string{}
+	s.Orders = []string{}
+	s.mu.Unlock()
+	s.acme = NewAcme(s.BaseReconfigure, fmt.Sprintf("%s/directory", s.Server.URL), "me@example.com", "", "127.0.0.1:8080", nil).(*Acme)
+	s.registry = new(RegistryMock)
+	s.registry.On("GetServices").Return([]string{"go-demo"}, nil)
+	s.registry.On("GetServiceAttribute", "go-demo", DOMAIN_KEY).Return("my-domain.com", true)
+	s.registry.On("PutCert", mock.Anything, mock.Anything).Return(nil)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return s.registry, nil
+	}
+	proxy = getProxyMock("")
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		return nil
+	}
+	readConfigsFile = ioutil.ReadFile
+	writeFile = ioutil.WriteFile
+	osRemove = os.Remove
+}
+
+func (s *AcmeTestSuite) TearDownTest() {
+	os.RemoveAll(s.ConfigsPath)
+}
+
+// ServeHTTP
+
+func (s AcmeTestSuite) Test_ServeHTTP_ReturnsKeyAuthorization() {
+	s.acme.tokens["my-token"] = "my-token.my-thumbprint"
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", ACME_CHALLENGE_PATH+"my-token", nil)
+
+	s.acme.ServeHTTP(w, req)
+
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("my-token.my-thumbprint", w.Body.String())
+}
+
+func (s AcmeTestSuite) Test_ServeHTTP_ReturnsNotFound_WhenTokenIsUnknown() {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", ACME_CHALLENGE_PATH+"unknown-token", nil)
+
+	s.acme.ServeHTTP(w, req)
+
+	s.Equal(http.StatusNotFound, w.Code)
+}
+
+// Watch
+
+func (s AcmeTestSuite) Test_Watch_RoutesChallengesToServer() {
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	s.acme.Stop()
+
+	s.acme.Watch()
+
+	s.Equal(map[string]string{
+		fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, ACME_SERVICE_NAME): `backend docker-flow-proxy-acme-be
+    server docker-flow-proxy-acme 127.0.0.1:8080`,
+		fmt.Sprintf("%s/%s.fe", s.TemplatesPath, ACME_SERVICE_NAME): `	acl url_docker-flow-proxy-acme path_beg /.well-known/acme-challenge/
+	use_backend docker-flow-proxy-acme-be if url_docker-flow-proxy-acme`,
+	}, actual)
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s AcmeTestSuite) Test_Watch_RoutesChallengesThroughOwnFrontend_WhenPerServiceFrontendsIsTrue() {
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+	actualRemoved := ""
+	osRemove = func(name string) error {
+		actualRemoved = name
+		return nil
+	}
+	s.acme.PerServiceFrontends = true
+	s.acme.Stop()
+
+	s.acme.Watch()
+
+	s.Equal(map[string]string{
+		fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, ACME_SERVICE_NAME): `frontend docker-flow-proxy-acme-fe
+	bind *:80
+	option http-server-close
+	acl url_docker-flow-proxy-acme path_beg /.well-known/acme-challenge/
+	use_backend docker-flow-proxy-acme-be if url_docker-flow-proxy-acme
+
+backend docker-flow-proxy-acme-be
+    server docker-flow-proxy-acme 127.0.0.1:8080`,
+	}, actual)
+	s.Equal(fmt.Sprintf("%s/%s.fe", s.TemplatesPath, ACME_SERVICE_NAME), actualRemoved)
+}
+
+func (s AcmeTestSuite) Test_Watch_DoesNotRedirectChallengesOfHttpsOnlyServices() {
+	templatesPath, _ := ioutil.TempDir("", "acme-templates")
+	defer os.RemoveAll(templatesPath)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("template content"), 0664)
+	r := &Reconfigure{}
+	sr := ServiceReconfigure{
+		ServiceName:   "go-demo",
+		ServicePath:   []string{"/"},
+		ServiceDomain: []string{"my-domain.com"},
+		HttpsOnly:     true,
+	}
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.fe", templatesPath), []byte(r.getFrontendRulesFromGo(sr)), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.cfg", templatesPath), []byte("backend go-demo-be"), 0664)
+	writeServiceConfigFile = ioutil.WriteFile
+	defer func() {
+		writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+			return nil
+		}
+	}()
+	cmdRunHaOrig := cmdRunHa
+	defer func() { cmdRunHa = cmdRunHaOrig }()
+	cmdRunHa = func(cmd *exec.Cmd) error {
+		return nil
+	}
+	s.acme.TemplatesPath = templatesPath
+	s.acme.Stop()
+	s.acme.Watch()
+
+	HaProxy{}.CreateConfigFromTemplates(templatesPath, s.ConfigsPath)
+
+	actual, _ := ioutil.ReadFile(fmt.Sprintf("%s/haproxy.cfg", s.ConfigsPath))
+	s.Contains(string(actual), `
+	redirect scheme https if !{ ssl_fc } !{ path_beg /.well-known/acme-challenge/ } url_go-demo domain_go-demo
+	use_backend docker-flow-proxy-acme-be if url_docker-flow-proxy-acme
+	use_backend go-demo-be if url_go-demo domain_go-demo
+`)
+}
+
+func (s AcmeTestSuite) Test_Watch_ReturnsError_WhenProxyConfigIsNotValid() {
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	s.Error(s.acme.Watch())
+}
+
+// update
+
+func (s *AcmeTestSuite) Test_Update_ObtainsCertificatesForServiceDomains() {
+	err := s.acme.update()
+
+	s.NoError(err)
+	s.Equal([]string{"my-domain.com"}, s.Validated)
+	content, _ := ioutil.ReadFile(fmt.Sprintf("%s/certs/my-domain.com.pem", s.ConfigsPath))
+	cert, err := (&Cert{}).parse(content)
+	s.NoError(err)
+	s.Equal([]string{"my-domain.com"}, cert.DNSNames)
+	s.Equal("My Test CA", cert.Issuer.CommonName)
+	s.registry.AssertCalled(s.T(), "PutCert", "my-domain.com", content)
+}
+
+func (s *AcmeTestSuite) Test_Update_PublishesObtainedCertificates() {
+	mockObj := getReplicasMock("")
+	s.acme.replicas = mockObj
+
+	s.acme.update()
+
+	mockObj.AssertCalled(s.T(), "Publish", "putCert", "my-domain.com")
+}
+
+func (s *AcmeTestSuite) Test_Update_DoesNotObtainCertificates_WhenDomainIsCovered() {
+	os.MkdirAll(fmt.Sprintf("%s/certs", s.ConfigsPath), 0755)
+	ioutil.WriteFile(
+		fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath),
+		getTestPem("my-domain.com", time.Now().Add(90*24*time.Hour)),
+		0600,
+	)
+
+	s.acme.update()
+
+	s.Empty(s.Orders)
+}
+
+func (s *AcmeTestSuite) Test_Update_RenewsCertificates_WhenTheyAreAboutToExpire() {
+	os.MkdirAll(fmt.Sprintf("%s/certs", s.ConfigsPath), 0755)
+	ioutil.WriteFile(
+		fmt.Sprintf("%s/certs/my-domain.com.pem", s.ConfigsPath),
+		getTestPem("my-domain.com", time.Now().Add(24*time.Hour)),
+		0600,
+	)
+
+	s.acme.update()
+
+	s.Equal([]string{"my-domain.com"}, s.Orders)
+}
+
+// getDomains
+
+func (s *AcmeTestSuite) Test_GetDomains_ReturnsUniqueDomainsWithoutWildcards() {
+	s.registry = new(RegistryMock)
+	s.registry.On("GetServiceAttribute", "a", DOMAIN_KEY).Return("My-Domain.com", true)
+	s.registry.On("GetServiceAttribute", "b", DOMAIN_KEY).Return("my-domain.com", true)
+	s.registry.On("GetServiceAttribute", "c", DOMAIN_KEY).Return("*.my-domain.com", true)
+	s.registry.On("GetServiceAttribute", "d", DOMAIN_KEY).Return("", false)
+	s.registry.On("GetServiceAttribute", "e", DOMAIN_KEY).Return("other-domain.com,*.other-domain.com,my-domain.com", true)
+	s.registry.On("GetServices").Return([]string{"a", "b", "c", "d", "e"}, nil)
+
+	actual, _ := s.acme.getDomains()
+
+	s.Equal([]string{"my-domain.com", "other-domain.com"}, actual)
+}
+
+// getAccountKey
+
+func (s AcmeTestSuite) Test_GetAccountKey_ReusesStoredKey() {
+	expected, _ := s.acme.getAccountKey()
+
+	actual, _ := s.acme.getAccountKey()
+
+	s.Equal(expected, actual)
+}
+
+// Suite
+
+func TestAcmeTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	s := new(AcmeTestSuite)
+	s.mu = &sync.Mutex{}
+	s.origWrite = writeServiceConfigFile
+	s.origReg = NewRegistry
+	s.origProxy = proxy
+	defer func() {
+		writeServiceConfigFile = s.origWrite
+		NewRegistry = s.origReg
+		proxy = s.origProxy
+	}()
+	s.CAKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "My Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template, &s.CAKey.PublicKey, s.CAKey)
+	s.CACert, _ = x509.ParseCertificate(der)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveAcme))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
+
+// Util
+
+// serveAcme is a minimal ACME server that validates the http-01 challenge against the Acme handler.
+func (s *AcmeTestSuite) serveAcme(w http.ResponseWriter, r *http.Request) {
+	url := s.Server.URL
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	payload := struct {
+		Payload string `json:"payload"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	body, _ := base64.RawURLEncoding.DecodeString(payload.Payload)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := func(status string) string {
+		return fmt.Sprintf(
+			`{"status": "%s", "identifiers": [{"type": "dns", "value": "my-domain.com"}], "authorizations": ["%s/authz"], "finalize": "%s/finalize", "certificate": "%s/cert"}`,
+			status, url, url, url,
+		)
+	}
+	switch r.URL.Path {
+	case "/directory":
+		fmt.Fprintf(w, `{"newNonce": "%s/nonce", "newAccount": "%s/account", "newOrder": "%s/order"}`, url, url, url)
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		w.Header().Set("Location", fmt.Sprintf("%s/account/1", url))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status": "valid"}`)
+	case "/order":
+		s.Orders = append(s.Orders, "my-domain.com")
+		w.Header().Set("Location", fmt.Sprintf("%s/order/1", url))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, order("pending"))
+	case "/order/1":
+		w.Header().Set("Location", fmt.Sprintf("%s/order/1", url))
+		fmt.Fprint(w, order("ready"))
+	case "/authz":
+		status := "pending"
+		if len(s.Validated) > 0 {
+			status = "valid"
+		}
+		fmt.Fprintf(
+			w,
+			`{"status": "%s", "identifier": {"type": "dns", "value": "my-domain.com"}, "challenges": [{"type": "http-01", "url": "%s/challenge", "token": "my-token", "status": "%s"}]}`,
+			status, url, status,
+		)
+	case "/challenge":
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", ACME_CHALLENGE_PATH+"my-token", nil)
+		s.acme.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK && strings.HasPrefix(rec.Body.String(), "my-token.") {
+			s.Validated = append(s.Validated, "my-domain.com")
+		}
+		fmt.Fprintf(w, `{"type": "http-01", "url": "%s/challenge", "token": "my-token", "status": "processing"}`, url)
+	case "/finalize":
+		req := struct {
+			CSR string `json:"csr"`
+		}{}
+		json.Unmarshal(body, &req)
+		csrDer, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, _ := x509.ParseCertificateRequest(csrDer)
+		template := x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		der, _ := x509.CreateCertificate(rand.Reader, &template, s.CACert, csr.PublicKey, s.CAKey)
+		s.Issued = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+		w.Header().Set("Location", fmt.Sprintf("%s/order/1", url))
+		fmt.Fprint(w, order("valid"))
+	case "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		fmt.Fprint(w, s.Issued)
+		fmt.Fprint(w, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.CACert.Raw})))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Mock
+
+type AcmeMock struct {
+	mock.Mock
+}
+
+func (m *AcmeMock) Watch() error {
+	params := m.Called()
+	return params.Error(0)
+}
+
+func (m *AcmeMock) Stop() {
+	m.Called()
+}
+
+func (m *AcmeMock) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.Called(w, req)
+}
+
+func getAcmeMock(skipMethod string) *AcmeMock {
+	mockObj := new(AcmeMock)
+	if skipMethod != "Watch" {
+		mockObj.On("Watch").Return(nil)
+	}
+	if skipMethod != "Stop" {
+		mockObj.On("Stop")
+	}
+	if skipMethod != "ServeHTTP" {
+		mockObj.On("ServeHTTP", mock.Anything, mock.Anything)
+	}
+	return mockObj
+}
@@ -18,13 +18,10 @@ type ArgsTestSuite struct {
 }
 
 func (s *ArgsTestSuite) SetupTest() {
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		return nil
-	}
 	cmdRunHa = func(cmd *exec.Cmd) error {
 		return nil
 	}
-	writeConsulTemplateFile = func(fileName string, data []byte, perm os.FileMode) error {
+	writeServiceConfigFile = func(fileName string, data []byte, perm os.FileMode) error {
 		return nil
 	}
 	httpListenAndServe = func(addr string, handler http.Handler) error {
@@ -36,6 +33,9 @@ func (s *ArgsTestSuite) SetupTest() {
 	osRemove = func(name string) error {
 		return nil
 	}
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
 	os.Setenv("CONSUL_ADDRESS", "myConsulAddress")
 }
 
@@ -68,11 +68,13 @@ func (s ArgsTestSuite) Test_Parse_ParsesReconfigureLongArgsStrings() {
 	}{
 		{"serviceNameFromArgs", "service-name", &reconfigure.ServiceName},
 		{"serviceColorFromArgs", "service-color", &reconfigure.ServiceColor},
-		{"serviceDomainFromArgs", "service-domain", &reconfigure.ServiceDomain},
 		{"consulAddressFromArgs", "consul-address", &reconfigure.ConsulAddress},
 		{"templatesPathFromArgs", "templates-path", &reconfigure.TemplatesPath},
 		{"configsPathFromArgs", "configs-path", &reconfigure.ConfigsPath},
 		{"consulTemplatePath", "consul-template-path", &reconfigure.ConsulTemplatePath},
+		{"etcd", "registry", &reconfigure.RegistryType},
+		{"etcdAddressFromArgs", "etcd-address", &reconfigure.EtcdAddress},
+		{"fileRegistryPathFromArgs", "file-registry-path", &reconfigure.FileRegistryPath},
 	}
 
 	for _, d := range data {
@@ -92,6 +94,7 @@ func (s ArgsTestSuite) Test_Parse_ParsesReconfigureLongArgsSlices() {
 		value    *[]string
 	}{
 		{[]string{"path1", "path2"}, "service-path", &reconfigure.ServicePath},
+		{[]string{"domain1.com", "*.domain2.com"}, "service-domain", &reconfigure.ServiceDomain},
 	}
 
 	for _, d := range data {
@@ -108,6 +111,22 @@ func (s ArgsTestSuite) Test_Parse_ParsesReconfigureLongArgsSlices() {
 	}
 }
 
+func (s ArgsTestSuite) Test_Parse_ParsesReconfigureServiceWeights() {
+	os.Args = []string{"myProgram", "reconfigure", "--service-weight", "blue:90", "--service-weight", "green:10"}
+
+	Args{}.Parse()
+
+	s.Equal(map[string]int{"blue": 90, "green": 10}, reconfigure.ServiceWeights)
+}
+
+func (s ArgsTestSuite) Test_Parse_ParsesReconfigureDryRun() {
+	os.Args = []string{"myProgram", "reconfigure", "--service-name", "go-demo", "--dry-run"}
+
+	Args{}.Parse()
+
+	s.True(reconfigure.DryRun)
+}
+
 func (s ArgsTestSuite) Test_Parse_ParsesReconfigureShortArgsStrings() {
 	os.Args = []string{"myProgram", "reconfigure"}
 	data := []struct {
@@ -211,6 +230,10 @@ func (s ArgsTestSuite) Test_Parse_ParsesRemoveLongArgsStrings() {
 		{"serviceNameFromArgs", "service-name", &remove.ServiceName},
 		{"templatesPathFromArgs", "templates-path", &remove.TemplatesPath},
 		{"configsPathFromArgs", "configs-path", &remove.ConfigsPath},
+		{"consulAddressFromArgs", "consul-address", &remove.ConsulAddress},
+		{"etcd", "registry", &remove.RegistryType},
+		{"etcdAddressFromArgs", "etcd-address", &remove.EtcdAddress},
+		{"fileRegistryPathFromArgs", "file-registry-path", &remove.FileRegistryPath},
 	}
 
 	for _, d := range data {
@@ -245,6 +268,15 @@ func (s ArgsTestSuite) Test_Parse_ParsesRemoveShortArgsStrings() {
 	}
 }
 
+func (s ArgsTestSuite) Test_Parse_ParsesRemoveKeepRegistry() {
+	os.Args = []string{"myProgram", "remove", "--service-name", "go-demo", "--keep-registry"}
+
+	err := Args{}.Parse()
+
+	s.NoError(err)
+	s.True(remove.KeepRegistry)
+}
+
 // Parse > Server
 
 func (s ArgsTestSuite) Test_Parse_ParsesServerLongArgs() {
@@ -256,6 +288,9 @@ func (s ArgsTestSuite) Test_Parse_ParsesServerLongArgs() {
 	}{
 		{"ipFromArgs", "ip", &server.IP},
 		{"portFromArgs", "port", &server.Port},
+		{"etcd", "registry", &server.RegistryType},
+		{"etcdAddressFromArgs", "etcd-address", &server.EtcdAddress},
+		{"fileRegistryPathFromArgs", "file-registry-path", &server.FileRegistryPath},
 	}
 
 	for _, d := range data {
@@ -325,6 +360,34 @@ func (s ArgsTestSuite) Test_Parse_ServerDefaultsToEnvVars() {
 	}
 }
 
+func (s ArgsTestSuite) Test_Parse_ParsesServerReplicasArgs() {
+	os.Args = []string{"myProgram", "server", "--replicas-sync", "--replica-name", "proxy-1"}
+
+	Args{}.Parse()
+
+	s.True(server.ReplicasSync)
+	s.Equal("proxy-1", server.ReplicaName)
+}
+
+func (s ArgsTestSuite) Test_Parse_ServerHistoryLimitDefaultsToTen() {
+	os.Args = []string{"myProgram", "server"}
+	os.Unsetenv("HISTORY_LIMIT")
+
+	Args{}.Parse()
+
+	s.Equal(10, server.HistoryLimit)
+}
+
+func (s ArgsTestSuite) Test_Parse_ServerDefaultUsersDefaultToEnvVar() {
+	os.Args = []string{"myProgram", "server"}
+	os.Setenv("PROXY_DEFAULT_USERS", "admin:$1$salt$hash,other:$1$salt$hash")
+	defer os.Unsetenv("PROXY_DEFAULT_USERS")
+
+	Args{}.Parse()
+
+	s.Equal([]string{"admin:$1$salt$hash", "other:$1$salt$hash"}, server.DefaultUsers)
+}
+
 // Suite
 
 func TestArgsTestSuite(t *testing.T) {
@@ -334,6 +397,8 @@ func TestArgsTestSuite(t *testing.T) {
 		proxy = proxyOrig
 	}()
 	proxy = getProxyMock("")
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
 	suite.Run(t, new(ArgsTestSuite))
 }
 
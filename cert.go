@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type Certable interface {
+	Put(certName string, content []byte) error
+	GetAll() ([]CertInfo, error)
+	Delete(certName string) error
+	Restore() error
+}
+
+type Cert struct {
+	BaseReconfigure
+}
+
+type CertInfo struct {
+	Name       string
+	CommonName string
+	DNSNames   []string
+	NotAfter   time.Time
+}
+
+var NewCert = func(baseData BaseReconfigure) Certable {
+	return &Cert{BaseReconfigure: baseData}
+}
+
+func (m *Cert) Put(certName string, content []byte) error {
+	if err := m.validateName(certName); err != nil {
+		return err
+	}
+	if _, err := m.parse(content); err != nil {
+		return err
+	}
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	path := m.getPath(certName)
+	previous, previousErr := readConfigsFile(path)
+	if err := os.MkdirAll(m.getDir(), 0755); err != nil {
+		return fmt.Errorf("Could not create the directory %s\n%s", m.getDir(), err.Error())
+	}
+	if err := writeFile(path, content, 0600); err != nil {
+		return fmt.Errorf("Could not write the certificate %s\n%s", certName, err.Error())
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		if previousErr == nil {
+			writeFile(path, previous, 0600)
+		} else {
+			osRemove(path)
+		}
+		return err
+	}
+	if err := proxy.Reload(); err != nil {
+		return err
+	}
+	return registry.PutCert(certName, content)
+}
+
+func (m *Cert) GetAll() ([]CertInfo, error) {
+	certs := []CertInfo{}
+	files, err := readConfigsDir(m.getDir())
+	if os.IsNotExist(err) {
+		return certs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read the directory %s\n%s", m.getDir(), err.Error())
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".pem") {
+			continue
+		}
+		content, err := readConfigsFile(fmt.Sprintf("%s/%s", m.getDir(), fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the certificate %s\n%s", fi.Name(), err.Error())
+		}
+		info := CertInfo{Name: strings.TrimSuffix(fi.Name(), ".pem")}
+		if cert, err := m.parse(content); err == nil {
+			info.CommonName = cert.Subject.CommonName
+			info.DNSNames = cert.DNSNames
+			info.NotAfter = cert.NotAfter
+		}
+		certs = append(certs, info)
+	}
+	return certs, nil
+}
+
+func (m *Cert) Delete(certName string) error {
+	if err := m.validateName(certName); err != nil {
+		return err
+	}
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	path := m.getPath(certName)
+	previous, err := readConfigsFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not find the certificate %s", certName)
+	}
+	if err := osRemove(path); err != nil {
+		return fmt.Errorf("Could not remove the certificate %s\n%s", certName, err.Error())
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		writeFile(path, previous, 0600)
+		return err
+	}
+	if err := proxy.Reload(); err != nil {
+		return err
+	}
+	return registry.DeleteCert(certName)
+}
+
+func (m *Cert) Restore() error {
+	logPrintf("Restoring certificates")
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return err
+	}
+	certs, err := registry.GetCerts()
+	if err != nil {
+		return err
+	}
+	logPrintf("\tFound %d certificates", len(certs))
+	if len(certs) == 0 {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(m.getDir(), 0755); err != nil {
+		return fmt.Errorf("Could not create the directory %s\n%s", m.getDir(), err.Error())
+	}
+	for certName, content := range certs {
+		if err := m.validateName(certName); err != nil {
+			logPrintf("\tSkipping the certificate %s\n%s", certName, err.Error())
+			continue
+		}
+		if err := writeFile(m.getPath(certName), content, 0600); err != nil {
+			return fmt.Errorf("Could not write the certificate %s\n%s", certName, err.Error())
+		}
+	}
+	return nil
+}
+
+func (m *Cert) parse(content []byte) (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	hasKey := false
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" && cert == nil {
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse the certificate\n%s", err.Error())
+			}
+			cert = c
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			hasKey = true
+		}
+	}
+	if cert == nil || !hasKey {
+		return nil, fmt.Errorf("The certificate must be a PEM bundle with both the certificate and the private key")
+	}
+	return cert, nil
+}
+
+func (m *Cert) validateName(certName string) error {
+	if len(certName) == 0 || strings.ContainsAny(certName, `/\`) || strings.HasPrefix(certName, ".") {
+		return fmt.Errorf("The certificate name %s is not valid", certName)
+	}
+	return nil
+}
+
+func (m *Cert) getDir() string {
+	return fmt.Sprintf("%s/certs", m.ConfigsPath)
+}
+
+func (m *Cert) getPath(certName string) string {
+	return fmt.Sprintf("%s/%s.pem", m.getDir(), certName)
+}
@@ -0,0 +1,295 @@
+// +build !integration
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+type CertTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Pem      []byte
+	NotAfter time.Time
+	cert     *Cert
+	registry *RegistryMock
+}
+
+func (s *CertTestSuite) SetupTest() {
+	s.ConfigsPath, _ = ioutil.TempDir("", "certs")
+	s.TemplatesPath = "test_configs/tmpl"
+	readConfigsFile = ioutil.ReadFile
+	readConfigsDir = ioutil.ReadDir
+	writeFile = ioutil.WriteFile
+	osRemove = os.Remove
+	s.cert = NewCert(s.BaseReconfigure).(*Cert)
+	s.registry = getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return s.registry, nil
+	}
+	proxy = getProxyMock("")
+}
+
+func (s *CertTestSuite) TearDownTest() {
+	os.RemoveAll(s.ConfigsPath)
+}
+
+// Put
+
+func (s CertTestSuite) Test_Put_WritesCertificate() {
+	err := s.cert.Put("my-cert", s.Pem)
+
+	s.NoError(err)
+	actual, _ := ioutil.ReadFile(fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath))
+	s.Equal(s.Pem, actual)
+}
+
+func (s CertTestSuite) Test_Put_ReturnsError_WhenCertificateCannotBeWritten() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	err := s.cert.Put("my-cert", s.Pem)
+
+	s.Error(err)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s CertTestSuite) Test_Put_ReloadsProxy() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	s.cert.Put("my-cert", s.Pem)
+
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s CertTestSuite) Test_Put_StoresCertificateInRegistry() {
+	s.cert.Put("my-cert", s.Pem)
+
+	s.registry.AssertCalled(s.T(), "PutCert", "my-cert", s.Pem)
+}
+
+func (s CertTestSuite) Test_Put_ReturnsError_WhenRegistryFails() {
+	s.registry = getRegistryMock("PutCert")
+	s.registry.On("PutCert", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return s.registry, nil
+	}
+
+	s.Error(s.cert.Put("my-cert", s.Pem))
+}
+
+func (s CertTestSuite) Test_Put_ReturnsError_WhenContentIsNotPemBundle() {
+	block, _ := pem.Decode(s.Pem)
+	certOnly := pem.EncodeToMemory(block)
+
+	s.Error(s.cert.Put("my-cert", []byte("this is not a certificate")))
+	s.Error(s.cert.Put("my-cert", certOnly))
+}
+
+func (s CertTestSuite) Test_Put_ReturnsError_WhenNameIsNotValid() {
+	for _, name := range []string{"", "../my-cert", "my/cert", ".hidden"} {
+		s.Error(s.cert.Put(name, s.Pem))
+	}
+}
+
+func (s CertTestSuite) Test_Put_RemovesCertificate_WhenProxyConfigIsNotValid() {
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	err := s.cert.Put("my-cert", s.Pem)
+
+	s.Error(err)
+	_, err = os.Stat(fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath))
+	s.True(os.IsNotExist(err))
+	mockObj.AssertNotCalled(s.T(), "Reload")
+	s.registry.AssertNotCalled(s.T(), "PutCert", mock.Anything, mock.Anything)
+}
+
+// GetAll
+
+func (s CertTestSuite) Test_GetAll_ReturnsCertificates() {
+	s.cert.Put("my-cert", s.Pem)
+	expected := []CertInfo{{
+		Name:       "my-cert",
+		CommonName: "my-domain.com",
+		DNSNames:   []string{"my-domain.com", "www.my-domain.com"},
+		NotAfter:   s.NotAfter,
+	}}
+
+	actual, _ := s.cert.GetAll()
+
+	s.Equal(expected, actual)
+}
+
+func (s CertTestSuite) Test_GetAll_ReturnsEmptyList_WhenThereAreNoCertificates() {
+	actual, err := s.cert.GetAll()
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+// Delete
+
+func (s CertTestSuite) Test_Delete_RemovesCertificate() {
+	s.cert.Put("my-cert", s.Pem)
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	err := s.cert.Delete("my-cert")
+
+	s.NoError(err)
+	_, err = os.Stat(fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath))
+	s.True(os.IsNotExist(err))
+	mockObj.AssertCalled(s.T(), "Reload")
+	s.registry.AssertCalled(s.T(), "DeleteCert", "my-cert")
+}
+
+func (s CertTestSuite) Test_Delete_ReturnsError_WhenCertificateDoesNotExist() {
+	err := s.cert.Delete("my-cert")
+
+	s.Error(err)
+}
+
+func (s CertTestSuite) Test_Delete_RestoresCertificate_WhenProxyConfigIsNotValid() {
+	s.cert.Put("my-cert", s.Pem)
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	err := s.cert.Delete("my-cert")
+
+	s.Error(err)
+	actual, _ := ioutil.ReadFile(fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath))
+	s.Equal(s.Pem, actual)
+}
+
+// Restore
+
+func (s CertTestSuite) Test_Restore_WritesCertificatesFromRegistry() {
+	s.registry = getRegistryMock("GetCerts")
+	s.registry.On("GetCerts").Return(map[string][]byte{"my-cert": s.Pem, "../invalid": s.Pem}, nil)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return s.registry, nil
+	}
+
+	err := s.cert.Restore()
+
+	s.NoError(err)
+	actual, _ := ioutil.ReadFile(fmt.Sprintf("%s/certs/my-cert.pem", s.ConfigsPath))
+	s.Equal(s.Pem, actual)
+	files, _ := ioutil.ReadDir(fmt.Sprintf("%s/certs", s.ConfigsPath))
+	s.Len(files, 1)
+}
+
+func (s CertTestSuite) Test_Restore_DoesNotReloadProxy() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	s.cert.Restore()
+
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s CertTestSuite) Test_Restore_ReturnsError_WhenRegistryFails() {
+	s.registry = getRegistryMock("GetCerts")
+	s.registry.On("GetCerts").Return(map[string][]byte{}, fmt.Errorf("This is an error"))
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return s.registry, nil
+	}
+
+	s.Error(s.cert.Restore())
+}
+
+// Suite
+
+func TestCertTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	proxyOrig := proxy
+	newRegistryOrig := NewRegistry
+	defer func() {
+		proxy = proxyOrig
+		NewRegistry = newRegistryOrig
+	}()
+	s := new(CertTestSuite)
+	s.NotAfter = time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	s.Pem = getTestPem("my-domain.com", s.NotAfter)
+	suite.Run(t, s)
+}
+
+// Util
+
+func getTestPem(domain string, notAfter time.Time) []byte {
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain, fmt.Sprintf("www.%s", domain)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return append(certPem, keyPem...)
+}
+
+// Mock
+
+type CertMock struct {
+	mock.Mock
+}
+
+func (m *CertMock) Put(certName string, content []byte) error {
+	params := m.Called(certName, content)
+	return params.Error(0)
+}
+
+func (m *CertMock) GetAll() ([]CertInfo, error) {
+	params := m.Called()
+	return params.Get(0).([]CertInfo), params.Error(1)
+}
+
+func (m *CertMock) Delete(certName string) error {
+	params := m.Called(certName)
+	return params.Error(0)
+}
+
+func (m *CertMock) Restore() error {
+	params := m.Called()
+	return params.Error(0)
+}
+
+func getCertMock(skipMethod string) *CertMock {
+	mockObj := new(CertMock)
+	if skipMethod != "Put" {
+		mockObj.On("Put", mock.Anything, mock.Anything).Return(nil)
+	}
+	if skipMethod != "GetAll" {
+		mockObj.On("GetAll").Return([]CertInfo{}, nil)
+	}
+	if skipMethod != "Delete" {
+		mockObj.On("Delete", mock.Anything).Return(nil)
+	}
+	if skipMethod != "Restore" {
+		mockObj.On("Restore").Return(nil)
+	}
+	return mockObj
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const CONSUL_CERTS_PREFIX = "docker-flow-certs/"
+
+type ConsulRegistry struct {
+	Address string
+}
+
+type consulTxnOperation struct {
+	Verb  string
+	Key   string
+	Value []byte
+}
+
+func (m *ConsulRegistry) GetKey(key string) (string, error) {
+	addr := fmt.Sprintf("%s/v1/kv/%s?raw", m.Address, strings.TrimLeft(key, "/"))
+	resp, err := http.Get(addr)
+	if err != nil {
+		return "", fmt.Errorf("Could not retrieve the key %s from Consul running on %s\n%s", key, m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Could not find the key %s in Consul running on %s", key, m.Address)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func (m *ConsulRegistry) GetServiceAttribute(serviceName, key string) (string, bool) {
+	value, err := m.GetKey(fmt.Sprintf("docker-flow/%s/%s", serviceName, key))
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// PutServiceAttributes stores all attributes of a service in a single Consul transaction so that the service is never left partially written.
+func (m *ConsulRegistry) PutServiceAttributes(serviceName string, attributes map[string]string) error {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	operations := []consulTxnOperation{}
+	for _, key := range keys {
+		operations = append(operations, consulTxnOperation{
+			Verb:  "set",
+			Key:   fmt.Sprintf("docker-flow/%s/%s", serviceName, key),
+			Value: []byte(attributes[key]),
+		})
+	}
+	if err := m.sendTxn(operations); err != nil {
+		return fmt.Errorf("Could not store the service %s\n%s", serviceName, err.Error())
+	}
+	return nil
+}
+
+func (m *ConsulRegistry) DeleteService(serviceName string) error {
+	addr := fmt.Sprintf("%s/v1/kv/docker-flow/%s/?recurse", m.Address, serviceName)
+	request, _ := http.NewRequest("DELETE", addr, nil)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Could not delete the service %s from Consul running on %s\n%s", serviceName, m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Consul running on %s returned status %d while deleting the service %s", m.Address, resp.StatusCode, serviceName)
+	}
+	return nil
+}
+
+func (m *ConsulRegistry) GetServices() ([]string, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/v1/catalog/services", m.Address))
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the list of services from Consul running on %s\n%s", m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var data map[string]interface{}
+	json.Unmarshal(body, &data)
+	services := []string{}
+	for name := range data {
+		services = append(services, name)
+	}
+	return services, nil
+}
+
+func (m *ConsulRegistry) GetInstances(serviceName, tag string) ([]ServiceInstance, error) {
+	addr := fmt.Sprintf("%s/v1/health/service/%s", m.Address, url.QueryEscape(serviceName))
+	if len(tag) > 0 {
+		addr = fmt.Sprintf("%s?tag=%s", addr, url.QueryEscape(tag))
+	}
+	resp, err := http.Get(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the service %s from Consul running on %s\n%s", serviceName, m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Consul running on %s returned status %d for the service %s", m.Address, resp.StatusCode, serviceName)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return m.parseHealthEntries(body)
+}
+
+func (m *ConsulRegistry) PutCert(certName string, content []byte) error {
+	addr := fmt.Sprintf("%s/v1/kv/%s%s", m.Address, CONSUL_CERTS_PREFIX, certName)
+	request, _ := http.NewRequest("PUT", addr, bytes.NewReader(content))
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Could not send the certificate %s to Consul running on %s\n%s", certName, m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Consul running on %s returned status %d while storing the certificate %s", m.Address, resp.StatusCode, certName)
+	}
+	return nil
+}
+
+func (m *ConsulRegistry) GetCerts() (map[string][]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/v1/kv/%s?recurse", m.Address, CONSUL_CERTS_PREFIX))
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve certificates from Consul running on %s\n%s", m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	certs := map[string][]byte{}
+	if resp.StatusCode == http.StatusNotFound {
+		return certs, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Consul running on %s returned status %d while retrieving certificates", m.Address, resp.StatusCode)
+	}
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("Could not parse the response from Consul\n%s", err.Error())
+	}
+	for _, e := range entries {
+		name := strings.TrimPrefix(e.Key, CONSUL_CERTS_PREFIX)
+		if len(name) > 0 && !strings.Contains(name, "/") {
+			certs[name] = e.Value
+		}
+	}
+	return certs, nil
+}
+
+func (m *ConsulRegistry) DeleteCert(certName string) error {
+	addr := fmt.Sprintf("%s/v1/kv/%s%s", m.Address, CONSUL_CERTS_PREFIX, certName)
+	request, _ := http.NewRequest("DELETE", addr, nil)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Could not delete the certificate %s from Consul running on %s\n%s", certName, m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Consul running on %s returned status %d while deleting the certificate %s", m.Address, resp.StatusCode, certName)
+	}
+	return nil
+}
+
+func (m *ConsulRegistry) sendTxn(operations []consulTxnOperation) error {
+	data := []map[string]consulTxnOperation{}
+	for _, o := range operations {
+		data = append(data, map[string]consulTxnOperation{"KV": o})
+	}
+	content, _ := json.Marshal(data)
+	request, _ := http.NewRequest("PUT", fmt.Sprintf("%s/v1/txn", m.Address), bytes.NewReader(content))
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Could not send data to Consul running on %s\n%s", m.Address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		var data struct {
+			Errors []struct {
+				What string
+			}
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &data)
+		messages := []string{}
+		for _, e := range data.Errors {
+			messages = append(messages, e.What)
+		}
+		return fmt.Errorf("Consul running on %s rolled back the transaction\n%s", m.Address, strings.Join(messages, "\n"))
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Consul running on %s returned status %d", m.Address, resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *ConsulRegistry) parseHealthEntries(body []byte) ([]ServiceInstance, error) {
+	var entries []struct {
+		Node struct {
+			Node    string
+			Address string
+		}
+		Service struct {
+			ID      string
+			Service string
+			Tags    []string
+			Address string
+			Port    int
+		}
+		Checks []struct {
+			Status string
+		}
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("Could not parse the response from Consul\n%s", err.Error())
+	}
+	instances := []ServiceInstance{}
+	for _, e := range entries {
+		instance := ServiceInstance{
+			ID:      e.Service.ID,
+			Name:    e.Service.Service,
+			Node:    e.Node.Node,
+			Address: e.Service.Address,
+			Port:    e.Service.Port,
+			Tags:    e.Service.Tags,
+			Status:  "passing",
+		}
+		if len(instance.Address) == 0 {
+			instance.Address = e.Node.Address
+		}
+		for _, check := range e.Checks {
+			if check.Status == "critical" {
+				instance.Status = "critical"
+			} else if check.Status == "warning" && instance.Status == "passing" {
+				instance.Status = "warning"
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
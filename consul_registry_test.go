@@ -0,0 +1,290 @@
+// +build !integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type ConsulRegistryTestSuite struct {
+	suite.Suite
+	Server   *httptest.Server
+	registry *ConsulRegistry
+	Puts     map[string]string
+	Deletes  []string
+	TxnPuts  int
+	mu       *sync.Mutex
+}
+
+func (s *ConsulRegistryTestSuite) SetupTest() {
+	s.mu.Lock()
+	s.Puts = map[string]string{}
+	s.Deletes = []string{}
+	s.TxnPuts = 0
+	s.mu.Unlock()
+	s.registry = &ConsulRegistry{Address: s.Server.URL}
+}
+
+// GetKey
+
+func (s ConsulRegistryTestSuite) Test_GetKey_ReturnsValue() {
+	actual, _ := s.registry.GetKey("docker-flow/go-demo/path")
+
+	s.Equal("/demo", actual)
+}
+
+func (s ConsulRegistryTestSuite) Test_GetKey_ReturnsError_WhenKeyDoesNotExist() {
+	_, err := s.registry.GetKey("this/key/does/not/exist")
+
+	s.Error(err)
+}
+
+// GetServiceAttribute
+
+func (s ConsulRegistryTestSuite) Test_GetServiceAttribute_ReturnsValue() {
+	actual, ok := s.registry.GetServiceAttribute("go-demo", PATH_KEY)
+
+	s.True(ok)
+	s.Equal("/demo", actual)
+}
+
+func (s ConsulRegistryTestSuite) Test_GetServiceAttribute_ReturnsFalse_WhenConsulIsNotAvailable() {
+	registry := ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	_, ok := registry.GetServiceAttribute("go-demo", PATH_KEY)
+
+	s.False(ok)
+}
+
+// PutServiceAttributes
+
+func (s *ConsulRegistryTestSuite) Test_PutServiceAttributes_PutsAllAttributes() {
+	s.registry.PutServiceAttributes("go-demo", map[string]string{PATH_KEY: "/demo", COLOR_KEY: "blue"})
+
+	s.Equal(map[string]string{
+		"/v1/kv/docker-flow/go-demo/path":  "/demo",
+		"/v1/kv/docker-flow/go-demo/color": "blue",
+	}, s.Puts)
+}
+
+func (s *ConsulRegistryTestSuite) Test_PutServiceAttributes_SendsSingleTransaction() {
+	err := s.registry.PutServiceAttributes("go-demo", map[string]string{PATH_KEY: "/demo", COLOR_KEY: "blue", PORT_KEY: "1234"})
+
+	s.NoError(err)
+	s.Equal(1, s.TxnPuts)
+}
+
+func (s *ConsulRegistryTestSuite) Test_PutServiceAttributes_ReturnsError_WhenTransactionIsRolledBack() {
+	err := s.registry.PutServiceAttributes("rolled-back", map[string]string{PATH_KEY: "/demo"})
+
+	s.Error(err)
+	s.Contains(err.Error(), "permission denied")
+	s.Empty(s.Puts)
+}
+
+func (s *ConsulRegistryTestSuite) Test_PutServiceAttributes_ReturnsError_WhenConsulReturnsStatusOtherThanOK() {
+	err := s.registry.PutServiceAttributes("not-allowed", map[string]string{PATH_KEY: "/demo"})
+
+	s.Error(err)
+	s.Empty(s.Puts)
+}
+
+func (s ConsulRegistryTestSuite) Test_PutServiceAttributes_ReturnsError_WhenConsulIsNotAvailable() {
+	registry := ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	err := registry.PutServiceAttributes("go-demo", map[string]string{PATH_KEY: "/demo"})
+
+	s.Error(err)
+}
+
+// DeleteService
+
+func (s *ConsulRegistryTestSuite) Test_DeleteService_DeletesKeysRecursively() {
+	err := s.registry.DeleteService("go-demo")
+
+	s.NoError(err)
+	s.Equal([]string{"/v1/kv/docker-flow/go-demo/?recurse"}, s.Deletes)
+}
+
+func (s *ConsulRegistryTestSuite) Test_DeleteService_KeepsCertificates() {
+	s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	for _, name := range []string{"c", "cert", "certs"} {
+		s.registry.DeleteService(name)
+	}
+
+	s.Equal(map[string]string{"/v1/kv/docker-flow-certs/my-cert": "my-pem"}, s.Puts)
+}
+
+func (s *ConsulRegistryTestSuite) Test_DeleteService_KeepsServicesWithTheSamePrefix() {
+	s.registry.PutServiceAttributes("go", map[string]string{PATH_KEY: "/go"})
+	s.registry.PutServiceAttributes("go-demo", map[string]string{PATH_KEY: "/demo"})
+
+	err := s.registry.DeleteService("go")
+
+	s.NoError(err)
+	s.Equal(map[string]string{"/v1/kv/docker-flow/go-demo/path": "/demo"}, s.Puts)
+}
+
+func (s ConsulRegistryTestSuite) Test_DeleteService_ReturnsError_WhenConsulIsNotAvailable() {
+	registry := ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	err := registry.DeleteService("go-demo")
+
+	s.Error(err)
+}
+
+// GetServices
+
+func (s ConsulRegistryTestSuite) Test_GetServices_ReturnsCatalogServices() {
+	actual, _ := s.registry.GetServices()
+
+	s.ElementsMatch([]string{"consul", "go-demo"}, actual)
+}
+
+func (s ConsulRegistryTestSuite) Test_GetServices_ReturnsError_WhenConsulIsNotAvailable() {
+	registry := ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	_, err := registry.GetServices()
+
+	s.Error(err)
+}
+
+// GetInstances
+
+func (s ConsulRegistryTestSuite) Test_GetInstances_ReturnsInstancesWithStatus() {
+	expected := []ServiceInstance{
+		{ID: "go-demo-1", Name: "go-demo", Node: "node1", Address: "10.0.0.1", Port: 1111, Status: "passing"},
+		{ID: "go-demo-2", Name: "go-demo", Node: "node2", Address: "10.0.0.22", Port: 2222, Tags: []string{"v1"}, Status: "critical"},
+	}
+
+	actual, _ := s.registry.GetInstances("go-demo", "")
+
+	s.Equal(expected, actual)
+}
+
+func (s ConsulRegistryTestSuite) Test_GetInstances_ReturnsError_WhenStatusIsNotOK() {
+	_, err := s.registry.GetInstances("this-service-does-not-exist", "")
+
+	s.Error(err)
+}
+
+// PutCert
+
+func (s *ConsulRegistryTestSuite) Test_PutCert_PutsCertificateUnderCertsKey() {
+	err := s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	s.NoError(err)
+	s.Equal(map[string]string{"/v1/kv/docker-flow-certs/my-cert": "my-pem"}, s.Puts)
+}
+
+func (s ConsulRegistryTestSuite) Test_PutCert_ReturnsError_WhenConsulIsNotAvailable() {
+	registry := ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	s.Error(registry.PutCert("my-cert", []byte("my-pem")))
+}
+
+// GetCerts
+
+func (s ConsulRegistryTestSuite) Test_GetCerts_ReturnsCertificates() {
+	actual, _ := s.registry.GetCerts()
+
+	s.Equal(map[string][]byte{"my-cert": []byte("my-pem")}, actual)
+}
+
+func (s ConsulRegistryTestSuite) Test_GetCerts_ReturnsEmptyMap_WhenThereAreNoCertificates() {
+	registry := ConsulRegistry{Address: fmt.Sprintf("%s/empty", s.Server.URL)}
+
+	actual, err := registry.GetCerts()
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+// DeleteCert
+
+func (s *ConsulRegistryTestSuite) Test_DeleteCert_DeletesCertificateKey() {
+	err := s.registry.DeleteCert("my-cert")
+
+	s.NoError(err)
+	s.Equal([]string{"/v1/kv/docker-flow-certs/my-cert"}, s.Deletes)
+}
+
+// Suite
+
+func TestConsulRegistryTestSuite(t *testing.T) {
+	s := new(ConsulRegistryTestSuite)
+	s.mu = &sync.Mutex{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path != "/v1/txn" {
+				s.Puts[r.URL.Path] = string(body)
+				return
+			}
+			s.TxnPuts++
+			var operations []struct {
+				KV struct {
+					Verb  string
+					Key   string
+					Value []byte
+				}
+			}
+			json.Unmarshal(body, &operations)
+			for _, o := range operations {
+				if strings.HasPrefix(o.KV.Key, "docker-flow/rolled-back/") {
+					w.WriteHeader(http.StatusConflict)
+					fmt.Fprint(w, `{"Results": null, "Errors": [{"OpIndex": 0, "What": "permission denied"}]}`)
+					return
+				} else if strings.HasPrefix(o.KV.Key, "docker-flow/not-allowed/") {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			for _, o := range operations {
+				s.Puts["/v1/kv/"+o.KV.Key] = string(o.KV.Value)
+			}
+			fmt.Fprint(w, `{"Results": [], "Errors": null}`)
+		case "DELETE":
+			s.Deletes = append(s.Deletes, r.URL.String())
+			_, recurse := r.URL.Query()["recurse"]
+			for key := range s.Puts {
+				if key == r.URL.Path || recurse && strings.HasPrefix(key, r.URL.Path) {
+					delete(s.Puts, key)
+				}
+			}
+		default:
+			switch r.URL.Path {
+			case "/v1/kv/docker-flow/go-demo/path":
+				fmt.Fprint(w, "/demo")
+			case "/v1/kv/docker-flow-certs/":
+				fmt.Fprint(w, `[
+					{"Key": "docker-flow-certs/", "Value": null},
+					{"Key": "docker-flow-certs/my-cert", "Value": "bXktcGVt"}
+				]`)
+			case "/v1/catalog/services":
+				fmt.Fprint(w, `{"consul": [], "go-demo": []}`)
+			case "/v1/health/service/go-demo":
+				fmt.Fprint(w, `[
+					{"Node": {"Node": "node1", "Address": "10.0.0.1"}, "Service": {"ID": "go-demo-1", "Service": "go-demo", "Port": 1111}, "Checks": [{"Status": "passing"}]},
+					{"Node": {"Node": "node2", "Address": "10.0.0.2"}, "Service": {"ID": "go-demo-2", "Service": "go-demo", "Address": "10.0.0.22", "Port": 2222, "Tags": ["v1"]}, "Checks": [{"Status": "warning"}, {"Status": "critical"}]}
+				]`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
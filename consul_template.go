@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+type ConsulTemplate struct {
+	Registry Registry
+}
+
+var NewConsulTemplate = func(registry Registry) *ConsulTemplate {
+	return &ConsulTemplate{Registry: registry}
+}
+
+func (m *ConsulTemplate) Render(content string) (string, error) {
+	funcs := template.FuncMap{
+		"service": m.service,
+		"key":     m.key,
+	}
+	tmpl, err := template.New("consulTemplate").Funcs(funcs).Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("Could not parse the template\n%s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, nil); err != nil {
+		return "", fmt.Errorf("Could not render the template\n%s", err.Error())
+	}
+	return out.String(), nil
+}
+
+// Mimics the consul-template function. The name can be prefixed with a tag (e.g. "v1.my-service").
+func (m *ConsulTemplate) service(name string, options ...string) ([]ServiceInstance, error) {
+	statuses := []string{"passing"}
+	if len(options) > 0 {
+		statuses = options
+	}
+	tag := ""
+	if i := strings.Index(name, "."); i > 0 {
+		tag = name[:i]
+		name = name[i+1:]
+	}
+	instances, err := m.Registry.GetInstances(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	filtered := []ServiceInstance{}
+	for _, instance := range instances {
+		for _, status := range statuses {
+			if status == "any" || status == instance.Status {
+				filtered = append(filtered, instance)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+func (m *ConsulTemplate) key(path string) (string, error) {
+	return m.Registry.GetKey(path)
+}
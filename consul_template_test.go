@@ -0,0 +1,114 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ConsulTemplateTestSuite struct {
+	suite.Suite
+	Server     *httptest.Server
+	ActualTags []string
+}
+
+func (s *ConsulTemplateTestSuite) SetupTest() {
+	s.ActualTags = []string{}
+}
+
+// Render
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsPassingInstances_WhenNoOptions() {
+	content := `{{range service "my-service"}}{{.Node}} {{.Address}}:{{.Port}};{{end}}`
+
+	actual, _ := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Equal("node1 10.0.0.1:1111;", actual)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsAllInstances_WhenAny() {
+	content := `{{range $i, $e := service "my-service" "any"}}{{$e.Node}}_{{$i}} {{$e.Address}}:{{$e.Port}};{{end}}`
+
+	actual, _ := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Equal("node1_0 10.0.0.1:1111;node2_1 10.0.0.22:2222;node3_2 10.0.0.3:3333;", actual)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_FiltersByStatus() {
+	content := `{{range service "my-service" "warning" "critical"}}{{.Node}};{{end}}`
+
+	actual, _ := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Equal("node2;node3;", actual)
+}
+
+func (s *ConsulTemplateTestSuite) Test_Render_SendsTag_WhenServiceNameIsPrefixed() {
+	content := `{{range service "v1.my-service" "any"}}{{.Node}};{{end}}`
+
+	NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Equal([]string{"v1"}, s.ActualTags)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsKeyValue() {
+	content := `timeout {{key "my-service/timeout"}}`
+
+	actual, _ := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Equal("timeout 10s", actual)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsError_WhenKeyDoesNotExist() {
+	content := `{{key "this/key/does/not/exist"}}`
+
+	_, err := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render(content)
+
+	s.Error(err)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsError_WhenServiceCannotBeRetrieved() {
+	content := `{{range service "my-service"}}{{.Node}}{{end}}`
+
+	_, err := NewConsulTemplate(&ConsulRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}).Render(content)
+
+	s.Error(err)
+}
+
+func (s ConsulTemplateTestSuite) Test_Render_ReturnsError_WhenTemplateIsInvalid() {
+	_, err := NewConsulTemplate(&ConsulRegistry{Address: s.Server.URL}).Render("{{range")
+
+	s.Error(err)
+}
+
+// Suite
+
+func TestConsulTemplateTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	s := new(ConsulTemplateTestSuite)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/health/service/my-service":
+			if tag := r.URL.Query().Get("tag"); len(tag) > 0 {
+				s.ActualTags = append(s.ActualTags, tag)
+			}
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, strings.TrimSpace(`[
+				{"Node": {"Node": "node1", "Address": "10.0.0.1"}, "Service": {"Port": 1111}, "Checks": [{"Status": "passing"}]},
+				{"Node": {"Node": "node2", "Address": "10.0.0.2"}, "Service": {"Address": "10.0.0.22", "Port": 2222}, "Checks": [{"Status": "passing"}, {"Status": "warning"}]},
+				{"Node": {"Node": "node3", "Address": "10.0.0.3"}, "Service": {"Port": 3333}, "Checks": [{"Status": "critical"}, {"Status": "warning"}]}
+			]`))
+		case "/v1/kv/my-service/timeout":
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "10s")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
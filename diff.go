@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// getUnifiedDiff returns the difference between two texts in the unified format.
+// An empty string is returned when the texts are the same.
+func getUnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+	lines := getDiffLines(splitDiffLines(from), splitDiffLines(to))
+	out := []string{
+		fmt.Sprintf("--- %s", fromName),
+		fmt.Sprintf("+++ %s", toName),
+	}
+	for start := 0; start < len(lines); {
+		if lines[start].kind == ' ' {
+			start++
+			continue
+		}
+		hunkStart := start - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := start
+		for unchanged := 0; hunkEnd < len(lines) && unchanged <= 2*diffContextLines; hunkEnd++ {
+			if lines[hunkEnd].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for hunkEnd > start && lines[hunkEnd-1].kind == ' ' {
+			hunkEnd--
+		}
+		if hunkEnd += diffContextLines; hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+		out = append(out, getDiffHunk(lines, hunkStart, hunkEnd)...)
+		start = hunkEnd
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func getDiffHunk(lines []diffLine, start, end int) []string {
+	fromLine, toLine := 1, 1
+	for _, line := range lines[:start] {
+		if line.kind != '+' {
+			fromLine++
+		}
+		if line.kind != '-' {
+			toLine++
+		}
+	}
+	fromCount, toCount := 0, 0
+	body := []string{}
+	for _, line := range lines[start:end] {
+		if line.kind != '+' {
+			fromCount++
+		}
+		if line.kind != '-' {
+			toCount++
+		}
+		body = append(body, string(line.kind)+line.text)
+	}
+	if fromCount == 0 {
+		fromLine--
+	}
+	if toCount == 0 {
+		toLine--
+	}
+	header := fmt.Sprintf("@@ -%d,%d +%d,%d @@", fromLine, fromCount, toLine, toCount)
+	return append([]string{header}, body...)
+}
+
+// getDiffLines aligns the lines using the longest common subsequence.
+// The common prefix and suffix are aligned first so that the table covers only the changed part of the texts.
+func getDiffLines(from, to []string) []diffLine {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	lines := []diffLine{}
+	for _, line := range from[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	lines = append(lines, getChangedDiffLines(from[prefix:len(from)-suffix], to[prefix:len(to)-suffix])...)
+	for _, line := range from[len(from)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+func getChangedDiffLines(from, to []string) []diffLine {
+	lines := []diffLine{}
+	if len(from) == 0 || len(to) == 0 {
+		for _, line := range from {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range to {
+			lines = append(lines, diffLine{'+', line})
+		}
+		return lines
+	}
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		if from[i] == to[j] {
+			lines = append(lines, diffLine{' ', from[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, diffLine{'-', from[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, diffLine{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, diffLine{'+', to[j]})
+	}
+	return lines
+}
+
+func splitDiffLines(content string) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -0,0 +1,111 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+// getUnifiedDiff
+
+func (s *DiffTestSuite) Test_GetUnifiedDiff_ReturnsEmptyString_WhenContentIsTheSame() {
+	actual := getUnifiedDiff("a", "b", "line 1\nline 2", "line 1\nline 2")
+
+	s.Empty(actual)
+}
+
+func (s *DiffTestSuite) Test_GetUnifiedDiff_ReturnsChangedLinesWithContext() {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10"
+	to := "1\n2\n3\n4\n5\nfive\n7\n8\n9\n10"
+	expected := `--- haproxy.cfg
++++ haproxy.cfg.new
+@@ -3,7 +3,7 @@
+ 3
+ 4
+ 5
+-6
++five
+ 7
+ 8
+ 9
+`
+
+	actual := getUnifiedDiff("haproxy.cfg", "haproxy.cfg.new", from, to)
+
+	s.Equal(expected, actual)
+}
+
+func (s *DiffTestSuite) Test_GetUnifiedDiff_SplitsDistantChangesIntoHunks() {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12"
+	to := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13"
+	expected := `--- a
++++ b
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+
+	actual := getUnifiedDiff("a", "b", from, to)
+
+	s.Equal(expected, actual)
+}
+
+func (s *DiffTestSuite) Test_GetUnifiedDiff_AddsAllLines_WhenFromIsEmpty() {
+	expected := `--- a
++++ b
+@@ -0,0 +1,2 @@
++1
++2
+`
+
+	actual := getUnifiedDiff("a", "b", "", "1\n2\n")
+
+	s.Equal(expected, actual)
+}
+
+func (s *DiffTestSuite) Test_GetUnifiedDiff_ReturnsChangedLines_WhenContentIsLarge() {
+	lines := []string{}
+	for i := 1; i <= 100000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	from := strings.Join(lines, "\n")
+	lines[49999] = "changed"
+	to := strings.Join(lines, "\n")
+	expected := `--- a
++++ b
+@@ -49997,7 +49997,7 @@
+ line 49997
+ line 49998
+ line 49999
+-line 50000
++changed
+ line 50001
+ line 50002
+ line 50003
+`
+
+	actual := getUnifiedDiff("a", "b", from, to)
+
+	s.Equal(expected, actual)
+}
+
+// Suite
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DOCKER_SERVICE_NAME_LABEL         = "com.df.serviceName"
+	DOCKER_SERVICE_PATH_LABEL         = "com.df.servicePath"
+	DOCKER_SERVICE_DOMAIN_LABEL       = "com.df.serviceDomain"
+	DOCKER_SERVICE_COLOR_LABEL        = "com.df.serviceColor"
+	DOCKER_PATH_TYPE_LABEL            = "com.df.pathType"
+	DOCKER_SKIP_CHECK_LABEL           = "com.df.skipCheck"
+	DOCKER_HTTPS_ONLY_LABEL           = "com.df.httpsOnly"
+	DOCKER_HSTS_LABEL                 = "com.df.hsts"
+	DOCKER_REQ_MODE_LABEL             = "com.df.reqMode"
+	DOCKER_SRC_PORT_LABEL             = "com.df.srcPort"
+	DOCKER_REQ_PATH_SEARCH_LABEL      = "com.df.reqPathSearch"
+	DOCKER_REQ_PATH_REPLACE_LABEL     = "com.df.reqPathReplace"
+	DOCKER_STRIP_PREFIX_LABEL         = "com.df.stripPrefix"
+	DOCKER_USERS_LABEL                = "com.df.users"
+	DOCKER_SKIP_DEFAULT_USERS_LABEL   = "com.df.skipDefaultUsers"
+	DOCKER_CONSUL_TEMPLATE_PATH_LABEL = "com.df.consulTemplatePath"
+	DOCKER_SERVICE_CERT_LABEL         = "com.df.serviceCert"
+)
+
+type DockerListener struct {
+	BaseReconfigure
+	RetryInterval time.Duration
+	address       string
+	client        *http.Client
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+type dockerEvent struct {
+	Type   string
+	Action string
+	Actor  struct {
+		ID         string
+		Attributes map[string]string
+	}
+}
+
+type dockerContainer struct {
+	Id     string
+	Labels map[string]string
+}
+
+var NewDockerListener = func(baseData BaseReconfigure, host string) Watchable {
+	client, address := getDockerClient(host)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DockerListener{
+		BaseReconfigure: baseData,
+		RetryInterval:   5 * time.Second,
+		address:         address,
+		client:          client,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+func (m *DockerListener) Watch() error {
+	logPrintf("Listening to Docker events from %s", m.address)
+	if err := m.registerRunningContainers(); err != nil {
+		logPrintf("Could not register running containers\n%s", err.Error())
+	}
+	for {
+		select {
+		case <-m.ctx.Done():
+			return nil
+		default:
+		}
+		if err := m.listen(); err != nil && m.ctx.Err() == nil {
+			logPrintf("Could not listen to Docker events\n%s", err.Error())
+			select {
+			case <-m.ctx.Done():
+			case <-time.After(m.RetryInterval):
+			}
+		}
+	}
+}
+
+func (m *DockerListener) Stop() {
+	m.cancel()
+}
+
+func (m *DockerListener) listen() error {
+	filters := `{"type":["container"],"event":["start","die"],"label":["` + DOCKER_SERVICE_NAME_LABEL + `"]}`
+	addr := fmt.Sprintf("%s/events?filters=%s", m.address, url.QueryEscape(filters))
+	req, _ := http.NewRequest("GET", addr, nil)
+	resp, err := m.client.Do(req.WithContext(m.ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Docker running on %s returned status %d", m.address, resp.StatusCode)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := dockerEvent{}
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		m.processEvent(event)
+	}
+}
+
+func (m *DockerListener) processEvent(event dockerEvent) {
+	if event.Type != "container" {
+		return
+	}
+	sr, ok := getServiceReconfigureFromLabels(event.Actor.Attributes)
+	if !ok {
+		return
+	}
+	switch event.Action {
+	case "start":
+		logPrintf("Container %s of the service %s started", event.Actor.ID, sr.ServiceName)
+		m.reconfigure(sr)
+	case "die":
+		logPrintf("Container %s of the service %s stopped", event.Actor.ID, sr.ServiceName)
+		containers, err := m.getContainers(sr.ServiceName)
+		if err != nil {
+			logPrintf("Could not retrieve containers of the service %s\n%s", sr.ServiceName, err.Error())
+			return
+		}
+		if len(containers) > 0 {
+			m.reconfigure(sr)
+			return
+		}
+		if err := NewRemove(sr.ServiceName, false, m.getChangeData()).Execute([]string{}); err != nil {
+			logPrintf("Could not remove the service %s\n%s", sr.ServiceName, err.Error())
+		}
+	}
+}
+
+func (m *DockerListener) reconfigure(sr ServiceReconfigure) {
+	if err := NewReconfigure(m.getChangeData(), sr).Execute([]string{}); err != nil {
+		logPrintf("Could not reconfigure the service %s\n%s", sr.ServiceName, err.Error())
+	}
+}
+
+// getChangeData returns the data that makes changes of the listener recorded in the history.
+func (m *DockerListener) getChangeData() BaseReconfigure {
+	return m.BaseReconfigure.withHistoryRequest("Docker listener")
+}
+
+func (m *DockerListener) registerRunningContainers() error {
+	containers, err := m.getContainers("")
+	if err != nil {
+		return err
+	}
+	registered := map[string]bool{}
+	for _, container := range containers {
+		sr, ok := getServiceReconfigureFromLabels(container.Labels)
+		if !ok || registered[sr.ServiceName] {
+			continue
+		}
+		registered[sr.ServiceName] = true
+		m.reconfigure(sr)
+	}
+	return nil
+}
+
+func (m *DockerListener) getContainers(serviceName string) ([]dockerContainer, error) {
+	label := DOCKER_SERVICE_NAME_LABEL
+	if len(serviceName) > 0 {
+		label = fmt.Sprintf("%s=%s", label, serviceName)
+	}
+	filters := `{"label":["` + label + `"]}`
+	addr := fmt.Sprintf("%s/containers/json?filters=%s", m.address, url.QueryEscape(filters))
+	req, _ := http.NewRequest("GET", addr, nil)
+	resp, err := m.client.Do(req.WithContext(m.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker running on %s returned status %d", m.address, resp.StatusCode)
+	}
+	containers := []dockerContainer{}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("Could not parse the list of containers\n%s", err.Error())
+	}
+	return containers, nil
+}
+
+func getServiceReconfigureFromLabels(labels map[string]string) (ServiceReconfigure, bool) {
+	sr := ServiceReconfigure{
+		ServiceName:        labels[DOCKER_SERVICE_NAME_LABEL],
+		ServiceColor:       labels[DOCKER_SERVICE_COLOR_LABEL],
+		ConsulTemplatePath: labels[DOCKER_CONSUL_TEMPLATE_PATH_LABEL],
+		ServiceCert:        labels[DOCKER_SERVICE_CERT_LABEL],
+		PathType:           labels[DOCKER_PATH_TYPE_LABEL],
+		ReqMode:            labels[DOCKER_REQ_MODE_LABEL],
+		SrcPort:            labels[DOCKER_SRC_PORT_LABEL],
+		ReqPathSearch:      labels[DOCKER_REQ_PATH_SEARCH_LABEL],
+		ReqPathReplace:     labels[DOCKER_REQ_PATH_REPLACE_LABEL],
+		StripPrefix:        labels[DOCKER_STRIP_PREFIX_LABEL],
+	}
+	if len(labels[DOCKER_SERVICE_PATH_LABEL]) > 0 {
+		sr.ServicePath = strings.Split(labels[DOCKER_SERVICE_PATH_LABEL], ",")
+	}
+	if len(labels[DOCKER_SERVICE_DOMAIN_LABEL]) > 0 {
+		sr.ServiceDomain = strings.Split(labels[DOCKER_SERVICE_DOMAIN_LABEL], ",")
+	}
+	if len(labels[DOCKER_USERS_LABEL]) > 0 {
+		sr.Users = strings.Split(labels[DOCKER_USERS_LABEL], ",")
+	}
+	if len(labels[DOCKER_SKIP_DEFAULT_USERS_LABEL]) > 0 {
+		sr.SkipDefaultUsers, _ = strconv.ParseBool(labels[DOCKER_SKIP_DEFAULT_USERS_LABEL])
+	}
+	if len(labels[DOCKER_SKIP_CHECK_LABEL]) > 0 {
+		sr.SkipCheck, _ = strconv.ParseBool(labels[DOCKER_SKIP_CHECK_LABEL])
+	}
+	if len(labels[DOCKER_HTTPS_ONLY_LABEL]) > 0 {
+		sr.HttpsOnly, _ = strconv.ParseBool(labels[DOCKER_HTTPS_ONLY_LABEL])
+	}
+	if len(labels[DOCKER_HSTS_LABEL]) > 0 {
+		sr.Hsts, _ = strconv.ParseBool(labels[DOCKER_HSTS_LABEL])
+	}
+	if len(sr.ServiceName) == 0 || !isServiceConfigured(sr) {
+		return sr, false
+	}
+	return sr, true
+}
+
+func getDockerClient(host string) (*http.Client, string) {
+	if strings.HasPrefix(host, "unix://") {
+		socket := strings.TrimPrefix(host, "unix://")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://docker"
+	}
+	address := strings.Replace(host, "tcp://", "http://", 1)
+	if !strings.HasPrefix(address, "http") {
+		address = fmt.Sprintf("http://%s", address)
+	}
+	return &http.Client{}, strings.TrimRight(address, "/")
+}
@@ -0,0 +1,313 @@
+// +build !integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type DockerListenerTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Server       *httptest.Server
+	Labels       map[string]string
+	Containers   string
+	Events       string
+	mu           *sync.Mutex
+	Reconfigured []ServiceReconfigure
+	Removed      []string
+	origReconf   func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable
+	origRemove   func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable
+}
+
+func (s *DockerListenerTestSuite) SetupTest() {
+	s.TemplatesPath = "test_configs/tmpl"
+	s.ConsulAddress = "http://1.2.3.4:1234"
+	s.Labels = map[string]string{
+		"com.df.serviceName":   "go-demo",
+		"com.df.servicePath":   "/demo,/demo2",
+		"com.df.serviceDomain": "my-domain.com",
+		"com.df.serviceColor":  "blue",
+		"com.df.pathType":      "path_reg",
+		"com.df.skipCheck":     "true",
+	}
+	labels, _ := json.Marshal(s.Labels)
+	s.mu.Lock()
+	s.Containers = fmt.Sprintf(`[{"Id": "123", "Labels": %s}]`, labels)
+	s.Events = fmt.Sprintf(`{"Type": "container", "Action": "start", "Actor": {"ID": "456", "Attributes": %s}}`, labels)
+	s.Reconfigured = []ServiceReconfigure{}
+	s.Removed = []string{}
+	s.mu.Unlock()
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Reconfigured = append(s.Reconfigured, serviceData)
+		return getReconfigureMock("")
+	}
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Removed = append(s.Removed, serviceName)
+		return getRemoveMock("")
+	}
+}
+
+func (s *DockerListenerTestSuite) TearDownTest() {
+	NewReconfigure = s.origReconf
+	NewRemove = s.origRemove
+}
+
+// NewDockerListener
+
+func (s *DockerListenerTestSuite) Test_NewDockerListener_ConvertsTcpHost() {
+	l := NewDockerListener(s.BaseReconfigure, "tcp://1.2.3.4:2375").(*DockerListener)
+
+	s.Equal("http://1.2.3.4:2375", l.address)
+}
+
+func (s *DockerListenerTestSuite) Test_NewDockerListener_ConnectsThroughUnixSocket() {
+	dir, _ := ioutil.TempDir("", "docker-listener")
+	defer os.RemoveAll(dir)
+	socket := fmt.Sprintf("%s/docker.sock", dir)
+	listener, err := net.Listen("unix", socket)
+	s.Require().NoError(err)
+	srv := httptest.NewUnstartedServer(s.Server.Config.Handler)
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+	l := NewDockerListener(s.BaseReconfigure, fmt.Sprintf("unix://%s", socket)).(*DockerListener)
+
+	actual, err := l.getContainers("")
+
+	s.NoError(err)
+	s.Len(actual, 1)
+}
+
+// getServiceReconfigureFromLabels
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsServiceData() {
+	expected := ServiceReconfigure{
+		ServiceName:   "go-demo",
+		ServicePath:   []string{"/demo", "/demo2"},
+		ServiceDomain: []string{"my-domain.com"},
+		ServiceColor:  "blue",
+		PathType:      "path_reg",
+		SkipCheck:     true,
+	}
+
+	actual, ok := getServiceReconfigureFromLabels(s.Labels)
+
+	s.True(ok)
+	s.Equal(expected, actual)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsHttpsOnlyAndHsts() {
+	s.Labels["com.df.httpsOnly"] = "true"
+	s.Labels["com.df.hsts"] = "true"
+
+	actual, _ := getServiceReconfigureFromLabels(s.Labels)
+
+	s.True(actual.HttpsOnly)
+	s.True(actual.Hsts)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsPathRewrite() {
+	s.Labels["com.df.reqPathSearch"] = "/demo/"
+	s.Labels["com.df.reqPathReplace"] = "/"
+	s.Labels["com.df.stripPrefix"] = "/api/v1"
+
+	actual, _ := getServiceReconfigureFromLabels(s.Labels)
+
+	s.Equal("/demo/", actual.ReqPathSearch)
+	s.Equal("/", actual.ReqPathReplace)
+	s.Equal("/api/v1", actual.StripPrefix)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsUsers() {
+	s.Labels["com.df.users"] = "admin:$6$salt$hash,other:$1$salt$hash"
+
+	actual, _ := getServiceReconfigureFromLabels(s.Labels)
+
+	s.Equal([]string{"admin:$6$salt$hash", "other:$1$salt$hash"}, actual.Users)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsSkipDefaultUsers() {
+	s.Labels["com.df.skipDefaultUsers"] = "true"
+
+	actual, _ := getServiceReconfigureFromLabels(s.Labels)
+
+	s.True(actual.SkipDefaultUsers)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsTcpService_WhenPathIsMissing() {
+	labels := map[string]string{
+		"com.df.serviceName": "postgres",
+		"com.df.reqMode":     "tcp",
+		"com.df.srcPort":     "5432",
+	}
+
+	actual, ok := getServiceReconfigureFromLabels(labels)
+
+	s.True(ok)
+	s.Equal(ServiceReconfigure{ServiceName: "postgres", ReqMode: "tcp", SrcPort: "5432"}, actual)
+}
+
+func (s *DockerListenerTestSuite) Test_GetServiceReconfigureFromLabels_ReturnsFalse_WhenPathIsMissing() {
+	_, ok := getServiceReconfigureFromLabels(map[string]string{"com.df.serviceName": "go-demo"})
+
+	s.False(ok)
+}
+
+// processEvent
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_ReconfiguresService_WhenContainerStarts() {
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+
+	l.processEvent(s.getEvent("start"))
+
+	s.Len(s.Reconfigured, 1)
+	s.Equal("go-demo", s.Reconfigured[0].ServiceName)
+	s.Empty(s.Removed)
+}
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_RemovesService_WhenLastContainerDies() {
+	s.Containers = "[]"
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+
+	l.processEvent(s.getEvent("die"))
+
+	s.Equal([]string{"go-demo"}, s.Removed)
+	s.Empty(s.Reconfigured)
+}
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_RecordsHistory_WhenContainerStarts() {
+	actual := BaseReconfigure{}
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actual = baseData
+		return getReconfigureMock("")
+	}
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+
+	l.processEvent(s.getEvent("start"))
+
+	s.Equal(s.BaseReconfigure.withHistoryRequest("Docker listener"), actual)
+}
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_RecordsHistory_WhenLastContainerDies() {
+	actual := BaseReconfigure{}
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = baseData
+		return getRemoveMock("")
+	}
+	s.Containers = "[]"
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+
+	l.processEvent(s.getEvent("die"))
+
+	s.Equal(s.BaseReconfigure.withHistoryRequest("Docker listener"), actual)
+}
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_ReconfiguresService_WhenOtherContainersAreRunning() {
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+
+	l.processEvent(s.getEvent("die"))
+
+	s.Len(s.Reconfigured, 1)
+	s.Empty(s.Removed)
+}
+
+func (s *DockerListenerTestSuite) Test_ProcessEvent_IgnoresContainersWithoutLabels() {
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+	event := s.getEvent("start")
+	event.Actor.Attributes = map[string]string{"image": "nginx"}
+
+	l.processEvent(event)
+
+	s.Empty(s.Reconfigured)
+}
+
+// Watch
+
+func (s *DockerListenerTestSuite) Test_Watch_RegistersRunningContainersAndProcessesEvents() {
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+	l.RetryInterval = time.Hour
+	go l.Watch()
+	defer l.Stop()
+
+	s.Eventually(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.Reconfigured) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *DockerListenerTestSuite) Test_Watch_ReturnsAfterStop() {
+	l := NewDockerListener(s.BaseReconfigure, s.Server.URL).(*DockerListener)
+	l.RetryInterval = time.Hour
+	done := make(chan bool)
+	go func() {
+		l.Watch()
+		done <- true
+	}()
+
+	l.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		s.Fail("Watch did not return after Stop")
+	}
+}
+
+// Suite
+
+func TestDockerListenerTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	s := new(DockerListenerTestSuite)
+	s.mu = &sync.Mutex{}
+	s.origReconf = NewReconfigure
+	s.origRemove = NewRemove
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		containers := s.Containers
+		events := s.Events
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/containers/json":
+			if !strings.Contains(r.URL.Query().Get("filters"), "com.df.serviceName") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, containers)
+		case "/events":
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, events)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
+
+// Util
+
+func (s *DockerListenerTestSuite) getEvent(action string) dockerEvent {
+	event := dockerEvent{Type: "container", Action: action}
+	event.Actor.ID = "456"
+	event.Actor.Attributes = s.Labels
+	return event
+}
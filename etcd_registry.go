@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	ETCD_SERVICES_PREFIX  = "docker-flow/"
+	ETCD_INSTANCES_PREFIX = "docker-flow-instances/"
+	ETCD_CERTS_PREFIX     = "docker-flow-certs/"
+)
+
+type EtcdRegistry struct {
+	Address string
+}
+
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (m *EtcdRegistry) GetKey(key string) (string, error) {
+	kvs, err := m.getRange(key, "")
+	if err != nil {
+		return "", err
+	}
+	if len(kvs) == 0 {
+		return "", fmt.Errorf("Could not find the key %s in etcd running on %s", key, m.Address)
+	}
+	return kvs[0].Value, nil
+}
+
+func (m *EtcdRegistry) GetServiceAttribute(serviceName, key string) (string, bool) {
+	value, err := m.GetKey(fmt.Sprintf("%s%s/%s", ETCD_SERVICES_PREFIX, serviceName, key))
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+func (m *EtcdRegistry) PutServiceAttributes(serviceName string, attributes map[string]string) error {
+	for key, value := range attributes {
+		data := map[string]string{
+			"key":   m.encode(fmt.Sprintf("%s%s/%s", ETCD_SERVICES_PREFIX, serviceName, key)),
+			"value": m.encode(value),
+		}
+		if _, err := m.post("/v3/kv/put", data); err != nil {
+			return fmt.Errorf("Could not send data to etcd\n%s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (m *EtcdRegistry) DeleteService(serviceName string) error {
+	prefix := fmt.Sprintf("%s%s/", ETCD_SERVICES_PREFIX, serviceName)
+	data := map[string]string{
+		"key":       m.encode(prefix),
+		"range_end": m.encode(m.getPrefixEnd(prefix)),
+	}
+	if _, err := m.post("/v3/kv/deleterange", data); err != nil {
+		return fmt.Errorf("Could not delete the service %s from etcd\n%s", serviceName, err.Error())
+	}
+	return nil
+}
+
+func (m *EtcdRegistry) GetServices() ([]string, error) {
+	kvs, err := m.getRange(ETCD_SERVICES_PREFIX, m.getPrefixEnd(ETCD_SERVICES_PREFIX))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, kv := range kvs {
+		name := strings.SplitN(strings.TrimPrefix(kv.Key, ETCD_SERVICES_PREFIX), "/", 2)[0]
+		names[name] = true
+	}
+	services := []string{}
+	for name := range names {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// Instances are expected to be stored as JSON under docker-flow-instances/<service>/<id>
+// (e.g. {"Node": "node-1", "Address": "10.0.0.1", "Port": 8080}).
+func (m *EtcdRegistry) GetInstances(serviceName, tag string) ([]ServiceInstance, error) {
+	prefix := fmt.Sprintf("%s%s/", ETCD_INSTANCES_PREFIX, serviceName)
+	kvs, err := m.getRange(prefix, m.getPrefixEnd(prefix))
+	if err != nil {
+		return nil, err
+	}
+	instances := []ServiceInstance{}
+	for _, kv := range kvs {
+		instance := ServiceInstance{}
+		if err := json.Unmarshal([]byte(kv.Value), &instance); err != nil {
+			return nil, fmt.Errorf("Could not parse the instance %s\n%s", kv.Key, err.Error())
+		}
+		if len(instance.ID) == 0 {
+			instance.ID = strings.TrimPrefix(kv.Key, prefix)
+		}
+		if len(instance.Name) == 0 {
+			instance.Name = serviceName
+		}
+		if len(instance.Status) == 0 {
+			instance.Status = "passing"
+		}
+		if len(tag) == 0 || m.hasTag(instance, tag) {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (m *EtcdRegistry) PutCert(certName string, content []byte) error {
+	data := map[string]string{
+		"key":   m.encode(fmt.Sprintf("%s%s", ETCD_CERTS_PREFIX, certName)),
+		"value": m.encode(string(content)),
+	}
+	if _, err := m.post("/v3/kv/put", data); err != nil {
+		return fmt.Errorf("Could not send the certificate %s to etcd\n%s", certName, err.Error())
+	}
+	return nil
+}
+
+func (m *EtcdRegistry) GetCerts() (map[string][]byte, error) {
+	kvs, err := m.getRange(ETCD_CERTS_PREFIX, m.getPrefixEnd(ETCD_CERTS_PREFIX))
+	if err != nil {
+		return nil, err
+	}
+	certs := map[string][]byte{}
+	for _, kv := range kvs {
+		certs[strings.TrimPrefix(kv.Key, ETCD_CERTS_PREFIX)] = []byte(kv.Value)
+	}
+	return certs, nil
+}
+
+func (m *EtcdRegistry) DeleteCert(certName string) error {
+	data := map[string]string{"key": m.encode(fmt.Sprintf("%s%s", ETCD_CERTS_PREFIX, certName))}
+	if _, err := m.post("/v3/kv/deleterange", data); err != nil {
+		return fmt.Errorf("Could not delete the certificate %s from etcd\n%s", certName, err.Error())
+	}
+	return nil
+}
+
+func (m *EtcdRegistry) getRange(key, rangeEnd string) ([]etcdKeyValue, error) {
+	data := map[string]string{"key": m.encode(key)}
+	if len(rangeEnd) > 0 {
+		data["range_end"] = m.encode(rangeEnd)
+	}
+	body, err := m.post("/v3/kv/range", data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not retrieve the key %s from etcd\n%s", key, err.Error())
+	}
+	resp := struct {
+		Kvs []etcdKeyValue `json:"kvs"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("Could not parse the response from etcd\n%s", err.Error())
+	}
+	kvs := []etcdKeyValue{}
+	for _, kv := range resp.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		kvs = append(kvs, etcdKeyValue{Key: string(key), Value: string(value)})
+	}
+	return kvs, nil
+}
+
+func (m *EtcdRegistry) post(path string, data interface{}) ([]byte, error) {
+	js, _ := json.Marshal(data)
+	resp, err := http.Post(fmt.Sprintf("%s%s", m.Address, path), "application/json", bytes.NewReader(js))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd running on %s returned status %d\n%s", m.Address, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+func (m *EtcdRegistry) encode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func (m *EtcdRegistry) getPrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return string(end)
+}
+
+func (m *EtcdRegistry) hasTag(instance ServiceInstance, tag string) bool {
+	for _, t := range instance.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+// +build !integration
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type EtcdRegistryTestSuite struct {
+	suite.Suite
+	Server   *httptest.Server
+	registry *EtcdRegistry
+	Data     map[string]string
+	mu       *sync.Mutex
+}
+
+func (s *EtcdRegistryTestSuite) SetupTest() {
+	s.mu.Lock()
+	s.Data = map[string]string{
+		"docker-flow/go-demo/path":               "/demo",
+		"docker-flow/go-demo/color":              "blue",
+		"docker-flow/books-ms/path":              "/api/v1/books",
+		"docker-flow-instances/go-demo-blue/id1": `{"Node": "node1", "Address": "10.0.0.1", "Port": 1111}`,
+		"docker-flow-instances/go-demo-blue/id2": `{"Node": "node2", "Address": "10.0.0.2", "Port": 2222, "Tags": ["v1"], "Status": "critical"}`,
+		"docker-flow-instances/go-demo-bluex/id": `{"Node": "node3", "Address": "10.0.0.3", "Port": 3333}`,
+		"docker-flow-certs/my-cert":              "my-pem",
+	}
+	s.mu.Unlock()
+	s.registry = &EtcdRegistry{Address: s.Server.URL}
+}
+
+// GetKey
+
+func (s EtcdRegistryTestSuite) Test_GetKey_ReturnsValue() {
+	actual, _ := s.registry.GetKey("docker-flow/go-demo/color")
+
+	s.Equal("blue", actual)
+}
+
+func (s EtcdRegistryTestSuite) Test_GetKey_ReturnsError_WhenKeyDoesNotExist() {
+	_, err := s.registry.GetKey("this/key/does/not/exist")
+
+	s.Error(err)
+}
+
+func (s EtcdRegistryTestSuite) Test_GetKey_ReturnsError_WhenEtcdIsNotAvailable() {
+	registry := EtcdRegistry{Address: "http:///THIS/URL/DOES/NOT/EXIST"}
+
+	_, err := registry.GetKey("docker-flow/go-demo/color")
+
+	s.Error(err)
+}
+
+// GetServiceAttribute
+
+func (s EtcdRegistryTestSuite) Test_GetServiceAttribute_ReturnsValue() {
+	actual, ok := s.registry.GetServiceAttribute("go-demo", PATH_KEY)
+
+	s.True(ok)
+	s.Equal("/demo", actual)
+}
+
+func (s EtcdRegistryTestSuite) Test_GetServiceAttribute_ReturnsFalse_WhenKeyDoesNotExist() {
+	_, ok := s.registry.GetServiceAttribute("go-demo", DOMAIN_KEY)
+
+	s.False(ok)
+}
+
+// PutServiceAttributes
+
+func (s *EtcdRegistryTestSuite) Test_PutServiceAttributes_PutsAllAttributes() {
+	s.registry.PutServiceAttributes("my-service", map[string]string{PATH_KEY: "/my", COLOR_KEY: "green"})
+
+	s.Equal("/my", s.Data["docker-flow/my-service/path"])
+	s.Equal("green", s.Data["docker-flow/my-service/color"])
+}
+
+// DeleteService
+
+func (s *EtcdRegistryTestSuite) Test_DeleteService_DeletesAllServiceKeys() {
+	s.registry.DeleteService("go-demo")
+
+	s.NotContains(s.Data, "docker-flow/go-demo/path")
+	s.NotContains(s.Data, "docker-flow/go-demo/color")
+	s.Contains(s.Data, "docker-flow/books-ms/path")
+}
+
+// GetServices
+
+func (s EtcdRegistryTestSuite) Test_GetServices_ReturnsServicesWithDefinitions() {
+	actual, _ := s.registry.GetServices()
+
+	s.Equal([]string{"books-ms", "go-demo"}, actual)
+}
+
+// GetInstances
+
+func (s EtcdRegistryTestSuite) Test_GetInstances_ReturnsServiceInstances() {
+	expected := []ServiceInstance{
+		{ID: "id1", Name: "go-demo-blue", Node: "node1", Address: "10.0.0.1", Port: 1111, Status: "passing"},
+		{ID: "id2", Name: "go-demo-blue", Node: "node2", Address: "10.0.0.2", Port: 2222, Tags: []string{"v1"}, Status: "critical"},
+	}
+
+	actual, _ := s.registry.GetInstances("go-demo-blue", "")
+
+	s.Equal(expected, actual)
+}
+
+func (s EtcdRegistryTestSuite) Test_GetInstances_FiltersByTag() {
+	actual, _ := s.registry.GetInstances("go-demo-blue", "v1")
+
+	s.Len(actual, 1)
+	s.Equal("id2", actual[0].ID)
+}
+
+// PutCert
+
+func (s *EtcdRegistryTestSuite) Test_PutCert_PutsCertificate() {
+	s.registry.PutCert("other-cert", []byte("other-pem"))
+
+	s.Equal("other-pem", s.Data["docker-flow-certs/other-cert"])
+}
+
+// GetCerts
+
+func (s EtcdRegistryTestSuite) Test_GetCerts_ReturnsCertificates() {
+	actual, _ := s.registry.GetCerts()
+
+	s.Equal(map[string][]byte{"my-cert": []byte("my-pem")}, actual)
+}
+
+// DeleteCert
+
+func (s *EtcdRegistryTestSuite) Test_DeleteCert_DeletesCertificate() {
+	s.registry.DeleteCert("my-cert")
+
+	s.NotContains(s.Data, "docker-flow-certs/my-cert")
+	s.Contains(s.Data, "docker-flow/go-demo/path")
+}
+
+// Suite
+
+func TestEtcdRegistryTestSuite(t *testing.T) {
+	s := new(EtcdRegistryTestSuite)
+	s.mu = &sync.Mutex{}
+	decode := func(value string) string {
+		decoded, _ := base64.StdEncoding.DecodeString(value)
+		return string(decoded)
+	}
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		req := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&req)
+		key := decode(req["key"])
+		rangeEnd := decode(req["range_end"])
+		inRange := func(k string) bool {
+			if len(rangeEnd) == 0 {
+				return k == key
+			}
+			return k >= key && k < rangeEnd
+		}
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			keys := []string{}
+			for k := range s.Data {
+				if inRange(k) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			kvs := []map[string]string{}
+			for _, k := range keys {
+				kvs = append(kvs, map[string]string{"key": encode(k), "value": encode(s.Data[k])})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs})
+		case "/v3/kv/put":
+			s.Data[key] = decode(req["value"])
+			w.Write([]byte("{}"))
+		case "/v3/kv/deleterange":
+			for k := range s.Data {
+				if inRange(k) {
+					delete(s.Data, k)
+				}
+			}
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type FileRegistry struct {
+	Path string
+}
+
+type FileRegistryService struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Instances  []string          `json:"instances,omitempty"`
+}
+
+func (m *FileRegistry) GetKey(key string) (string, error) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	if len(parts) == 3 && parts[0] == "docker-flow" {
+		if value, ok := m.GetServiceAttribute(parts[1], parts[2]); ok {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("Could not find the key %s in the registry directory %s", key, m.Path)
+}
+
+func (m *FileRegistry) GetServiceAttribute(serviceName, key string) (string, bool) {
+	service, err := m.getService(serviceName)
+	if err != nil {
+		return "", false
+	}
+	value, ok := service.Attributes[key]
+	return value, ok
+}
+
+func (m *FileRegistry) PutServiceAttributes(serviceName string, attributes map[string]string) error {
+	path, err := m.getFilePath(serviceName)
+	if err != nil {
+		return err
+	}
+	service, err := m.getService(serviceName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if service.Attributes == nil {
+		service.Attributes = map[string]string{}
+	}
+	for key, value := range attributes {
+		service.Attributes[key] = value
+	}
+	js, _ := json.MarshalIndent(service, "", "  ")
+	if err := os.MkdirAll(m.Path, 0755); err != nil {
+		return fmt.Errorf("Could not create the registry directory %s\n%s", m.Path, err.Error())
+	}
+	if err := ioutil.WriteFile(path, js, 0664); err != nil {
+		return fmt.Errorf("Could not write the service %s to the registry directory %s\n%s", serviceName, m.Path, err.Error())
+	}
+	return nil
+}
+
+// Instances are static configuration so only the attributes are removed unless there is nothing else left.
+func (m *FileRegistry) DeleteService(serviceName string) error {
+	path, err := m.getFilePath(serviceName)
+	if err != nil {
+		return err
+	}
+	service, err := m.getService(serviceName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(service.Instances) == 0 {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("Could not remove the service %s from the registry directory %s\n%s", serviceName, m.Path, err.Error())
+		}
+		return nil
+	}
+	service.Attributes = nil
+	js, _ := json.MarshalIndent(service, "", "  ")
+	if err := ioutil.WriteFile(path, js, 0664); err != nil {
+		return fmt.Errorf("Could not write the service %s to the registry directory %s\n%s", serviceName, m.Path, err.Error())
+	}
+	return nil
+}
+
+func (m *FileRegistry) GetServices() ([]string, error) {
+	files, err := ioutil.ReadDir(m.Path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read the registry directory %s\n%s", m.Path, err.Error())
+	}
+	services := []string{}
+	for _, fi := range files {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".json") {
+			services = append(services, strings.TrimSuffix(fi.Name(), ".json"))
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+func (m *FileRegistry) GetInstances(serviceName, tag string) ([]ServiceInstance, error) {
+	instances := []ServiceInstance{}
+	if len(tag) > 0 {
+		return instances, nil
+	}
+	service, err := m.getService(serviceName)
+	if os.IsNotExist(err) {
+		return instances, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, address := range service.Instances {
+		host, port := address, 0
+		if i := strings.LastIndex(address, ":"); i > 0 {
+			host = address[:i]
+			p, err := strconv.Atoi(address[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("The address %s of the service %s is not valid", address, serviceName)
+			}
+			port = p
+		}
+		instances = append(instances, ServiceInstance{
+			ID:      fmt.Sprintf("%s-%d", serviceName, i),
+			Name:    serviceName,
+			Node:    strings.Replace(host, ".", "-", -1),
+			Address: host,
+			Port:    port,
+			Status:  "passing",
+		})
+	}
+	return instances, nil
+}
+
+func (m *FileRegistry) PutCert(certName string, content []byte) error {
+	if err := os.MkdirAll(m.getCertsDir(), 0755); err != nil {
+		return fmt.Errorf("Could not create the registry directory %s\n%s", m.getCertsDir(), err.Error())
+	}
+	if err := ioutil.WriteFile(m.getCertPath(certName), content, 0600); err != nil {
+		return fmt.Errorf("Could not write the certificate %s to the registry directory %s\n%s", certName, m.Path, err.Error())
+	}
+	return nil
+}
+
+func (m *FileRegistry) GetCerts() (map[string][]byte, error) {
+	certs := map[string][]byte{}
+	files, err := ioutil.ReadDir(m.getCertsDir())
+	if os.IsNotExist(err) {
+		return certs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read the registry directory %s\n%s", m.getCertsDir(), err.Error())
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".pem") {
+			continue
+		}
+		certName := strings.TrimSuffix(fi.Name(), ".pem")
+		content, err := ioutil.ReadFile(m.getCertPath(certName))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the certificate %s from the registry directory %s\n%s", certName, m.Path, err.Error())
+		}
+		certs[certName] = content
+	}
+	return certs, nil
+}
+
+func (m *FileRegistry) DeleteCert(certName string) error {
+	if err := os.Remove(m.getCertPath(certName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not remove the certificate %s from the registry directory %s\n%s", certName, m.Path, err.Error())
+	}
+	return nil
+}
+
+func (m *FileRegistry) getService(serviceName string) (FileRegistryService, error) {
+	service := FileRegistryService{}
+	path, err := m.getFilePath(serviceName)
+	if err != nil {
+		return service, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return service, err
+	}
+	if err := json.Unmarshal(content, &service); err != nil {
+		return service, fmt.Errorf("Could not parse the registry file of the service %s\n%s", serviceName, err.Error())
+	}
+	return service, nil
+}
+
+// Service names become file names so they must not point outside the registry directory.
+func (m *FileRegistry) getFilePath(serviceName string) (string, error) {
+	if len(serviceName) == 0 || strings.ContainsAny(serviceName, `/\`) || strings.HasPrefix(serviceName, ".") {
+		return "", fmt.Errorf("The service name %s is not valid", serviceName)
+	}
+	return fmt.Sprintf("%s/%s.json", m.Path, serviceName), nil
+}
+
+func (m *FileRegistry) getCertsDir() string {
+	return fmt.Sprintf("%s/certs", m.Path)
+}
+
+func (m *FileRegistry) getCertPath(certName string) string {
+	return fmt.Sprintf("%s/%s.pem", m.getCertsDir(), certName)
+}
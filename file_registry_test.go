@@ -0,0 +1,231 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type FileRegistryTestSuite struct {
+	suite.Suite
+	Path     string
+	registry *FileRegistry
+}
+
+func (s *FileRegistryTestSuite) SetupTest() {
+	s.Path, _ = ioutil.TempDir("", "file-registry")
+	s.registry = &FileRegistry{Path: s.Path}
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.json", s.Path), []byte(`{
+  "attributes": {"path": "/demo", "color": "blue"},
+  "instances": ["10.0.0.1:1111", "10.0.0.2:2222"]
+}`), 0664)
+}
+
+func (s *FileRegistryTestSuite) TearDownTest() {
+	os.RemoveAll(s.Path)
+}
+
+// GetKey
+
+func (s FileRegistryTestSuite) Test_GetKey_ReturnsServiceAttribute() {
+	actual, _ := s.registry.GetKey("docker-flow/go-demo/color")
+
+	s.Equal("blue", actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetKey_ReturnsError_WhenKeyDoesNotExist() {
+	_, err := s.registry.GetKey("this/key/does/not/exist")
+
+	s.Error(err)
+}
+
+// GetServiceAttribute
+
+func (s FileRegistryTestSuite) Test_GetServiceAttribute_ReturnsValue() {
+	actual, ok := s.registry.GetServiceAttribute("go-demo", PATH_KEY)
+
+	s.True(ok)
+	s.Equal("/demo", actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetServiceAttribute_ReturnsFalse_WhenServiceDoesNotExist() {
+	_, ok := s.registry.GetServiceAttribute("this-service-does-not-exist", PATH_KEY)
+
+	s.False(ok)
+}
+
+// PutServiceAttributes
+
+func (s FileRegistryTestSuite) Test_PutServiceAttributes_CreatesServiceFile() {
+	s.registry.PutServiceAttributes("books-ms", map[string]string{PATH_KEY: "/api/v1/books"})
+
+	actual, _ := s.registry.GetServiceAttribute("books-ms", PATH_KEY)
+	s.Equal("/api/v1/books", actual)
+}
+
+func (s FileRegistryTestSuite) Test_PutServiceAttributes_KeepsInstances() {
+	s.registry.PutServiceAttributes("go-demo", map[string]string{PATH_KEY: "/demo/hello"})
+
+	path, _ := s.registry.GetServiceAttribute("go-demo", PATH_KEY)
+	color, _ := s.registry.GetServiceAttribute("go-demo", COLOR_KEY)
+	instances, _ := s.registry.GetInstances("go-demo", "")
+	s.Equal("/demo/hello", path)
+	s.Equal("blue", color)
+	s.Len(instances, 2)
+}
+
+func (s FileRegistryTestSuite) Test_PutServiceAttributes_ReturnsError_WhenServiceNameIsNotValid() {
+	for _, name := range []string{"../go-demo", "go/demo", `go\demo`, "..", ""} {
+		err := s.registry.PutServiceAttributes(name, map[string]string{PATH_KEY: "/demo"})
+
+		s.Error(err, name)
+	}
+	_, err := os.Stat(fmt.Sprintf("%s/../go-demo.json", s.Path))
+	s.True(os.IsNotExist(err))
+}
+
+func (s FileRegistryTestSuite) Test_PutServiceAttributes_CreatesDirectory() {
+	registry := FileRegistry{Path: fmt.Sprintf("%s/sub/dir", s.Path)}
+
+	err := registry.PutServiceAttributes("books-ms", map[string]string{PATH_KEY: "/api/v1/books"})
+
+	s.NoError(err)
+	s.FileExists(fmt.Sprintf("%s/sub/dir/books-ms.json", s.Path))
+}
+
+// DeleteService
+
+func (s FileRegistryTestSuite) Test_DeleteService_RemovesAttributesAndKeepsInstances() {
+	s.registry.DeleteService("go-demo")
+
+	_, ok := s.registry.GetServiceAttribute("go-demo", PATH_KEY)
+	instances, _ := s.registry.GetInstances("go-demo", "")
+	s.False(ok)
+	s.Len(instances, 2)
+}
+
+func (s FileRegistryTestSuite) Test_DeleteService_RemovesFile_WhenThereAreNoInstances() {
+	s.registry.PutServiceAttributes("books-ms", map[string]string{PATH_KEY: "/api/v1/books"})
+
+	s.registry.DeleteService("books-ms")
+
+	_, err := os.Stat(fmt.Sprintf("%s/books-ms.json", s.Path))
+	s.True(os.IsNotExist(err))
+}
+
+func (s FileRegistryTestSuite) Test_DeleteService_ReturnsError_WhenServiceNameIsNotValid() {
+	err := s.registry.DeleteService("../go-demo")
+
+	s.Error(err)
+}
+
+func (s FileRegistryTestSuite) Test_DeleteService_DoesNotReturnError_WhenServiceDoesNotExist() {
+	err := s.registry.DeleteService("this-service-does-not-exist")
+
+	s.NoError(err)
+}
+
+// GetServices
+
+func (s FileRegistryTestSuite) Test_GetServices_ReturnsAllServiceFiles() {
+	s.registry.PutServiceAttributes("books-ms", map[string]string{PATH_KEY: "/api/v1/books"})
+	ioutil.WriteFile(fmt.Sprintf("%s/README.md", s.Path), []byte("not a service"), 0664)
+
+	actual, _ := s.registry.GetServices()
+
+	s.Equal([]string{"books-ms", "go-demo"}, actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetServices_ReturnsEmptyList_WhenDirectoryDoesNotExist() {
+	registry := FileRegistry{Path: "/this/path/does/not/exist"}
+
+	actual, err := registry.GetServices()
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+// GetInstances
+
+func (s FileRegistryTestSuite) Test_GetInstances_ReturnsStaticAddresses() {
+	expected := []ServiceInstance{
+		{ID: "go-demo-0", Name: "go-demo", Node: "10-0-0-1", Address: "10.0.0.1", Port: 1111, Status: "passing"},
+		{ID: "go-demo-1", Name: "go-demo", Node: "10-0-0-2", Address: "10.0.0.2", Port: 2222, Status: "passing"},
+	}
+
+	actual, _ := s.registry.GetInstances("go-demo", "")
+
+	s.Equal(expected, actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetInstances_ReturnsEmptyList_WhenServiceDoesNotExist() {
+	actual, err := s.registry.GetInstances("this-service-does-not-exist", "")
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetInstances_ReturnsError_WhenPortIsInvalid() {
+	ioutil.WriteFile(fmt.Sprintf("%s/books-ms.json", s.Path), []byte(`{"instances": ["10.0.0.1:abc"]}`), 0664)
+
+	_, err := s.registry.GetInstances("books-ms", "")
+
+	s.Error(err)
+}
+
+// PutCert
+
+func (s FileRegistryTestSuite) Test_PutCert_WritesCertificate() {
+	err := s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	s.NoError(err)
+	actual, _ := ioutil.ReadFile(fmt.Sprintf("%s/certs/my-cert.pem", s.Path))
+	s.Equal("my-pem", string(actual))
+}
+
+func (s FileRegistryTestSuite) Test_PutCert_DoesNotAddService() {
+	s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	actual, _ := s.registry.GetServices()
+
+	s.Equal([]string{"go-demo"}, actual)
+}
+
+// GetCerts
+
+func (s FileRegistryTestSuite) Test_GetCerts_ReturnsCertificates() {
+	s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	actual, _ := s.registry.GetCerts()
+
+	s.Equal(map[string][]byte{"my-cert": []byte("my-pem")}, actual)
+}
+
+func (s FileRegistryTestSuite) Test_GetCerts_ReturnsEmptyMap_WhenThereAreNoCertificates() {
+	actual, err := s.registry.GetCerts()
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+// DeleteCert
+
+func (s FileRegistryTestSuite) Test_DeleteCert_RemovesCertificate() {
+	s.registry.PutCert("my-cert", []byte("my-pem"))
+
+	err := s.registry.DeleteCert("my-cert")
+
+	s.NoError(err)
+	_, err = os.Stat(fmt.Sprintf("%s/certs/my-cert.pem", s.Path))
+	s.True(os.IsNotExist(err))
+}
+
+// Suite
+
+func TestFileRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(FileRegistryTestSuite))
+}
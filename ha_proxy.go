@@ -1,22 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strings"
+	"text/template"
 )
 
-const ServiceTemplateFilename = "service-formatted.ctmpl"
-
 type Proxy interface {
 	RunCmd(extraArgs []string) error
 	CreateConfigFromTemplates(templatesPath string, configsPath string) error
+	RenderConfigFromTemplates(templatesPath string, configsPath string) (string, error)
 	Reload() error
 }
 
 var proxy Proxy = HaProxy{}
 
+var httpsBindRegexp = regexp.MustCompile(`(?m)^(\s*bind \*:443)[ \t]*$`)
+
 type HaProxy struct{}
 
 func (m HaProxy) RunCmd(extraArgs []string) error {
@@ -38,14 +44,125 @@ func (m HaProxy) RunCmd(extraArgs []string) error {
 }
 
 func (m HaProxy) CreateConfigFromTemplates(templatesPath string, configsPath string) error {
-	configsContent, err := m.getConfigs(templatesPath)
+	configsContent, hasCrtList, err := m.getValidConfig(templatesPath, configsPath, configsPath)
 	if err != nil {
 		return err
 	}
+	if hasCrtList {
+		candidatePath := fmt.Sprintf("%s/crt-list.txt.new", configsPath)
+		crtListPath := fmt.Sprintf("%s/crt-list.txt", configsPath)
+		if err := osRename(candidatePath, crtListPath); err != nil {
+			return fmt.Errorf("Could not move the file %s to %s\n%s", candidatePath, crtListPath, err.Error())
+		}
+	}
 	configPath := fmt.Sprintf("%s/haproxy.cfg", configsPath)
 	return writeFile(configPath, []byte(configsContent), 0664)
 }
 
+// RenderConfigFromTemplates returns the validated configuration without replacing haproxy.cfg or crt-list.txt.
+// Candidates are validated inside a temporary directory so that nothing is written to the configs directory.
+func (m HaProxy) RenderConfigFromTemplates(templatesPath string, configsPath string) (string, error) {
+	tempDir, err := ioutil.TempDir("", "docker-flow-proxy")
+	if err != nil {
+		return "", fmt.Errorf("Could not create a temporary directory\n%s", err.Error())
+	}
+	defer os.RemoveAll(tempDir)
+	configsContent, _, err := m.getValidConfig(templatesPath, configsPath, tempDir)
+	return configsContent, err
+}
+
+// getValidConfig writes the candidate configuration and crt-list into the candidates directory and validates them.
+// The returned configuration refers to crt-list.txt in the configs directory.
+func (m HaProxy) getValidConfig(templatesPath, configsPath, candidatesPath string) (string, bool, error) {
+	configsContent, err := m.getConfigs(templatesPath)
+	if err != nil {
+		return "", false, err
+	}
+	if !m.hasCerts(configsPath) {
+		if err := m.validate(candidatesPath, configsContent); err != nil {
+			return "", false, err
+		}
+		return configsContent, false, nil
+	}
+	crtList, err := m.getCrtList(templatesPath)
+	if err != nil {
+		return "", false, err
+	}
+	candidateCrtListPath := fmt.Sprintf("%s/crt-list.txt.new", candidatesPath)
+	if len(crtList) > 0 {
+		if err := writeFile(candidateCrtListPath, []byte(crtList), 0664); err != nil {
+			return "", false, fmt.Errorf("Could not write the file %s\n%s", candidateCrtListPath, err.Error())
+		}
+	}
+	if err := m.validate(candidatesPath, m.addCerts(configsPath, candidateCrtListPath, crtList, configsContent)); err != nil {
+		if len(crtList) > 0 {
+			osRemove(candidateCrtListPath)
+		}
+		return "", false, err
+	}
+	crtListPath := fmt.Sprintf("%s/crt-list.txt", configsPath)
+	return m.addCerts(configsPath, crtListPath, crtList, configsContent), len(crtList) > 0, nil
+}
+
+func (m HaProxy) hasCerts(configsPath string) bool {
+	certs, err := readConfigsDir(fmt.Sprintf("%s/certs", configsPath))
+	if err != nil {
+		return false
+	}
+	for _, fi := range certs {
+		if strings.HasSuffix(fi.Name(), ".pem") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m HaProxy) addCerts(configsPath, crtListPath, crtList, content string) string {
+	bind := fmt.Sprintf("${1} ssl crt %s/certs", configsPath)
+	if len(crtList) > 0 {
+		bind = fmt.Sprintf("%s crt-list %s", bind, crtListPath)
+	}
+	return httpsBindRegexp.ReplaceAllString(content, bind)
+}
+
+func (m HaProxy) getCrtList(templatesPath string) (string, error) {
+	files, err := readConfigsDir(templatesPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the directory %s\n%s", templatesPath, err.Error())
+	}
+	lines := []string{}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".sni") {
+			continue
+		}
+		content, err := readConfigsFile(fmt.Sprintf("%s/%s", templatesPath, fi.Name()))
+		if err != nil {
+			return "", fmt.Errorf("Could not read the file %s\n%s", fi.Name(), err.Error())
+		}
+		lines = append(lines, strings.TrimSpace(string(content)))
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func (m HaProxy) validate(configsPath, content string) error {
+	path := fmt.Sprintf("%s/haproxy.cfg.new", configsPath)
+	if err := writeFile(path, []byte(content), 0664); err != nil {
+		return fmt.Errorf("Could not write the file %s\n%s", path, err.Error())
+	}
+	defer osRemove(path)
+	var out bytes.Buffer
+	cmd := exec.Command("haproxy", "-c", "-f", path)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmdRunHa(cmd); err != nil {
+		return fmt.Errorf("The proxy configuration is not valid\n%s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
 func (m HaProxy) Reload() error {
 	logPrintf("Reloading the proxy")
 	pidPath := "/var/run/haproxy.pid"
@@ -60,6 +177,7 @@ func (m HaProxy) Reload() error {
 func (m HaProxy) getConfigs(templatesPath string) (string, error) {
 	content := []string{}
 	configsFiles := []string{"haproxy.tmpl"}
+	frontendFiles := []string{}
 	configs, err := readConfigsDir(templatesPath)
 	if err != nil {
 		return "", fmt.Errorf("Could not read the directory %s\n%s", templatesPath, err.Error())
@@ -67,6 +185,8 @@ func (m HaProxy) getConfigs(templatesPath string) (string, error) {
 	for _, fi := range configs {
 		if strings.HasSuffix(fi.Name(), ".cfg") {
 			configsFiles = append(configsFiles, fi.Name())
+		} else if strings.HasSuffix(fi.Name(), ".fe") {
+			frontendFiles = append(frontendFiles, fi.Name())
 		}
 	}
 	for _, file := range configsFiles {
@@ -74,8 +194,22 @@ func (m HaProxy) getConfigs(templatesPath string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("Could not read the file %s\n%s", file, err.Error())
 		}
+		if file == "haproxy.tmpl" {
+			rendered, err := m.renderSettings(templatesPath, string(templateBytes))
+			if err != nil {
+				return "", err
+			}
+			templateBytes = []byte(rendered)
+		}
 		content = append(content, string(templateBytes))
 	}
+	if len(frontendFiles) > 0 {
+		frontend, err := m.getFrontend(templatesPath, frontendFiles)
+		if err != nil {
+			return "", err
+		}
+		content = append(content[:1], append([]string{frontend}, content[1:]...)...)
+	}
 	if len(configsFiles) == 1 {
 		content = append(content, `frontend dummy-fe
     bind *:80
@@ -89,3 +223,162 @@ backend dummy-be
 	}
 	return strings.Join(content, "\n\n"), nil
 }
+
+func (m HaProxy) renderSettings(templatesPath, content string) (string, error) {
+	settings, err := getProxySettings(templatesPath)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New("haproxy.tmpl").Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("Could not parse the file haproxy.tmpl\n%s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, settings); err != nil {
+		return "", fmt.Errorf("Could not render the file haproxy.tmpl\n%s", err.Error())
+	}
+	return out.String(), nil
+}
+
+type frontendRules struct {
+	acls        []string
+	httpRules   []string
+	useBackends frontendUseBackends
+}
+
+type frontendUseBackend struct {
+	name       string
+	line       string
+	pathLength int
+	conditions int
+	wildcard   bool
+}
+
+// frontendUseBackends are ordered by path length so that more specific paths are matched first.
+// Rules with the same path are ordered so that exact domains take precedence over wildcard domains.
+type frontendUseBackends []frontendUseBackend
+
+func (s frontendUseBackends) Len() int {
+	return len(s)
+}
+
+func (s frontendUseBackends) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s frontendUseBackends) Less(i, j int) bool {
+	if s[i].pathLength != s[j].pathLength {
+		return s[i].pathLength > s[j].pathLength
+	}
+	if s[i].conditions != s[j].conditions {
+		return s[i].conditions > s[j].conditions
+	}
+	if s[i].wildcard != s[j].wildcard {
+		return !s[i].wildcard
+	}
+	return s[i].name < s[j].name
+}
+
+func (m HaProxy) getFrontend(templatesPath string, files []string) (string, error) {
+	acls := []string{}
+	httpRules := []string{}
+	useBackends := frontendUseBackends{}
+	for _, file := range files {
+		ruleBytes, err := readConfigsFile(fmt.Sprintf("%s/%s", templatesPath, file))
+		if err != nil {
+			return "", fmt.Errorf("Could not read the file %s\n%s", file, err.Error())
+		}
+		rules := m.parseFrontendRules(strings.TrimSuffix(file, ".fe"), string(ruleBytes))
+		acls = append(acls, rules.acls...)
+		httpRules = append(httpRules, rules.httpRules...)
+		useBackends = append(useBackends, rules.useBackends...)
+	}
+	sort.Stable(useBackends)
+	lines := []string{`frontend services
+	bind *:80
+	bind *:443
+	option http-server-close`}
+	lines = append(lines, acls...)
+	lines = append(lines, httpRules...)
+	for _, useBackend := range useBackends {
+		lines = append(lines, useBackend.line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// parseFrontendRules splits use_backend rules of services with multiple paths into one rule per path
+// so that each path is ordered on its own.
+func (m HaProxy) parseFrontendRules(name, content string) frontendRules {
+	rules := frontendRules{}
+	paths := map[string][][]string{}
+	useBackends := [][]string{}
+	exactDomain := false
+	wildcardDomain := false
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "acl":
+			rules.acls = append(rules.acls, "\t"+strings.Join(fields, " "))
+			if len(fields) > 1 && strings.HasPrefix(fields[1], "url_") {
+				for i := 3; i < len(fields); i += 2 {
+					paths[fields[1]] = append(paths[fields[1]], fields[i-1:i+1])
+				}
+			}
+			if len(fields) > 2 && strings.HasPrefix(fields[1], "domain_") {
+				if strings.HasPrefix(fields[2], "hdr_end(host)") || strings.Contains(strings.Join(fields[3:], " "), "-m end") {
+					wildcardDomain = true
+				} else {
+					exactDomain = true
+				}
+			}
+		case "redirect", "http-request":
+			rules.httpRules = append(rules.httpRules, "\t"+strings.Join(fields, " "))
+		case "use_backend":
+			useBackends = append(useBackends, fields)
+		}
+	}
+	wildcard := wildcardDomain && !exactDomain
+	for _, fields := range useBackends {
+		conditions := []string{}
+		for i, field := range fields {
+			if field == "if" {
+				conditions = fields[i+1:]
+				fields = fields[:i+1]
+			}
+		}
+		url := -1
+		for i, condition := range conditions {
+			if _, ok := paths[condition]; ok {
+				url = i
+			}
+		}
+		if url < 0 || len(paths[conditions[url]]) < 2 {
+			useBackend := frontendUseBackend{
+				name:       name,
+				line:       "\t" + strings.Join(append(fields, conditions...), " "),
+				conditions: len(conditions),
+				wildcard:   wildcard,
+			}
+			if url >= 0 && len(paths[conditions[url]]) == 1 {
+				useBackend.pathLength = len(paths[conditions[url]][0][1])
+			}
+			rules.useBackends = append(rules.useBackends, useBackend)
+			continue
+		}
+		for _, path := range paths[conditions[url]] {
+			pathConditions := append([]string{}, conditions...)
+			pathConditions[url] = fmt.Sprintf("{ %s %s }", path[0], path[1])
+			rules.useBackends = append(rules.useBackends, frontendUseBackend{
+				name:       name,
+				line:       "\t" + strings.Join(append(append([]string{}, fields...), pathConditions...), " "),
+				pathLength: len(path[1]),
+				conditions: len(conditions),
+				wildcard:   wildcard,
+			})
+		}
+	}
+	return rules
+}
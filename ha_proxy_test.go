@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -25,6 +28,9 @@ func (s *HaProxyTestSuite) SetupTest() {
 	writeFile = func(filename string, data []byte, perm os.FileMode) error {
 		return nil
 	}
+	cmdRunHa = func(cmd *exec.Cmd) error {
+		return nil
+	}
 }
 
 // CreateConfigFromTemplates
@@ -109,6 +115,427 @@ func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_ReturnsError_WhenReadCo
 	s.Error(err)
 }
 
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_ValidatesCandidateConfig() {
+	var actualFilenames []string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actualFilenames = append(actualFilenames, filename)
+		return nil
+	}
+	actualCommand := s.mockHaExecCmd()
+	expectedCandidate := fmt.Sprintf("%s/haproxy.cfg.new", s.ConfigsPath)
+
+	HaProxy{}.CreateConfigFromTemplates(s.TemplatesPath, s.ConfigsPath)
+
+	s.Equal([]string{"haproxy", "-c", "-f", expectedCandidate}, *actualCommand)
+	s.Equal([]string{expectedCandidate, fmt.Sprintf("%s/haproxy.cfg", s.ConfigsPath)}, actualFilenames)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_KeepsConfig_WhenValidationFails() {
+	var actualFilenames []string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actualFilenames = append(actualFilenames, filename)
+		return nil
+	}
+	cmdRunHa = func(cmd *exec.Cmd) error {
+		cmd.Stderr.Write([]byte("[ALERT] parsing [haproxy.cfg:12] : unknown keyword 'serverr'"))
+		return fmt.Errorf("exit status 1")
+	}
+
+	err := HaProxy{}.CreateConfigFromTemplates(s.TemplatesPath, s.ConfigsPath)
+
+	s.Error(err)
+	s.Contains(err.Error(), "unknown keyword 'serverr'")
+	s.NotContains(actualFilenames, fmt.Sprintf("%s/haproxy.cfg", s.ConfigsPath))
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_CreatesSharedFrontendOrderedByPathLength() {
+	dir, _ := ioutil.TempDir("", "ha-proxy")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"haproxy.tmpl": "template content",
+		"short.cfg":    "backend short-be",
+		"short.fe": `	acl url_short path_beg /a
+	use_backend short-be if url_short`,
+		"long.cfg": "backend long-be",
+		"long.fe": `	acl url_long path_beg /a/b/c path_beg /d
+	use_backend long-be if url_long`,
+		"domain.cfg": "backend domain-be",
+		"domain.fe": `	acl url_domain path_beg /a
+	acl domain_domain req.hdr(host),field(1,:) -i my-domain.com
+	use_backend domain-be if url_domain domain_domain`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+	}
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	expected := `template content
+
+frontend services
+	bind *:80
+	bind *:443
+	option http-server-close
+	acl url_domain path_beg /a
+	acl domain_domain req.hdr(host),field(1,:) -i my-domain.com
+	acl url_long path_beg /a/b/c path_beg /d
+	acl url_short path_beg /a
+	use_backend long-be if { path_beg /a/b/c }
+	use_backend domain-be if url_domain domain_domain
+	use_backend long-be if { path_beg /d }
+	use_backend short-be if url_short
+
+backend domain-be
+
+backend long-be
+
+backend short-be`
+
+	HaProxy{}.CreateConfigFromTemplates(dir, s.ConfigsPath)
+
+	s.Equal(expected, actual)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_OrdersSharedFrontendByEachPath() {
+	dir, _ := ioutil.TempDir("", "ha-proxy")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"haproxy.tmpl": "template content",
+		"a.cfg":        "backend a-be",
+		"a.fe": `	acl url_a path_beg /a path_beg /very/long/path
+	acl domain_a req.hdr(host),field(1,:) -i my-domain.com
+	use_backend a-be if url_a domain_a`,
+		"b.cfg": "backend b-be",
+		"b.fe": `	acl url_b path_beg /a/b
+	acl domain_b req.hdr(host),field(1,:) -i my-domain.com
+	use_backend b-be if url_b domain_b`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+	}
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	expected := `	use_backend a-be if { path_beg /very/long/path } domain_a
+	use_backend b-be if url_b domain_b
+	use_backend a-be if { path_beg /a } domain_a
+`
+
+	HaProxy{}.CreateConfigFromTemplates(dir, s.ConfigsPath)
+
+	s.Contains(actual, expected)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_AddsRedirectsToSharedFrontend() {
+	dir, _ := ioutil.TempDir("", "ha-proxy")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"haproxy.tmpl": "template content",
+		"secure.cfg":   "backend secure-be",
+		"secure.fe": `	acl url_secure path_beg /secure
+	redirect scheme https if !{ ssl_fc } url_secure
+	use_backend secure-be if url_secure`,
+		"plain.cfg": "backend plain-be",
+		"plain.fe": `	acl url_plain path_beg /plain/path
+	use_backend plain-be if url_plain`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+	}
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	expected := `frontend services
+	bind *:80
+	bind *:443
+	option http-server-close
+	acl url_plain path_beg /plain/path
+	acl url_secure path_beg /secure
+	redirect scheme https if !{ ssl_fc } url_secure
+	use_backend plain-be if url_plain
+	use_backend secure-be if url_secure
+`
+
+	HaProxy{}.CreateConfigFromTemplates(dir, s.ConfigsPath)
+
+	s.Contains(actual, expected)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_OrdersExactDomainsBeforeWildcards() {
+	dir, _ := ioutil.TempDir("", "ha-proxy")
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"haproxy.tmpl": "template content",
+		"any.cfg":      "backend any-be",
+		"any.fe": `	acl url_any path_beg /demo
+	acl domain_any req.hdr(host),field(1,:) -m end -i .my-domain.com
+	use_backend any-be if url_any domain_any`,
+		"api.cfg": "backend api-be",
+		"api.fe": `	acl url_api path_beg /demo
+	acl domain_api req.hdr(host),field(1,:) -i api.my-domain.com
+	use_backend api-be if url_api domain_api`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+	}
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	expected := `	acl url_any path_beg /demo
+	acl domain_any req.hdr(host),field(1,:) -m end -i .my-domain.com
+	acl url_api path_beg /demo
+	acl domain_api req.hdr(host),field(1,:) -i api.my-domain.com
+	use_backend api-be if url_api domain_api
+	use_backend any-be if url_any domain_any
+`
+
+	HaProxy{}.CreateConfigFromTemplates(dir, s.ConfigsPath)
+
+	s.Contains(actual, expected)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_OrdersApexDomainBeforeWildcard() {
+	dir, _ := ioutil.TempDir("", "ha-proxy")
+	defer os.RemoveAll(dir)
+	wildcard := Reconfigure{ServiceReconfigure: ServiceReconfigure{ServiceName: "any", ServicePath: []string{"/demo"}, ServiceDomain: []string{"*.my-domain.com"}}}
+	apex := Reconfigure{ServiceReconfigure: ServiceReconfigure{ServiceName: "apex", ServicePath: []string{"/demo"}, ServiceDomain: []string{"my-domain.com"}}}
+	files := map[string]string{
+		"haproxy.tmpl": "template content",
+		"any.cfg":      "backend any-be",
+		"any.fe":       wildcard.getFrontendRulesFromGo(wildcard.ServiceReconfigure),
+		"apex.cfg":     "backend apex-be",
+		"apex.fe":      apex.getFrontendRulesFromGo(apex.ServiceReconfigure),
+	}
+	for name, content := range files {
+		ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+	}
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	expected := `	acl url_any path_beg /demo
+	acl domain_any req.hdr(host),field(1,:) -m end -i .my-domain.com
+	acl url_apex path_beg /demo
+	acl domain_apex req.hdr(host),field(1,:) -i my-domain.com
+	use_backend apex-be if url_apex domain_apex
+	use_backend any-be if url_any domain_any
+`
+
+	HaProxy{}.CreateConfigFromTemplates(dir, s.ConfigsPath)
+
+	s.Contains(actual, expected)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_RendersSettingsIntoHaProxyTemplate() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	defer os.RemoveAll(templatesPath)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte(`    balance {{.Balance}}
+    maxconn {{.MaxConn}}
+    timeout server  {{.TimeoutServer}}
+    stats auth {{.StatsUser}}:{{.StatsPass}}`), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/settings.json", templatesPath), []byte(`{"TimeoutServer": "60s", "StatsPass": "secret"}`), 0664)
+	var actual string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+
+	HaProxy{}.CreateConfigFromTemplates(templatesPath, s.ConfigsPath)
+
+	s.True(strings.HasPrefix(actual, `    balance roundrobin
+    maxconn 5000
+    timeout server  60s
+    stats auth admin:secret`), actual)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_ReturnsError_WhenHaProxyTemplateCannotBeParsed() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	defer os.RemoveAll(templatesPath)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("balance {{.Balance"), 0664)
+
+	err := HaProxy{}.CreateConfigFromTemplates(templatesPath, s.ConfigsPath)
+
+	s.Error(err)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_AddsCertsToHttpsBinds() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	configsPath, _ := ioutil.TempDir("", "ha-proxy-configs")
+	defer os.RemoveAll(templatesPath)
+	defer os.RemoveAll(configsPath)
+	os.MkdirAll(fmt.Sprintf("%s/certs", configsPath), 0755)
+	ioutil.WriteFile(fmt.Sprintf("%s/certs/my-cert.pem", configsPath), []byte("cert"), 0600)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("template content"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.cfg", templatesPath), []byte(`frontend go-demo-fe
+	bind *:80
+	bind *:443
+	bind *:8443 ssl crt /my/own/cert.pem`), 0664)
+	actual := map[string]string{}
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+
+	HaProxy{}.CreateConfigFromTemplates(templatesPath, configsPath)
+
+	config := actual[fmt.Sprintf("%s/haproxy.cfg", configsPath)]
+	s.Contains(config, fmt.Sprintf("\tbind *:443 ssl crt %s/certs\n", configsPath))
+	s.Contains(config, "\tbind *:8443 ssl crt /my/own/cert.pem")
+	s.NotContains(config, "crt-list")
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_AddsCrtList_WhenServicesHaveCerts() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	configsPath, _ := ioutil.TempDir("", "ha-proxy-configs")
+	defer os.RemoveAll(templatesPath)
+	defer os.RemoveAll(configsPath)
+	os.MkdirAll(fmt.Sprintf("%s/certs", configsPath), 0755)
+	ioutil.WriteFile(fmt.Sprintf("%s/certs/my-cert.pem", configsPath), []byte("cert"), 0600)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("template content"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.cfg", templatesPath), []byte("backend go-demo-be"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.fe", templatesPath), []byte("\tuse_backend go-demo-be if url_go-demo"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.sni", templatesPath), []byte("/certs/my-cert.pem my-domain.com\n"), 0664)
+	actual := map[string]string{}
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+	osRenameOrig := osRename
+	defer func() { osRename = osRenameOrig }()
+	renamed := map[string]string{}
+	osRename = func(oldpath, newpath string) error {
+		renamed[oldpath] = newpath
+		return nil
+	}
+
+	HaProxy{}.CreateConfigFromTemplates(templatesPath, configsPath)
+
+	s.Equal("/certs/my-cert.pem my-domain.com\n", actual[fmt.Sprintf("%s/crt-list.txt.new", configsPath)])
+	s.Contains(
+		actual[fmt.Sprintf("%s/haproxy.cfg.new", configsPath)],
+		fmt.Sprintf("crt-list %s/crt-list.txt.new\n", configsPath),
+	)
+	s.Equal(map[string]string{fmt.Sprintf("%s/crt-list.txt.new", configsPath): fmt.Sprintf("%s/crt-list.txt", configsPath)}, renamed)
+	s.Contains(
+		actual[fmt.Sprintf("%s/haproxy.cfg", configsPath)],
+		fmt.Sprintf("\tbind *:443 ssl crt %s/certs crt-list %s/crt-list.txt\n", configsPath, configsPath),
+	)
+}
+
+func (s HaProxyTestSuite) Test_CreateConfigFromTemplates_KeepsCrtList_WhenValidationFails() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	configsPath, _ := ioutil.TempDir("", "ha-proxy-configs")
+	defer os.RemoveAll(templatesPath)
+	defer os.RemoveAll(configsPath)
+	os.MkdirAll(fmt.Sprintf("%s/certs", configsPath), 0755)
+	ioutil.WriteFile(fmt.Sprintf("%s/certs/my-cert.pem", configsPath), []byte("cert"), 0600)
+	ioutil.WriteFile(fmt.Sprintf("%s/crt-list.txt", configsPath), []byte("previous crt-list\n"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("template content"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.cfg", templatesPath), []byte("backend go-demo-be"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.sni", templatesPath), []byte("/certs/my-cert.pem my-domain.com\n"), 0664)
+	writeFile = ioutil.WriteFile
+	osRemoveOrig := osRemove
+	defer func() { osRemove = osRemoveOrig }()
+	osRemove = os.Remove
+	cmdRunHa = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("exit status 1")
+	}
+
+	err := HaProxy{}.CreateConfigFromTemplates(templatesPath, configsPath)
+
+	s.Error(err)
+	content, _ := ioutil.ReadFile(fmt.Sprintf("%s/crt-list.txt", configsPath))
+	s.Equal("previous crt-list\n", string(content))
+	_, err = os.Stat(fmt.Sprintf("%s/crt-list.txt.new", configsPath))
+	s.True(os.IsNotExist(err))
+}
+
+// RenderConfigFromTemplates
+
+func (s HaProxyTestSuite) Test_RenderConfigFromTemplates_ReturnsConfigWithoutWritingIt() {
+	var actualFilenames []string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actualFilenames = append(actualFilenames, filename)
+		return nil
+	}
+	actualCommand := s.mockHaExecCmd()
+
+	actual, err := HaProxy{}.RenderConfigFromTemplates(s.TemplatesPath, s.ConfigsPath)
+
+	s.NoError(err)
+	s.Equal(`template content
+
+config1 content
+
+config2 content`, actual)
+	s.Len(actualFilenames, 1)
+	s.Equal("haproxy.cfg.new", filepath.Base(actualFilenames[0]))
+	s.NotEqual(s.ConfigsPath, filepath.Dir(actualFilenames[0]))
+	s.Equal([]string{"haproxy", "-c", "-f", actualFilenames[0]}, *actualCommand)
+}
+
+func (s HaProxyTestSuite) Test_RenderConfigFromTemplates_RemovesTemporaryDirectory() {
+	var actualFilename string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actualFilename = filename
+		return nil
+	}
+
+	HaProxy{}.RenderConfigFromTemplates(s.TemplatesPath, s.ConfigsPath)
+
+	_, err := os.Stat(filepath.Dir(actualFilename))
+	s.True(os.IsNotExist(err))
+}
+
+func (s HaProxyTestSuite) Test_RenderConfigFromTemplates_ValidatesCandidateCrtListInTemporaryDirectory() {
+	templatesPath, _ := ioutil.TempDir("", "ha-proxy-templates")
+	configsPath, _ := ioutil.TempDir("", "ha-proxy-configs")
+	defer os.RemoveAll(templatesPath)
+	defer os.RemoveAll(configsPath)
+	os.MkdirAll(fmt.Sprintf("%s/certs", configsPath), 0755)
+	ioutil.WriteFile(fmt.Sprintf("%s/certs/my-cert.pem", configsPath), []byte("cert"), 0600)
+	ioutil.WriteFile(fmt.Sprintf("%s/haproxy.tmpl", templatesPath), []byte("template content"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.cfg", templatesPath), []byte("backend go-demo-be"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.fe", templatesPath), []byte("\tuse_backend go-demo-be if url_go-demo"), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/go-demo.sni", templatesPath), []byte("/certs/my-cert.pem my-domain.com\n"), 0664)
+	actual := map[string]string{}
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filepath.Base(filename)] = string(data)
+		s.NotEqual(configsPath, filepath.Dir(filename))
+		return nil
+	}
+	actualCommand := s.mockHaExecCmd()
+
+	config, err := HaProxy{}.RenderConfigFromTemplates(templatesPath, configsPath)
+
+	s.NoError(err)
+	s.Equal("/certs/my-cert.pem my-domain.com\n", actual["crt-list.txt.new"])
+	tempDir := filepath.Dir((*actualCommand)[3])
+	s.Contains(actual["haproxy.cfg.new"], fmt.Sprintf("crt-list %s/crt-list.txt.new\n", tempDir))
+	s.Contains(config, fmt.Sprintf("\tbind *:443 ssl crt %s/certs crt-list %s/crt-list.txt\n", configsPath, configsPath))
+	files, _ := ioutil.ReadDir(configsPath)
+	s.Len(files, 1)
+}
+
+func (s HaProxyTestSuite) Test_RenderConfigFromTemplates_ReturnsError_WhenValidationFails() {
+	cmdRunHa = func(cmd *exec.Cmd) error {
+		return fmt.Errorf("exit status 1")
+	}
+
+	_, err := HaProxy{}.RenderConfigFromTemplates(s.TemplatesPath, s.ConfigsPath)
+
+	s.Error(err)
+}
+
 // Reload
 
 func (s HaProxyTestSuite) Test_Reload_ReadsPidFile() {
@@ -179,15 +606,6 @@ func (s HaProxyTestSuite) mockHaExecCmd() *[]string {
 	return &actualCommand
 }
 
-func (s HaProxyTestSuite) mockConsulExecCmd() *[]string {
-	var actualCommand []string
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		actualCommand = cmd.Args
-		return nil
-	}
-	return &actualCommand
-}
-
 type ProxyMock struct {
 	mock.Mock
 }
@@ -202,6 +620,11 @@ func (m *ProxyMock) CreateConfigFromTemplates(templatesPath string, configsPath
 	return params.Error(0)
 }
 
+func (m *ProxyMock) RenderConfigFromTemplates(templatesPath string, configsPath string) (string, error) {
+	params := m.Called(templatesPath, configsPath)
+	return params.String(0), params.Error(1)
+}
+
 func (m *ProxyMock) Reload() error {
 	params := m.Called()
 	return params.Error(0)
@@ -215,6 +638,9 @@ func getProxyMock(skipMethod string) *ProxyMock {
 	if skipMethod != "CreateConfigFromTemplates" {
 		mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(nil)
 	}
+	if skipMethod != "RenderConfigFromTemplates" {
+		mockObj.On("RenderConfigFromTemplates", mock.Anything, mock.Anything).Return("", nil)
+	}
 	if skipMethod != "Reload" {
 		mockObj.On("Reload").Return(nil)
 	}
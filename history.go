@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Historyable interface {
+	Record(action, request string, sr ServiceReconfigure) error
+	GetAll() ([]ConfigVersion, error)
+	Get(version int) (ConfigVersion, bool, error)
+	Rollback(version int) (ConfigVersion, error)
+}
+
+type History struct {
+	BaseReconfigure
+	Limit int
+}
+
+type ConfigVersion struct {
+	Version  int
+	Created  time.Time
+	Request  string
+	Services map[string]ServiceReconfigure `json:",omitempty"`
+	Config   string                        `json:",omitempty"`
+	Files    map[string]string             `json:",omitempty"`
+}
+
+var historyFileSuffixes = []string{".cfg", ".fe", ".sni"}
+
+var NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+	return &History{BaseReconfigure: baseData, Limit: limit}
+}
+
+// Record stores the current proxy configuration together with the request that produced it.
+// Only the last Limit versions are kept. Services are read from the registry when there is no previous version
+// or when all services were reloaded. The caller must hold mu so that the stored files belong to the recorded change.
+func (m *History) Record(action, request string, sr ServiceReconfigure) error {
+	if m.Limit <= 0 {
+		return nil
+	}
+	return m.record(action, request, sr)
+}
+
+// withHistoryRequest returns a copy of the data that makes Reconfigure and Remove record applied changes
+// in the history under the request.
+func (m BaseReconfigure) withHistoryRequest(request string) BaseReconfigure {
+	m.historyRequest = request
+	return m
+}
+
+// recordHistory records an applied change when the data was created with withHistoryRequest. The caller must hold mu.
+func (m BaseReconfigure) recordHistory(action string, sr ServiceReconfigure) {
+	if len(m.historyRequest) > 0 {
+		recordChange(m, action, m.historyRequest, sr)
+	}
+}
+
+// recordChange records a change without failing it. The change is already applied so failures are only logged.
+// The caller must hold mu.
+func recordChange(baseData BaseReconfigure, action, request string, sr ServiceReconfigure) {
+	if err := NewHistory(baseData, baseData.HistoryLimit).Record(action, request, sr); err != nil {
+		logPrintf("Could not record the %s request %s\n%s", action, request, err.Error())
+	}
+}
+
+// GetAll returns all stored versions, starting with the latest. Configurations and files are omitted.
+func (m *History) GetAll() ([]ConfigVersion, error) {
+	numbers, err := m.getVersionNumbers()
+	if err != nil {
+		return nil, err
+	}
+	versions := []ConfigVersion{}
+	for i := len(numbers) - 1; i >= 0; i-- {
+		version, found, err := m.Get(numbers[i])
+		if err != nil {
+			return nil, err
+		} else if !found {
+			continue
+		}
+		version.Config = ""
+		version.Files = nil
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (m *History) Get(version int) (ConfigVersion, bool, error) {
+	data := ConfigVersion{}
+	path := m.getVersionPath(version)
+	content, err := readConfigsFile(path)
+	if os.IsNotExist(err) {
+		return data, false, nil
+	} else if err != nil {
+		return data, false, fmt.Errorf("Could not read the file %s\n%s", path, err.Error())
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return data, false, fmt.Errorf("Could not parse the file %s\n%s", path, err.Error())
+	}
+	return data, true, nil
+}
+
+// Rollback restores service definitions stored with the version, re-renders their configurations against
+// the current instances and reloads the proxy. The current service files and definitions are kept if HAProxy
+// does not accept the configuration. The rollback is stored as a new version.
+func (m *History) Rollback(version int) (ConfigVersion, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	target, found, err := m.Get(version)
+	if err != nil {
+		return ConfigVersion{}, err
+	} else if !found {
+		return ConfigVersion{}, fmt.Errorf("The version %d does not exist", version)
+	}
+	latest, err := m.getLatest()
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	current, err := m.getFiles()
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	if err := m.createConfigs(registry, latest.Services, target.Services); err != nil {
+		m.restoreFiles(current)
+		return ConfigVersion{}, err
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		m.restoreFiles(current)
+		return ConfigVersion{}, err
+	}
+	if err := proxy.Reload(); err != nil {
+		return ConfigVersion{}, err
+	}
+	if err := m.restoreServices(registry, latest.Services, target.Services); err != nil {
+		return ConfigVersion{}, err
+	}
+	rollback, err := m.store(fmt.Sprintf("rollback to version %d", version), target.Services)
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	rollback.Config = ""
+	rollback.Files = nil
+	return rollback, nil
+}
+
+func (m *History) record(action, request string, sr ServiceReconfigure) error {
+	latest, err := m.getLatest()
+	if err != nil {
+		return err
+	}
+	services := latest.Services
+	if latest.Version == 0 || action == "reload" {
+		if services, err = m.getRegistryServices(); err != nil {
+			return err
+		}
+	}
+	services = m.copyServices(services)
+	if action == "remove" {
+		delete(services, sr.ServiceName)
+	} else if len(sr.ServiceName) > 0 {
+		services[sr.ServiceName] = sr
+	}
+	_, err = m.store(request, services)
+	return err
+}
+
+func (m *History) store(request string, services map[string]ServiceReconfigure) (ConfigVersion, error) {
+	numbers, err := m.getVersionNumbers()
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	files, err := m.getFiles()
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	version := ConfigVersion{
+		Version:  1,
+		Created:  time.Now().UTC(),
+		Request:  request,
+		Services: services,
+		Files:    files,
+	}
+	if len(numbers) > 0 {
+		version.Version = numbers[len(numbers)-1] + 1
+	}
+	if config, err := readConfigsFile(fmt.Sprintf("%s/haproxy.cfg", m.ConfigsPath)); err == nil {
+		version.Config = string(config)
+	}
+	dir := m.getHistoryPath()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ConfigVersion{}, fmt.Errorf("Could not create the directory %s\n%s", dir, err.Error())
+	}
+	content, _ := json.Marshal(version)
+	path := m.getVersionPath(version.Version)
+	if err := writeFile(path, content, 0664); err != nil {
+		return ConfigVersion{}, fmt.Errorf("Could not write the file %s\n%s", path, err.Error())
+	}
+	numbers = append(numbers, version.Version)
+	if m.Limit > 0 && len(numbers) > m.Limit {
+		for _, number := range numbers[:len(numbers)-m.Limit] {
+			osRemove(m.getVersionPath(number))
+		}
+	}
+	return version, nil
+}
+
+// createConfigs renders the files of target services and removes the files of services that are not part of the target.
+func (m *History) createConfigs(registry Registry, latest, target map[string]ServiceReconfigure) error {
+	r := &Reconfigure{BaseReconfigure: m.BaseReconfigure}
+	for _, sr := range target {
+		if err := r.createConfig(registry, m.TemplatesPath, sr); err != nil {
+			return fmt.Errorf("Could not create the configuration of the service %s\n%s", sr.ServiceName, err.Error())
+		}
+	}
+	for name := range latest {
+		if _, ok := target[name]; !ok {
+			for _, suffix := range historyFileSuffixes {
+				osRemove(fmt.Sprintf("%s/%s%s", m.TemplatesPath, name, suffix))
+			}
+		}
+	}
+	return nil
+}
+
+func (m *History) restoreServices(registry Registry, latest, target map[string]ServiceReconfigure) error {
+	r := &Reconfigure{BaseReconfigure: m.BaseReconfigure}
+	for _, sr := range target {
+		if err := r.putToRegistry(registry, sr); err != nil {
+			return fmt.Errorf("Could not restore the definition of the service %s\n%s", sr.ServiceName, err.Error())
+		}
+	}
+	for name := range latest {
+		if _, ok := target[name]; !ok {
+			if err := registry.DeleteService(name); err != nil {
+				return fmt.Errorf("Could not remove the definition of the service %s\n%s", name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (m *History) getRegistryServices() (map[string]ServiceReconfigure, error) {
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return nil, err
+	}
+	names, err := registry.GetServices()
+	if err != nil {
+		return nil, err
+	}
+	r := &Reconfigure{BaseReconfigure: m.BaseReconfigure}
+	c := make(chan ServiceReconfigure)
+	for _, name := range names {
+		go r.getService(registry, name, c)
+	}
+	services := map[string]ServiceReconfigure{}
+	for range names {
+		if sr := <-c; isServiceConfigured(sr) {
+			services[sr.ServiceName] = sr
+		}
+	}
+	return services, nil
+}
+
+func (m *History) copyServices(services map[string]ServiceReconfigure) map[string]ServiceReconfigure {
+	copied := map[string]ServiceReconfigure{}
+	for name, sr := range services {
+		copied[name] = sr
+	}
+	return copied
+}
+
+func (m *History) getLatest() (ConfigVersion, error) {
+	numbers, err := m.getVersionNumbers()
+	if err != nil || len(numbers) == 0 {
+		return ConfigVersion{}, err
+	}
+	latest, _, err := m.Get(numbers[len(numbers)-1])
+	return latest, err
+}
+
+func (m *History) getFiles() (map[string]string, error) {
+	infos, err := readConfigsDir(m.TemplatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the directory %s\n%s", m.TemplatesPath, err.Error())
+	}
+	files := map[string]string{}
+	for _, fi := range infos {
+		if fi.IsDir() || !m.isServiceFile(fi.Name()) {
+			continue
+		}
+		path := fmt.Sprintf("%s/%s", m.TemplatesPath, fi.Name())
+		content, err := readConfigsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the file %s\n%s", path, err.Error())
+		}
+		files[fi.Name()] = string(content)
+	}
+	return files, nil
+}
+
+// restoreFiles brings the service files back to the state read before a failed rollback.
+func (m *History) restoreFiles(files map[string]string) {
+	if rendered, err := m.getFiles(); err == nil {
+		for name := range rendered {
+			if _, ok := files[name]; !ok {
+				osRemove(fmt.Sprintf("%s/%s", m.TemplatesPath, name))
+			}
+		}
+	}
+	for name, content := range files {
+		writeFile(fmt.Sprintf("%s/%s", m.TemplatesPath, name), []byte(content), 0664)
+	}
+}
+
+func (m *History) getVersionNumbers() ([]int, error) {
+	infos, err := readConfigsDir(m.getHistoryPath())
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read the directory %s\n%s", m.getHistoryPath(), err.Error())
+	}
+	numbers := []int{}
+	for _, fi := range infos {
+		if number, err := strconv.Atoi(strings.TrimSuffix(fi.Name(), ".json")); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (m *History) isServiceFile(name string) bool {
+	for _, suffix := range historyFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *History) getHistoryPath() string {
+	return fmt.Sprintf("%s/history", m.TemplatesPath)
+}
+
+func (m *History) getVersionPath(version int) string {
+	return fmt.Sprintf("%s/%d.json", m.getHistoryPath(), version)
+}
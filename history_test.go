@@ -0,0 +1,313 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type HistoryTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	history *History
+}
+
+func (s *HistoryTestSuite) SetupTest() {
+	s.TemplatesPath, _ = ioutil.TempDir("", "history-templates")
+	s.ConfigsPath, _ = ioutil.TempDir("", "history-configs")
+	s.history = NewHistory(s.BaseReconfigure, 3).(*History)
+	readConfigsDir = ioutil.ReadDir
+	readConfigsFile = ioutil.ReadFile
+	writeFile = ioutil.WriteFile
+	writeServiceConfigFile = ioutil.WriteFile
+	osRemove = os.Remove
+	proxy = getProxyMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
+}
+
+func (s *HistoryTestSuite) TearDownTest() {
+	os.RemoveAll(s.TemplatesPath)
+	os.RemoveAll(s.ConfigsPath)
+}
+
+// Record
+
+func (s *HistoryTestSuite) Test_Record_StoresConfigFilesAndService() {
+	s.writeFile(s.ConfigsPath, "haproxy.cfg", "my-config")
+	s.writeFile(s.TemplatesPath, "go-demo.cfg", "go-demo-be")
+	s.writeFile(s.TemplatesPath, "go-demo.fe", "go-demo-fe")
+	s.writeFile(s.TemplatesPath, "haproxy.tmpl", "my-template")
+	sr := ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo"}}
+
+	err := s.history.Record("reconfigure", "GET /v1/docker-flow-proxy/reconfigure?serviceName=go-demo", sr)
+
+	s.NoError(err)
+	actual, found, _ := s.history.Get(1)
+	s.True(found)
+	s.Equal(1, actual.Version)
+	s.Equal("GET /v1/docker-flow-proxy/reconfigure?serviceName=go-demo", actual.Request)
+	s.Equal("my-config", actual.Config)
+	s.Equal(map[string]string{"go-demo.cfg": "go-demo-be", "go-demo.fe": "go-demo-fe"}, actual.Files)
+	s.Equal(map[string]ServiceReconfigure{"go-demo": sr}, actual.Services)
+	s.False(actual.Created.IsZero())
+}
+
+func (s *HistoryTestSuite) Test_Record_KeepsServicesFromThePreviousVersion() {
+	s.history.Record("reconfigure", "first", ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo"}})
+	s.history.Record("reconfigure", "second", ServiceReconfigure{ServiceName: "other", ServicePath: []string{"/other"}})
+
+	s.history.Record("remove", "third", ServiceReconfigure{ServiceName: "go-demo"})
+
+	actual, _, _ := s.history.Get(3)
+	s.Len(actual.Services, 1)
+	s.Contains(actual.Services, "other")
+}
+
+func (s *HistoryTestSuite) Test_Record_AddsServicesFromRegistry_WhenThereIsNoPreviousVersion() {
+	registryMock := new(RegistryMock)
+	registryMock.On("GetServices").Return([]string{"go-demo", "consul"}, nil)
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+
+	s.history.Record("start", "start", ServiceReconfigure{})
+
+	actual, _, _ := s.history.Get(1)
+	s.Len(actual.Services, 1)
+	s.Equal([]string{"/demo"}, actual.Services["go-demo"].ServicePath)
+}
+
+func (s *HistoryTestSuite) Test_Record_ReplacesServicesWithThoseFromRegistry_WhenAllServicesAreReloaded() {
+	s.history.Record("reconfigure", "first", ServiceReconfigure{ServiceName: "other", ServicePath: []string{"/other"}})
+	registryMock := new(RegistryMock)
+	registryMock.On("GetServices").Return([]string{"go-demo"}, nil)
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+
+	s.history.Record("reload", "second", ServiceReconfigure{})
+
+	actual, _, _ := s.history.Get(2)
+	s.Len(actual.Services, 1)
+	s.Contains(actual.Services, "go-demo")
+}
+
+func (s *HistoryTestSuite) Test_Record_ReturnsError_WhenVersionCannotBeWritten() {
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		return fmt.Errorf("This is an error")
+	}
+
+	err := s.history.Record("reconfigure", "request", ServiceReconfigure{})
+
+	s.Error(err)
+}
+
+func (s *HistoryTestSuite) Test_Record_RemovesVersionsAboveTheLimit() {
+	for i := 0; i < 5; i++ {
+		s.history.Record("reconfigure", fmt.Sprintf("request %d", i), ServiceReconfigure{})
+	}
+
+	actual, _ := s.history.GetAll()
+
+	s.Len(actual, 3)
+	s.Equal(5, actual[0].Version)
+	s.Equal(3, actual[2].Version)
+}
+
+func (s *HistoryTestSuite) Test_Record_DoesNothing_WhenLimitIsZero() {
+	s.history.Limit = 0
+
+	s.history.Record("reconfigure", "request", ServiceReconfigure{})
+
+	_, err := os.Stat(fmt.Sprintf("%s/history", s.TemplatesPath))
+	s.True(os.IsNotExist(err))
+}
+
+// GetAll
+
+func (s *HistoryTestSuite) Test_GetAll_ReturnsVersionsWithoutConfigs() {
+	s.writeFile(s.ConfigsPath, "haproxy.cfg", "my-config")
+	s.history.Record("reconfigure", "first", ServiceReconfigure{})
+	s.history.Record("reconfigure", "second", ServiceReconfigure{})
+
+	actual, err := s.history.GetAll()
+
+	s.NoError(err)
+	s.Len(actual, 2)
+	s.Equal("second", actual[0].Request)
+	s.Empty(actual[0].Config)
+	s.Nil(actual[0].Files)
+}
+
+func (s *HistoryTestSuite) Test_GetAll_ReturnsEmptyList_WhenThereIsNoHistory() {
+	actual, err := s.history.GetAll()
+
+	s.NoError(err)
+	s.Empty(actual)
+}
+
+// Get
+
+func (s *HistoryTestSuite) Test_Get_ReturnsFalse_WhenVersionDoesNotExist() {
+	_, found, err := s.history.Get(42)
+
+	s.NoError(err)
+	s.False(found)
+}
+
+// Rollback
+
+func (s *HistoryTestSuite) Test_Rollback_RendersServicesWithCurrentInstancesAndReloadsProxy() {
+	s.writeFile(s.TemplatesPath, "go-demo.cfg", "server go-demo_0 10.0.0.1:8080")
+	s.history.Record("reconfigure", "first", ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo"}})
+	s.writeFile(s.TemplatesPath, "other.cfg", "other")
+	s.writeFile(s.TemplatesPath, "other.fe", "other")
+	s.history.Record("reconfigure", "second", ServiceReconfigure{ServiceName: "other", ServicePath: []string{"/other"}})
+	registryMock := getRegistryMock("GetInstances")
+	registryMock.On("GetInstances", "go-demo", "").Return([]ServiceInstance{{Address: "10.0.0.2", Port: 8080, Status: "passing"}}, nil)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	actual, err := s.history.Rollback(1)
+
+	s.NoError(err)
+	s.Contains(s.readFile(s.TemplatesPath, "go-demo.cfg"), "10.0.0.2:8080")
+	s.NotContains(s.readFile(s.TemplatesPath, "go-demo.cfg"), "10.0.0.1")
+	s.Contains(s.readFile(s.TemplatesPath, "go-demo.fe"), "/demo")
+	for _, name := range []string{"other.cfg", "other.fe"} {
+		_, statErr := os.Stat(fmt.Sprintf("%s/%s", s.TemplatesPath, name))
+		s.True(os.IsNotExist(statErr))
+	}
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+	s.Equal(3, actual.Version)
+	s.Equal("rollback to version 1", actual.Request)
+}
+
+func (s *HistoryTestSuite) Test_Rollback_RestoresServicesInRegistry() {
+	v1 := ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo"}}
+	s.history.Record("reconfigure", "first", v1)
+	s.history.Record("reconfigure", "second", ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo/v2"}})
+	s.history.Record("reconfigure", "third", ServiceReconfigure{ServiceName: "other", ServicePath: []string{"/other"}})
+	registryMock := getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+
+	s.history.Rollback(1)
+
+	registryMock.AssertCalled(s.T(), "PutServiceAttributes", "go-demo", mock.MatchedBy(func(attributes map[string]string) bool {
+		return attributes[PATH_KEY] == "/demo"
+	}))
+	registryMock.AssertCalled(s.T(), "DeleteService", "other")
+}
+
+func (s *HistoryTestSuite) Test_Rollback_KeepsCurrentFilesAndServices_WhenConfigIsNotValid() {
+	s.history.Record("reconfigure", "first", ServiceReconfigure{ServiceName: "go-demo", ServicePath: []string{"/demo"}})
+	s.writeFile(s.TemplatesPath, "go-demo.cfg", "go-demo-v2")
+	s.writeFile(s.TemplatesPath, "other.cfg", "other")
+	s.history.Record("reconfigure", "second", ServiceReconfigure{ServiceName: "other", ServicePath: []string{"/other"}})
+	registryMock := getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	_, err := s.history.Rollback(1)
+
+	s.Error(err)
+	s.Equal("go-demo-v2", s.readFile(s.TemplatesPath, "go-demo.cfg"))
+	s.Equal("other", s.readFile(s.TemplatesPath, "other.cfg"))
+	_, statErr := os.Stat(fmt.Sprintf("%s/go-demo.fe", s.TemplatesPath))
+	s.True(os.IsNotExist(statErr))
+	mockObj.AssertNotCalled(s.T(), "Reload")
+	registryMock.AssertNotCalled(s.T(), "PutServiceAttributes", mock.Anything, mock.Anything)
+	registryMock.AssertNotCalled(s.T(), "DeleteService", mock.Anything)
+}
+
+func (s *HistoryTestSuite) Test_Rollback_ReturnsError_WhenVersionDoesNotExist() {
+	_, err := s.history.Rollback(42)
+
+	s.Error(err)
+}
+
+// Suite
+
+func TestHistoryTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	proxyOrig := proxy
+	newRegistryOrig := NewRegistry
+	defer func() {
+		proxy = proxyOrig
+		NewRegistry = newRegistryOrig
+	}()
+	suite.Run(t, new(HistoryTestSuite))
+}
+
+func (s *HistoryTestSuite) writeFile(dir, name, content string) {
+	ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, name), []byte(content), 0664)
+}
+
+func (s *HistoryTestSuite) readFile(dir, name string) string {
+	content, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", dir, name))
+	return string(content)
+}
+
+// Mock
+
+type HistoryMock struct {
+	mock.Mock
+}
+
+func (m *HistoryMock) Record(action, request string, sr ServiceReconfigure) error {
+	params := m.Called(action, request, sr)
+	return params.Error(0)
+}
+
+func (m *HistoryMock) GetAll() ([]ConfigVersion, error) {
+	params := m.Called()
+	return params.Get(0).([]ConfigVersion), params.Error(1)
+}
+
+func (m *HistoryMock) Get(version int) (ConfigVersion, bool, error) {
+	params := m.Called(version)
+	return params.Get(0).(ConfigVersion), params.Bool(1), params.Error(2)
+}
+
+func (m *HistoryMock) Rollback(version int) (ConfigVersion, error) {
+	params := m.Called(version)
+	return params.Get(0).(ConfigVersion), params.Error(1)
+}
+
+func getHistoryMock(skipMethod string) *HistoryMock {
+	mockObj := new(HistoryMock)
+	if skipMethod != "Record" {
+		mockObj.On("Record", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	}
+	if skipMethod != "GetAll" {
+		mockObj.On("GetAll").Return([]ConfigVersion{}, nil)
+	}
+	if skipMethod != "Get" {
+		mockObj.On("Get", mock.Anything).Return(ConfigVersion{}, true, nil)
+	}
+	if skipMethod != "Rollback" {
+		mockObj.On("Rollback", mock.Anything).Return(ConfigVersion{}, nil)
+	}
+	return mockObj
+}
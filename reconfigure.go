@@ -4,16 +4,16 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"html/template"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"unicode"
 )
 
 var mu = &sync.Mutex{}
@@ -23,6 +23,8 @@ type Reconfigurable interface {
 	GetData() (BaseReconfigure, ServiceReconfigure)
 	ReloadAllServices(address string) error
 	GetConsulTemplate(sr ServiceReconfigure) (string, error)
+	GetConfigDiff() (string, error)
+	Shift(from, to string, step int) (ServiceReconfigure, error)
 }
 
 const (
@@ -32,51 +34,191 @@ const (
 	PATH_TYPE_KEY            = "pathtype"
 	SKIP_CHECK_KEY           = "skipcheck"
 	CONSUL_TEMPLATE_PATH_KEY = "consultemplatepath"
+	PORT_KEY                 = "port"
+	CERT_KEY                 = "cert"
+	HTTPS_ONLY_KEY           = "httpsonly"
+	HSTS_KEY                 = "hsts"
+	REQ_MODE_KEY             = "reqmode"
+	SRC_PORT_KEY             = "srcport"
+	WEIGHTS_KEY              = "weights"
+	REQ_PATH_SEARCH_KEY      = "reqpathsearch"
+	REQ_PATH_REPLACE_KEY     = "reqpathreplace"
+	STRIP_PREFIX_KEY         = "stripprefix"
+	USERS_KEY                = "users"
+	SKIP_DEFAULT_USERS_KEY   = "skipdefaultusers"
 )
 
+// ACME challenges are never redirected since they must be answered over HTTP, before the certificate exists.
+const frontendRulesTemplate = `	acl url_{{.ServiceName}}{{range .ServicePath}} {{$.PathType}} {{.}}{{end}}{{.Acl}}{{if .HttpsOnly}}
+	redirect scheme https if !{ ssl_fc } !{ path_beg ` + ACME_CHALLENGE_PATH + ` } url_{{.ServiceName}}{{.AclCondition}}{{end}}
+	use_backend {{.ServiceName}}-be if url_{{.ServiceName}}{{.AclCondition}}`
+
+const tcpFrontendTemplate = `frontend {{.ServiceName}}-fe
+	bind *:{{.SrcPort}}
+	mode tcp
+	default_backend {{.ServiceName}}-be`
+
 type Reconfigure struct {
 	BaseReconfigure
 	ServiceReconfigure
+	DryRun bool `long:"dry-run" description:"Whether to output the difference between the current and the new proxy configuration without applying it."`
 }
 
 type ServiceReconfigure struct {
-	ServiceName        string   `short:"s" long:"service-name" required:"true" description:"The name of the service that should be reconfigured (e.g. my-service)."`
-	ServiceColor       string   `short:"C" long:"service-color" description:"The color of the service release in case blue-green deployment is performed (e.g. blue)."`
-	ServicePath        []string `short:"p" long:"service-path" description:"Path that should be configured in the proxy (e.g. /api/v1/my-service)."`
-	ServiceDomain      string   `long:"service-domain" description:"The domain of the service. If specified, proxy will allow access only to requests coming from that domain (e.g. my-domain.com)."`
-	ConsulTemplatePath string   `long:"consul-template-path" description:"The path to the Consul Template. If specified, proxy template will be loaded from the specified file."`
+	ServiceName        string         `short:"s" long:"service-name" required:"true" description:"The name of the service that should be reconfigured (e.g. my-service)."`
+	ServiceColor       string         `short:"C" long:"service-color" description:"The color of the service release in case blue-green deployment is performed (e.g. blue)."`
+	ServicePath        []string       `short:"p" long:"service-path" description:"Path that should be configured in the proxy (e.g. /api/v1/my-service)."`
+	ServiceDomain      []string       `long:"service-domain" description:"The domain of the service. If specified, proxy will allow access only to requests coming from that domain (e.g. my-domain.com). Wildcards are allowed as the leftmost label (e.g. *.my-domain.com). Multiple values can be specified."`
+	ConsulTemplatePath string         `long:"consul-template-path" description:"The path to the Consul Template. If specified, proxy template will be loaded from the specified file."`
+	ServiceCert        string         `long:"service-cert" description:"The name of the certificate (uploaded through the cert endpoint) that should be used for the service domain."`
+	HttpsOnly          bool           `long:"https-only" description:"Whether HTTP requests to the service should be redirected to HTTPS."`
+	Hsts               bool           `long:"hsts" description:"Whether responses of the service should include the Strict-Transport-Security header."`
+	ReqMode            string         `long:"req-mode" choice:"http" choice:"tcp" description:"The mode of the service. Services in the tcp mode get a dedicated frontend bound to the srcPort instead of path rules."`
+	SrcPort            string         `long:"src-port" description:"The port the proxy listens to for requests to the service. Mandatory when reqMode is tcp."`
+	ServiceWeights     map[string]int `long:"service-weight" description:"The weight of a service color (e.g. blue:90). If specified, the backend contains servers of all the colors and requests are distributed according to their weights. Multiple values can be specified."`
+	ReqPathSearch      string         `long:"req-path-search" description:"A regular expression matched against the path of requests before they are forwarded to the service (e.g. /api/v1/)."`
+	ReqPathReplace     string         `long:"req-path-replace" description:"The replacement of the part of the path matched by reqPathSearch (e.g. /)."`
+	StripPrefix        string         `long:"strip-prefix" description:"The prefix that should be removed from the path of requests before they are forwarded to the service (e.g. /api/v1/my-service)."`
+	Users              []string       `long:"users" description:"The user allowed to access the service through HTTP basic authentication formatted as name:hash. The password must be hashed with crypt(3) (e.g. mkpasswd -m sha-512). Multiple values can be specified."`
+	SkipDefaultUsers   bool           `long:"skip-default-users" description:"Whether the service should be accessible without authentication even though the proxy has default users."`
 	PathType           string
 	SkipCheck          bool
+	Port               string
 	Acl                string
 	AclCondition       string
 	FullServiceName    string
+	ReqPathRules       string
+	UserList           []User
+}
+
+type User struct {
+	Name     string
+	PassHash string
 }
 
 type BaseReconfigure struct {
-	ConsulAddress string `short:"a" long:"consul-address" env:"CONSUL_ADDRESS" required:"true" description:"The address of the Consul service (e.g. /api/v1/my-service)."`
-	ConfigsPath   string `short:"c" long:"configs-path" default:"/cfg" description:"The path to the configurations directory"`
-	TemplatesPath string `short:"t" long:"templates-path" default:"/cfg/tmpl" description:"The path to the templates directory"`
+	RegistryType        string   `long:"registry" default:"consul" env:"REGISTRY" choice:"consul" choice:"etcd" choice:"file" description:"The service registry that stores services data."`
+	ConsulAddress       string   `short:"a" long:"consul-address" env:"CONSUL_ADDRESS" description:"The address of the Consul service (e.g. /api/v1/my-service). Mandatory when the consul registry is used."`
+	EtcdAddress         string   `long:"etcd-address" env:"ETCD_ADDRESS" description:"The address of the etcd v3 HTTP/JSON gateway (e.g. http://etcd:2379). Mandatory when the etcd registry is used."`
+	FileRegistryPath    string   `long:"file-registry-path" default:"/cfg/registry" env:"FILE_REGISTRY_PATH" description:"The path to the directory with services data. Used with the file registry."`
+	ConfigsPath         string   `short:"c" long:"configs-path" default:"/cfg" description:"The path to the configurations directory"`
+	TemplatesPath       string   `short:"t" long:"templates-path" default:"/cfg/tmpl" description:"The path to the templates directory"`
+	PerServiceFrontends bool     `long:"per-service-frontends" env:"PER_SERVICE_FRONTENDS" description:"Whether to create a separate frontend for each service instead of a single frontend shared by all services."`
+	DefaultUsers        []string `long:"default-users" env:"PROXY_DEFAULT_USERS" env-delim:"," description:"The users (formatted as name:hash) allowed to access services that do not specify their own users. The passwords must be hashed with crypt(3)."`
+	HistoryLimit        int      `long:"history-limit" default:"10" env:"HISTORY_LIMIT" description:"The number of configuration versions kept for rollbacks. History is disabled when set to 0."`
+	// The request recorded in the history once the change is applied. Set through withHistoryRequest.
+	historyRequest string
 }
 
 var reconfigure Reconfigure
 
 var NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
-	return &Reconfigure{baseData, serviceData}
+	return &Reconfigure{BaseReconfigure: baseData, ServiceReconfigure: serviceData}
 }
 
 func (m *Reconfigure) Execute(args []string) error {
+	if m.DryRun {
+		diff, err := m.GetConfigDiff()
+		if err != nil {
+			return err
+		}
+		if len(diff) == 0 {
+			logPrintf("The proxy configuration would not change")
+		} else {
+			logPrintf("The proxy configuration would change as follows\n%s", diff)
+		}
+		return nil
+	}
 	mu.Lock()
 	defer mu.Unlock()
-	if err := m.createConfig(m.TemplatesPath, m.ServiceReconfigure); err != nil {
+	if err := m.apply(); err != nil {
 		return err
 	}
-	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+	m.recordHistory("reconfigure", m.ServiceReconfigure)
+	return nil
+}
+
+// apply creates the configuration of the service and reloads the proxy. The caller must hold mu.
+func (m *Reconfigure) apply() error {
+	if err := m.validate(m.ServiceReconfigure); err != nil {
+		return err
+	}
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return err
+	}
+	if err := m.configure(registry, m.ServiceReconfigure); err != nil {
 		return err
 	}
 	if err := proxy.Reload(); err != nil {
 		return err
 	}
-	return m.putToConsul(m.ConsulAddress, m.ServiceReconfigure)
+	return m.putToRegistry(registry, m.ServiceReconfigure)
+}
+
+// configure creates the service files and the proxy configuration.
+// The previous service files are restored if HAProxy does not accept the new configuration. The caller must hold mu.
+func (m *Reconfigure) configure(registry Registry, sr ServiceReconfigure) error {
+	paths := []string{
+		fmt.Sprintf("%s/%s.cfg", m.TemplatesPath, sr.ServiceName),
+		fmt.Sprintf("%s/%s.fe", m.TemplatesPath, sr.ServiceName),
+		fmt.Sprintf("%s/%s.sni", m.TemplatesPath, sr.ServiceName),
+	}
+	previous := map[string][]byte{}
+	for _, path := range paths {
+		if content, err := readConfigsFile(path); err == nil {
+			previous[path] = content
+		}
+	}
+	if err := m.createConfig(registry, m.TemplatesPath, sr); err != nil {
+		return err
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		for _, path := range paths {
+			if content, ok := previous[path]; ok {
+				writeServiceConfigFile(path, content, 0664)
+			} else {
+				osRemove(path)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// GetConfigDiff returns the unified diff between the current proxy configuration and the one Execute would create.
+// Service files are created in a temporary copy of the templates directory so that neither the proxy nor the registry is changed.
+func (m *Reconfigure) GetConfigDiff() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := m.validate(m.ServiceReconfigure); err != nil {
+		return "", err
+	}
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return "", err
+	}
+	templatesPath, err := ioutil.TempDir("", "docker-flow-proxy-dry-run")
+	if err != nil {
+		return "", fmt.Errorf("Could not create a temporary directory\n%s", err.Error())
+	}
+	defer os.RemoveAll(templatesPath)
+	if err := m.copyTemplates(templatesPath); err != nil {
+		return "", err
+	}
+	if err := m.createConfig(registry, templatesPath, m.ServiceReconfigure); err != nil {
+		return "", err
+	}
+	config, err := proxy.RenderConfigFromTemplates(templatesPath, m.ConfigsPath)
+	if err != nil {
+		return "", err
+	}
+	configPath := fmt.Sprintf("%s/haproxy.cfg", m.ConfigsPath)
+	current, err := readConfigsFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("Could not read the file %s\n%s", configPath, err.Error())
+	}
+	return getUnifiedDiff(configPath, fmt.Sprintf("%s.new", configPath), string(current), config), nil
 }
 
 func (m *Reconfigure) GetData() (BaseReconfigure, ServiceReconfigure) {
@@ -85,30 +227,27 @@ func (m *Reconfigure) GetData() (BaseReconfigure, ServiceReconfigure) {
 
 func (m *Reconfigure) ReloadAllServices(address string) error {
 	logPrintf("Configuring existing services")
-	address = strings.ToLower(address)
-	if !strings.HasPrefix(address, "http") {
-		address = fmt.Sprintf("http://%s", address)
+	baseData := m.BaseReconfigure
+	baseData.ConsulAddress = address
+	registry, err := NewRegistry(baseData)
+	if err != nil {
+		return err
 	}
-	servicesUrl := fmt.Sprintf("%s/v1/catalog/services", address)
-	resp, err := http.Get(servicesUrl)
+	services, err := registry.GetServices()
 	if err != nil {
-		return fmt.Errorf("Could not retrieve the list of services from Consul running on %s\n%s", address, err.Error())
+		return err
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	var data map[string]interface{}
-	json.Unmarshal(body, &data)
-	logPrintf("\tFound %d services", len(data))
+	logPrintf("\tFound %d services", len(services))
 
 	c := make(chan ServiceReconfigure)
-	for key, _ := range data {
-		go m.getService(address, key, c)
+	for _, name := range services {
+		go m.getService(registry, name, c)
 	}
-	for i := 0; i < len(data); i++ {
+	for i := 0; i < len(services); i++ {
 		s := <-c
-		if len(s.ServicePath) > 0 {
+		if isServiceConfigured(s) {
 			logPrintf("\tConfiguring %s", s.ServiceName)
-			m.createConfig(m.TemplatesPath, s)
+			m.createConfig(registry, m.TemplatesPath, s)
 		}
 	}
 
@@ -118,96 +257,332 @@ func (m *Reconfigure) ReloadAllServices(address string) error {
 	return proxy.Reload()
 }
 
-func (m *Reconfigure) getService(address, serviceName string, c chan ServiceReconfigure) {
+func (m *Reconfigure) getService(registry Registry, serviceName string, c chan ServiceReconfigure) {
 	sr := ServiceReconfigure{ServiceName: serviceName}
 
-	if path, ok := m.getServiceAttribute(address, serviceName, PATH_KEY); ok {
-		sr.ServicePath = strings.Split(path, ",")
-		sr.ServiceColor, _ = m.getServiceAttribute(address, serviceName, COLOR_KEY)
-		sr.ServiceDomain, _ = m.getServiceAttribute(address, serviceName, DOMAIN_KEY)
-		sr.PathType, _ = m.getServiceAttribute(address, serviceName, PATH_TYPE_KEY)
-		skipCheck, _ := m.getServiceAttribute(address, serviceName, SKIP_CHECK_KEY)
+	path, hasPath := registry.GetServiceAttribute(serviceName, PATH_KEY)
+	reqMode, hasReqMode := registry.GetServiceAttribute(serviceName, REQ_MODE_KEY)
+	consulTemplatePath, hasConsulTemplatePath := registry.GetServiceAttribute(serviceName, CONSUL_TEMPLATE_PATH_KEY)
+	if hasPath || hasReqMode || hasConsulTemplatePath {
+		if len(path) > 0 {
+			sr.ServicePath = strings.Split(path, ",")
+		}
+		sr.ReqMode = reqMode
+		sr.ConsulTemplatePath = consulTemplatePath
+		sr.ServiceColor, _ = registry.GetServiceAttribute(serviceName, COLOR_KEY)
+		if domain, _ := registry.GetServiceAttribute(serviceName, DOMAIN_KEY); len(domain) > 0 {
+			sr.ServiceDomain = strings.Split(domain, ",")
+		}
+		sr.PathType, _ = registry.GetServiceAttribute(serviceName, PATH_TYPE_KEY)
+		skipCheck, _ := registry.GetServiceAttribute(serviceName, SKIP_CHECK_KEY)
 		sr.SkipCheck, _ = strconv.ParseBool(skipCheck)
-		sr.ConsulTemplatePath, _ = m.getServiceAttribute(address, serviceName, CONSUL_TEMPLATE_PATH_KEY)
+		sr.Port, _ = registry.GetServiceAttribute(serviceName, PORT_KEY)
+		sr.ServiceCert, _ = registry.GetServiceAttribute(serviceName, CERT_KEY)
+		httpsOnly, _ := registry.GetServiceAttribute(serviceName, HTTPS_ONLY_KEY)
+		sr.HttpsOnly, _ = strconv.ParseBool(httpsOnly)
+		hsts, _ := registry.GetServiceAttribute(serviceName, HSTS_KEY)
+		sr.Hsts, _ = strconv.ParseBool(hsts)
+		sr.SrcPort, _ = registry.GetServiceAttribute(serviceName, SRC_PORT_KEY)
+		weights, _ := registry.GetServiceAttribute(serviceName, WEIGHTS_KEY)
+		sr.ServiceWeights, _ = parseServiceWeights(weights)
+		sr.ReqPathSearch, _ = registry.GetServiceAttribute(serviceName, REQ_PATH_SEARCH_KEY)
+		sr.ReqPathReplace, _ = registry.GetServiceAttribute(serviceName, REQ_PATH_REPLACE_KEY)
+		sr.StripPrefix, _ = registry.GetServiceAttribute(serviceName, STRIP_PREFIX_KEY)
+		if users, _ := registry.GetServiceAttribute(serviceName, USERS_KEY); len(users) > 0 {
+			sr.Users = strings.Split(users, ",")
+		}
+		skipDefaultUsers, _ := registry.GetServiceAttribute(serviceName, SKIP_DEFAULT_USERS_KEY)
+		sr.SkipDefaultUsers, _ = strconv.ParseBool(skipDefaultUsers)
 	}
 	c <- sr
 }
 
-func (m *Reconfigure) getServiceAttribute(address, serviceName, key string) (string, bool) {
-	url := fmt.Sprintf("%s/v1/kv/docker-flow/%s/%s?raw", address, serviceName, key)
-	resp, _ := http.Get(url)
-	if resp.StatusCode != http.StatusOK {
-		return "", false
+// isServiceConfigured tells whether the service has enough data to be added to the proxy.
+func isServiceConfigured(sr ServiceReconfigure) bool {
+	return len(sr.ServicePath) > 0 || len(sr.ConsulTemplatePath) > 0 || sr.ReqMode == "tcp"
+}
+
+func (m *Reconfigure) validate(sr ServiceReconfigure) error {
+	if err := m.validateMode(sr); err != nil {
+		return err
+	}
+	if err := m.validateWeights(sr); err != nil {
+		return err
+	}
+	if err := m.validatePathRewrite(sr); err != nil {
+		return err
+	}
+	return m.validateUsers(sr)
+}
+
+func (m *Reconfigure) copyTemplates(dest string) error {
+	files, err := readConfigsDir(m.TemplatesPath)
+	if err != nil {
+		return fmt.Errorf("Could not read the directory %s\n%s", m.TemplatesPath, err.Error())
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	return string(body), true
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		path := fmt.Sprintf("%s/%s", m.TemplatesPath, fi.Name())
+		content, err := readConfigsFile(path)
+		if err != nil {
+			return fmt.Errorf("Could not read the file %s\n%s", path, err.Error())
+		}
+		if err := writeServiceConfigFile(fmt.Sprintf("%s/%s", dest, fi.Name()), content, 0664); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (m *Reconfigure) createConfig(templatesPath string, sr ServiceReconfigure) error {
+func (m *Reconfigure) createConfig(registry Registry, templatesPath string, sr ServiceReconfigure) error {
 	logPrintf("Creating configuration for the service %s", sr.ServiceName)
 	templateContent, err := m.GetConsulTemplate(sr)
 	if err != nil {
 		return err
 	}
-	src := fmt.Sprintf("%s/%s", templatesPath, "service-formatted.ctmpl")
-	writeConsulTemplateFile(src, []byte(templateContent), 0664)
-	dest := fmt.Sprintf("%s/%s", templatesPath, sr.ServiceName)
-	if err := m.runConsulTemplateCmd(src, dest); err != nil {
+	content, err := NewConsulTemplate(registry).Render(templateContent)
+	if err != nil {
 		return err
 	}
-	return nil
+	dest := fmt.Sprintf("%s/%s.cfg", templatesPath, sr.ServiceName)
+	if err := writeServiceConfigFile(dest, []byte(content), 0664); err != nil {
+		return err
+	}
+	sniDest := fmt.Sprintf("%s/%s.sni", templatesPath, sr.ServiceName)
+	if len(sr.ServiceCert) > 0 && len(sr.ServiceDomain) > 0 {
+		sni := fmt.Sprintf("%s/certs/%s.pem %s\n", m.ConfigsPath, sr.ServiceCert, strings.Join(sr.ServiceDomain, " "))
+		if err := writeServiceConfigFile(sniDest, []byte(sni), 0664); err != nil {
+			return err
+		}
+	} else {
+		osRemove(sniDest)
+	}
+	frontendDest := fmt.Sprintf("%s/%s.fe", templatesPath, sr.ServiceName)
+	if m.PerServiceFrontends || len(sr.ConsulTemplatePath) > 0 || sr.ReqMode == "tcp" {
+		osRemove(frontendDest)
+		return nil
+	}
+	return writeServiceConfigFile(frontendDest, []byte(m.getFrontendRulesFromGo(sr)), 0664)
 }
 
-func (m *Reconfigure) putToConsul(address string, sr ServiceReconfigure) error {
-	if !strings.HasPrefix(address, "http") {
-		address = fmt.Sprintf("http://%s", address)
-	}
-	c := make(chan error)
-	go m.sendPutRequest(address, sr, COLOR_KEY, sr.ServiceColor, c)
-	go m.sendPutRequest(address, sr, PATH_KEY, strings.Join(sr.ServicePath, ","), c)
-	go m.sendPutRequest(address, sr, DOMAIN_KEY, sr.ServiceDomain, c)
-	go m.sendPutRequest(address, sr, PATH_TYPE_KEY, sr.PathType, c)
-	go m.sendPutRequest(address, sr, SKIP_CHECK_KEY, fmt.Sprintf("%t", sr.SkipCheck), c)
-	go m.sendPutRequest(address, sr, CONSUL_TEMPLATE_PATH_KEY, sr.ConsulTemplatePath, c)
-	for i := 0; i < 6; i++ {
-		err := <-c
-		if err != nil {
-			return fmt.Errorf("Could not send data to Consul\n%s", err.Error())
+func (m *Reconfigure) putToRegistry(registry Registry, sr ServiceReconfigure) error {
+	return registry.PutServiceAttributes(sr.ServiceName, map[string]string{
+		COLOR_KEY:                sr.ServiceColor,
+		PATH_KEY:                 strings.Join(sr.ServicePath, ","),
+		DOMAIN_KEY:               strings.Join(sr.ServiceDomain, ","),
+		PATH_TYPE_KEY:            sr.PathType,
+		SKIP_CHECK_KEY:           fmt.Sprintf("%t", sr.SkipCheck),
+		CONSUL_TEMPLATE_PATH_KEY: sr.ConsulTemplatePath,
+		PORT_KEY:                 sr.Port,
+		CERT_KEY:                 sr.ServiceCert,
+		HTTPS_ONLY_KEY:           fmt.Sprintf("%t", sr.HttpsOnly),
+		HSTS_KEY:                 fmt.Sprintf("%t", sr.Hsts),
+		REQ_MODE_KEY:             sr.ReqMode,
+		SRC_PORT_KEY:             sr.SrcPort,
+		WEIGHTS_KEY:              formatServiceWeights(sr.ServiceWeights),
+		REQ_PATH_SEARCH_KEY:      sr.ReqPathSearch,
+		REQ_PATH_REPLACE_KEY:     sr.ReqPathReplace,
+		STRIP_PREFIX_KEY:         sr.StripPrefix,
+		USERS_KEY:                strings.Join(sr.Users, ","),
+		SKIP_DEFAULT_USERS_KEY:   fmt.Sprintf("%t", sr.SkipDefaultUsers),
+	})
+}
+
+// Shift moves the weight of the step from one color of the service to another.
+// Once the last color is left, the service is configured with that color alone.
+// The weights are read and written while holding mu so that concurrent shifts do not overwrite each other.
+func (m *Reconfigure) Shift(from, to string, step int) (ServiceReconfigure, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return ServiceReconfigure{}, err
+	}
+	c := make(chan ServiceReconfigure, 1)
+	m.getService(registry, m.ServiceName, c)
+	sr := <-c
+	if !isServiceConfigured(sr) {
+		return sr, fmt.Errorf("The service %s is not configured", m.ServiceName)
+	}
+	weights := map[string]int{}
+	for color, weight := range sr.ServiceWeights {
+		weights[color] = weight
+	}
+	if len(weights) == 0 && len(sr.ServiceColor) > 0 {
+		weights[sr.ServiceColor] = 100
+	}
+	if _, ok := weights[from]; !ok {
+		return sr, fmt.Errorf("The service %s does not have the color %s", m.ServiceName, from)
+	}
+	moved := step
+	if weights[from] < moved {
+		moved = weights[from]
+	}
+	weights[from] -= moved
+	weights[to] += moved
+	if weights[from] == 0 {
+		delete(weights, from)
+	}
+	sr.ServiceWeights = weights
+	if len(weights) == 1 {
+		for color := range weights {
+			sr.ServiceColor = color
+		}
+		sr.ServiceWeights = nil
+	}
+	m.ServiceReconfigure = sr
+	if err := m.apply(); err != nil {
+		return sr, err
+	}
+	m.recordHistory("reconfigure", sr)
+	return sr, nil
+}
+
+func (m *Reconfigure) validateWeights(sr ServiceReconfigure) error {
+	if len(sr.ServiceWeights) == 0 {
+		return nil
+	}
+	total := 0
+	for color, weight := range sr.ServiceWeights {
+		if len(color) == 0 {
+			return fmt.Errorf("The color of the service weight is mandatory")
 		}
+		if weight < 0 || weight > 256 {
+			return fmt.Errorf("The weight of the color %s must be between 0 and 256", color)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("At least one color must have a weight greater than 0")
 	}
 	return nil
 }
 
-func (m *Reconfigure) sendPutRequest(address string, sr ServiceReconfigure, key string, value string, c chan error) {
-	url := fmt.Sprintf("%s/v1/kv/docker-flow/%s/%s", address, sr.ServiceName, key)
-	client := &http.Client{}
-	request, _ := http.NewRequest("PUT", url, strings.NewReader(value))
-	_, err := client.Do(request)
-	c <- err
+// Regular expressions are validated by HAProxy when the configuration is checked. Control characters are rejected
+// since they would break the reqrep line into lines HAProxy would interpret as other directives.
+func (m *Reconfigure) validatePathRewrite(sr ServiceReconfigure) error {
+	if len(sr.ReqPathSearch) == 0 && len(sr.ReqPathReplace) == 0 && len(sr.StripPrefix) == 0 {
+		return nil
+	}
+	if sr.ReqMode == "tcp" {
+		return fmt.Errorf("The path of requests to tcp services cannot be rewritten")
+	}
+	if len(sr.ReqPathReplace) > 0 && len(sr.ReqPathSearch) == 0 {
+		return fmt.Errorf("reqPathSearch is mandatory when reqPathReplace is specified")
+	}
+	for _, field := range [][]string{
+		{"reqPathSearch", sr.ReqPathSearch},
+		{"reqPathReplace", sr.ReqPathReplace},
+		{"stripPrefix", sr.StripPrefix},
+	} {
+		if strings.IndexFunc(field[1], unicode.IsControl) >= 0 {
+			return fmt.Errorf("The %s %q cannot contain control characters", field[0], field[1])
+		}
+	}
+	if len(sr.StripPrefix) > 0 && (!strings.HasPrefix(sr.StripPrefix, "/") || strings.ContainsAny(sr.StripPrefix, " \t")) {
+		return fmt.Errorf("The stripPrefix %s must start with / and cannot contain spaces", sr.StripPrefix)
+	}
+	return nil
 }
 
-func (m *Reconfigure) runConsulTemplateCmd(src, dest string) error {
-	template := fmt.Sprintf(`%s:%s.cfg`, src, dest)
-	cmdArgs := []string{
-		"-consul", m.getConsulAddress(m.ConsulAddress),
-		"-template", template,
-		"-once",
+func (m *Reconfigure) validateUsers(sr ServiceReconfigure) error {
+	if len(sr.Users) > 0 && sr.ReqMode == "tcp" {
+		return fmt.Errorf("Users cannot be specified for tcp services")
 	}
-	cmd := exec.Command("consul-template", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmdRunConsul(cmd); err != nil {
-		return fmt.Errorf("Command %v\n%v\n", cmd, err)
+	if _, err := parseUsers(sr.Users); err != nil {
+		return err
+	}
+	if _, err := parseUsers(m.DefaultUsers); err != nil {
+		return fmt.Errorf("The default users are not valid\n%s", err.Error())
 	}
 	return nil
 }
 
-func (m *Reconfigure) getConsulAddress(address string) string {
-	a := strings.ToLower(address)
-	a = strings.TrimLeft(a, "http://")
-	a = strings.TrimLeft(a, "https://")
-	return a
+var userNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9._@-]+$`)
+var passHashRegexp = regexp.MustCompile(`^\$[a-zA-Z0-9./$=]+$`)
+
+// Only hashed passwords are accepted so that plain text passwords are never stored in the registry or the proxy configuration.
+func parseUsers(users []string) ([]User, error) {
+	list := []User{}
+	for _, user := range users {
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) != 2 || !userNameRegexp.MatchString(parts[0]) {
+			return nil, fmt.Errorf("The user %s is not formatted as name:hash", user)
+		}
+		if !passHashRegexp.MatchString(parts[1]) {
+			return nil, fmt.Errorf("The password of the user %s is not hashed with crypt(3)", parts[0])
+		}
+		list = append(list, User{Name: parts[0], PassHash: parts[1]})
+	}
+	return list, nil
+}
+
+func parseServiceWeights(value string) (map[string]int, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	weights := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("The service weight %s is not formatted as color:weight", pair)
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("The service weight %s is not formatted as color:weight", pair)
+		}
+		weights[parts[0]] = weight
+	}
+	return weights, nil
+}
+
+func formatServiceWeights(weights map[string]int) string {
+	pairs := []string{}
+	for color, weight := range weights {
+		pairs = append(pairs, fmt.Sprintf("%s:%d", color, weight))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Ports bound by the shared frontend, haproxy.tmpl, or other services cannot be claimed by a tcp service.
+func (m *Reconfigure) validateMode(sr ServiceReconfigure) error {
+	switch sr.ReqMode {
+	case "", "http":
+		return nil
+	case "tcp":
+	default:
+		return fmt.Errorf("The reqMode %s is not supported", sr.ReqMode)
+	}
+	if port, err := strconv.Atoi(sr.SrcPort); err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("The srcPort %s is not valid. It is mandatory when reqMode is tcp", sr.SrcPort)
+	}
+	if !m.PerServiceFrontends && (sr.SrcPort == "80" || sr.SrcPort == "443") {
+		return fmt.Errorf("The port %s is already used by the services frontend", sr.SrcPort)
+	}
+	files, err := readConfigsDir(m.TemplatesPath)
+	if err != nil {
+		return fmt.Errorf("Could not read the directory %s\n%s", m.TemplatesPath, err.Error())
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if name == fmt.Sprintf("%s.cfg", sr.ServiceName) || (name != "haproxy.tmpl" && !strings.HasSuffix(name, ".cfg")) {
+			continue
+		}
+		content, err := readConfigsFile(fmt.Sprintf("%s/%s", m.TemplatesPath, name))
+		if err != nil {
+			return fmt.Errorf("Could not read the file %s\n%s", name, err.Error())
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 1 && fields[0] == "bind" && fields[1][strings.LastIndex(fields[1], ":")+1:] == sr.SrcPort {
+				return fmt.Errorf("The port %s is already used by %s", sr.SrcPort, strings.TrimSuffix(name, ".cfg"))
+			}
+		}
+	}
+	return nil
 }
 
 func (m *Reconfigure) GetConsulTemplate(sr ServiceReconfigure) (string, error) {
@@ -218,16 +593,92 @@ func (m *Reconfigure) GetConsulTemplate(sr ServiceReconfigure) (string, error) {
 }
 
 func (m *Reconfigure) getConsulTemplateFromGo(sr ServiceReconfigure) string {
+	src := `{{if .UserList}}userlist {{.ServiceName}}-users{{range .UserList}}
+	user {{.Name}} password {{.PassHash}}{{end}}
+
+{{end}}backend {{.ServiceName}}-be{{if eq .ReqMode "tcp"}}
+	mode tcp{{else if .Hsts}}
+	http-response set-header Strict-Transport-Security "max-age=31536000; includeSubDomains" if { ssl_fc }{{end}}{{if .UserList}}
+	http-request auth realm {{.ServiceName}} if !{ http_auth({{.ServiceName}}-users) }{{end}}{{.ReqPathRules}}{{if .ServiceWeights}}{{range $color, $weight := .ServiceWeights}}{{if $.Port}}
+	server {{$.ServiceName}}-{{$color}} {{$.ServiceName}}-{{$color}}:{{$.Port}}{{if eq $.SkipCheck false}} check{{end}} weight {{$weight}}{{else}}
+	{{"{{"}}range $i, $e := service "{{$.ServiceName}}-{{$color}}" "any"{{"}}"}}
+	server {{"{{$e.Node}}"}}_{{$color}}{{"_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}}"}}{{if eq $.SkipCheck false}} check{{end}} weight {{$weight}}
+	{{"{{end}}"}}{{end}}{{end}}{{else if .Port}}
+	server {{.FullServiceName}} {{.FullServiceName}}:{{.Port}}{{if eq .SkipCheck false}} check{{end}}{{else}}
+	{{"{{"}}range $i, $e := service "{{.FullServiceName}}" "any"{{"}}"}}
+	server {{"{{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}}"}}{{if eq .SkipCheck false}} check{{end}}
+	{{"{{end}}"}}{{end}}`
+	sr.UserList = nil
+	if sr.ReqMode != "tcp" {
+		users := sr.Users
+		if len(users) == 0 && !sr.SkipDefaultUsers {
+			users = m.DefaultUsers
+		}
+		sr.UserList, _ = parseUsers(users)
+	}
+	if sr.ReqMode == "tcp" {
+		src = tcpFrontendTemplate + `
+
+` + src
+	} else if m.PerServiceFrontends {
+		src = `frontend {{.ServiceName}}-fe
+	bind *:80
+	bind *:443
+	option http-server-close
+` + frontendRulesTemplate + `
+
+` + src
+	}
+	return m.executeTemplate(src, sr)
+}
+
+func (m *Reconfigure) getFrontendRulesFromGo(sr ServiceReconfigure) string {
+	return m.executeTemplate(frontendRulesTemplate, sr)
+}
+
+func (m *Reconfigure) executeTemplate(src string, sr ServiceReconfigure) string {
 	sr.Acl = ""
 	sr.AclCondition = ""
 	if len(sr.ServiceDomain) > 0 {
-		sr.Acl = fmt.Sprintf(`
-	acl domain_%s hdr_dom(host) -i %s`,
-			sr.ServiceName,
-			sr.ServiceDomain,
-		)
+		domains := []string{}
+		suffixes := []string{}
+		for _, domain := range sr.ServiceDomain {
+			if strings.HasPrefix(domain, "*.") {
+				suffixes = append(suffixes, strings.TrimPrefix(domain, "*"))
+			} else {
+				domains = append(domains, domain)
+			}
+		}
+		if len(domains) > 0 {
+			sr.Acl += fmt.Sprintf(`
+	acl domain_%s req.hdr(host),field(1,:) -i %s`,
+				sr.ServiceName,
+				strings.Join(domains, " "),
+			)
+		}
+		if len(suffixes) > 0 {
+			sr.Acl += fmt.Sprintf(`
+	acl domain_%s req.hdr(host),field(1,:) -m end -i %s`,
+				sr.ServiceName,
+				strings.Join(suffixes, " "),
+			)
+		}
 		sr.AclCondition = fmt.Sprintf(" domain_%s", sr.ServiceName)
 	}
+	sr.ReqPathRules = ""
+	if len(sr.StripPrefix) > 0 {
+		prefix := regexp.QuoteMeta(strings.TrimSuffix(sr.StripPrefix, "/"))
+		sr.ReqPathRules += fmt.Sprintf(`
+	reqrep ^([^\ :]*)\ %s/(.*)     \1\ /\2
+	reqrep ^([^\ :]*)\ %s([?\ ].*)     \1\ /\2`, prefix, prefix)
+	}
+	if len(sr.ReqPathSearch) > 0 {
+		sr.ReqPathRules += fmt.Sprintf(`
+	reqrep ^([^\ :]*)\ %s     \1\ %s`,
+			strings.Replace(sr.ReqPathSearch, " ", "\\ ", -1),
+			strings.Replace(sr.ReqPathReplace, " ", "\\ ", -1),
+		)
+	}
 	if len(sr.ServiceColor) > 0 {
 		sr.FullServiceName = fmt.Sprintf("%s-%s", sr.ServiceName, sr.ServiceColor)
 	} else {
@@ -236,17 +687,6 @@ func (m *Reconfigure) getConsulTemplateFromGo(sr ServiceReconfigure) string {
 	if len(sr.PathType) == 0 {
 		sr.PathType = "path_beg"
 	}
-	src := `frontend {{.ServiceName}}-fe
-	bind *:80
-	bind *:443
-	option http-server-close
-	acl url_{{.ServiceName}}{{range .ServicePath}} {{$.PathType}} {{.}}{{end}}{{.Acl}}
-	use_backend {{.ServiceName}}-be if url_{{.ServiceName}}{{.AclCondition}}
-
-backend {{.ServiceName}}-be
-	{{"{{"}}range $i, $e := service "{{.FullServiceName}}" "any"{{"}}"}}
-	server {{"{{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}}"}}{{if eq .SkipCheck false}} check{{end}}
-	{{"{{end}}"}}`
 	tmpl, _ := template.New("consulTemplate").Parse(src)
 	var ct bytes.Buffer
 	tmpl.Execute(&ct, sr)
@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type ReconfigureTestSuite struct {
@@ -29,12 +30,13 @@ type ReconfigureTestSuite struct {
 	Server            *httptest.Server
 	PutPathResponse   string
 	ConsulRequestBody ServiceReconfigure
+	ServiceConfig     string
 }
 
 func (s *ReconfigureTestSuite) SetupTest() {
 	s.Pid = "123"
 	s.ServicePath = []string{"path/to/my/service/api", "path/to/my/other/service/api"}
-	s.ServiceDomain = "my-domain.com"
+	s.ServiceDomain = []string{"my-domain.com"}
 	s.ConfigsPath = "path/to/configs/dir"
 	s.TemplatesPath = "test_configs/tmpl"
 	s.ConsulTemplate = `frontend myService-fe
@@ -48,24 +50,35 @@ backend myService-be
 	{{range $i, $e := service "myService" "any"}}
 	server {{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check
 	{{end}}`
+	s.ServiceConfig = `frontend myService-fe
+	bind *:80
+	bind *:443
+	option http-server-close
+	acl url_myService path_beg path/to/my/service/api path_beg path/to/my/other/service/api
+	use_backend myService-be if url_myService
+
+backend myService-be
+	
+	server node1_0_1111 10.0.0.1:1111 check
+	
+	server node2_1_2222 10.0.0.22:2222 check
+	`
 	cmdRunHa = func(cmd *exec.Cmd) error {
 		return nil
 	}
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		return nil
-	}
 	readPidFile = func(fileName string) ([]byte, error) {
 		return []byte(s.Pid), nil
 	}
-	writeConsulTemplateFile = func(fileName string, data []byte, perm os.FileMode) error {
+	writeServiceConfigFile = func(fileName string, data []byte, perm os.FileMode) error {
 		return nil
 	}
 	s.ConsulAddress = s.Server.URL
 	s.reconfigure = Reconfigure{
 		BaseReconfigure: BaseReconfigure{
-			ConsulAddress: s.ConsulAddress,
-			TemplatesPath: s.TemplatesPath,
-			ConfigsPath:   s.ConfigsPath,
+			ConsulAddress:       s.ConsulAddress,
+			TemplatesPath:       s.TemplatesPath,
+			ConfigsPath:         s.ConfigsPath,
+			PerServiceFrontends: true,
 		},
 		ServiceReconfigure: ServiceReconfigure{
 			ServiceName: s.ServiceName,
@@ -90,7 +103,7 @@ func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsHost() {
 	bind *:443
 	option http-server-close
 	acl url_myService path_beg path/to/my/service/api path_beg path/to/my/other/service/api
-	acl domain_myService hdr_dom(host) -i my-domain.com
+	acl domain_myService req.hdr(host),field(1,:) -i my-domain.com
 	use_backend myService-be if url_myService domain_myService
 
 backend myService-be
@@ -103,6 +116,16 @@ backend myService-be
 	s.Equal(s.ConsulTemplate, actual)
 }
 
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsMultipleAndWildcardHosts() {
+	s.reconfigure.ServiceDomain = []string{"my-domain.com", "*.my-domain.com", "other-domain.com", "*.other-domain.com"}
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, `
+	acl domain_myService req.hdr(host),field(1,:) -i my-domain.com other-domain.com
+	acl domain_myService req.hdr(host),field(1,:) -m end -i .my-domain.com .other-domain.com
+	use_backend myService-be if url_myService domain_myService`)
+}
+
 func (s ReconfigureTestSuite) Test_GetConsulTemplate_UsesPathReg() {
 	s.ConsulTemplate = strings.Replace(s.ConsulTemplate, "path_beg", "path_reg", -1)
 	s.reconfigure.PathType = "path_reg"
@@ -128,6 +151,138 @@ func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotSetCheckWhenSkipChec
 	s.Equal(s.ConsulTemplate, actual)
 }
 
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_UsesServiceAddress_WhenPortIsSet() {
+	s.reconfigure.ServiceColor = "black"
+	s.reconfigure.Port = "8080"
+	expected := fmt.Sprintf(`backend %s-be
+	server %s-black %s-black:8080 check`, s.ServiceName, s.ServiceName, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.True(strings.HasSuffix(actual, expected), actual)
+	s.NotContains(actual, "range")
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsServersOfAllColors_WhenServiceWeightsAreSet() {
+	s.reconfigure.ServiceColor = "black"
+	s.reconfigure.ServiceWeights = map[string]int{"green": 10, "blue": 90}
+	expected := fmt.Sprintf(`backend %s-be
+	{{range $i, $e := service "%s-blue" "any"}}
+	server {{$e.Node}}_blue_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check weight 90
+	{{end}}
+	{{range $i, $e := service "%s-green" "any"}}
+	server {{$e.Node}}_green_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check weight 10
+	{{end}}`, s.ServiceName, s.ServiceName, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.True(strings.HasSuffix(actual, expected), actual)
+	s.NotContains(actual, "black")
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_UsesServiceAddressesOfAllColors_WhenServiceWeightsAndPortAreSet() {
+	s.reconfigure.Port = "8080"
+	s.reconfigure.ServiceWeights = map[string]int{"green": 10, "blue": 90}
+	expected := fmt.Sprintf(`backend %s-be
+	server %s-blue %s-blue:8080 check weight 90
+	server %s-green %s-green:8080 check weight 10`, s.ServiceName, s.ServiceName, s.ServiceName, s.ServiceName, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.True(strings.HasSuffix(actual, expected), actual)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsStripPrefixRules_WhenStripPrefixIsSet() {
+	s.reconfigure.StripPrefix = "/api/v1.0/"
+	expected := fmt.Sprintf(`backend %s-be
+	reqrep ^([^\ :]*)\ /api/v1\.0/(.*)     \1\ /\2
+	reqrep ^([^\ :]*)\ /api/v1\.0([?\ ].*)     \1\ /\2
+`, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, expected)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsReqPathRule_WhenReqPathSearchIsSet() {
+	s.reconfigure.ReqPathSearch = "/demo/(.+)"
+	s.reconfigure.ReqPathReplace = "/new demo/\\1"
+	expected := fmt.Sprintf(`backend %s-be
+	reqrep ^([^\ :]*)\ /demo/(.+)     \1\ /new\ demo/\1
+`, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, expected)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotEscapeSpecialCharacters() {
+	s.reconfigure.ServicePath = []string{"/demo&other's"}
+	s.reconfigure.ReqPathSearch = "/demo/<id>"
+	s.reconfigure.ReqPathReplace = "/new?a=1&b=2"
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, "path_beg /demo&other's")
+	s.Contains(actual, `reqrep ^([^\ :]*)\ /demo/<id>     \1\ /new?a=1&b=2`)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsUserListAndAuth_WhenUsersAreSet() {
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.Users = []string{"admin:$6$salt$hash", "other:$1$salt$hash"}
+	expected := fmt.Sprintf(`userlist %s-users
+	user admin password $6$salt$hash
+	user other password $1$salt$hash
+
+backend %s-be
+	http-request auth realm %s if !{ http_auth(%s-users) }
+`, s.ServiceName, s.ServiceName, s.ServiceName, s.ServiceName)
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.True(strings.HasPrefix(actual, expected), actual)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_UsesDefaultUsers_WhenUsersAreNotSet() {
+	s.reconfigure.DefaultUsers = []string{"default:$6$salt$hash"}
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, "\tuser default password $6$salt$hash\n")
+	s.Contains(actual, fmt.Sprintf("http_auth(%s-users)", s.ServiceName))
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotUseDefaultUsers_WhenUsersAreSet() {
+	s.reconfigure.DefaultUsers = []string{"default:$6$salt$hash"}
+	s.reconfigure.Users = []string{"admin:$6$salt$hash"}
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, "\tuser admin password $6$salt$hash\n")
+	s.NotContains(actual, "default")
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotUseDefaultUsers_WhenSkipDefaultUsersIsTrue() {
+	s.reconfigure.DefaultUsers = []string{"default:$6$salt$hash"}
+	s.reconfigure.SkipDefaultUsers = true
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.NotContains(actual, "userlist")
+	s.NotContains(actual, "http_auth")
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotAddAuth_WhenReqModeIsTcp() {
+	s.reconfigure.DefaultUsers = []string{"default:$6$salt$hash"}
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "5432"
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.NotContains(actual, "userlist")
+	s.NotContains(actual, "http_auth")
+}
+
 func (s ReconfigureTestSuite) Test_GetConsulTemplate_ReturnsFileContent_WhenConsulTemplatePathIsSet() {
 	expected := "This is content of a template"
 	readTemplateFileOrig := readTemplateFile
@@ -155,26 +310,213 @@ func (s ReconfigureTestSuite) Test_GetConsulTemplate_ReturnsError_WhenConsulTemp
 	s.Error(actual)
 }
 
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_ReturnsOnlyBackend_WhenPerServiceFrontendsIsFalse() {
+	s.reconfigure.PerServiceFrontends = false
+	expected := `backend myService-be
+	{{range $i, $e := service "myService" "any"}}
+	server {{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check
+	{{end}}`
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Equal(expected, actual)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsHttpsRedirect_WhenHttpsOnlyIsTrue() {
+	s.reconfigure.ServiceDomain = s.ServiceDomain
+	s.reconfigure.HttpsOnly = true
+	expected := `
+	redirect scheme https if !{ ssl_fc } !{ path_beg /.well-known/acme-challenge/ } url_myService domain_myService
+	use_backend myService-be if url_myService domain_myService`
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Contains(actual, expected)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_AddsHstsHeader_WhenHstsIsTrue() {
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.Hsts = true
+	expected := `backend myService-be
+	http-response set-header Strict-Transport-Security "max-age=31536000; includeSubDomains" if { ssl_fc }
+	{{range $i, $e := service "myService" "any"}}`
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.True(strings.HasPrefix(actual, expected), actual)
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_DoesNotAddHttpsRules_WhenHttpsOnlyAndHstsAreFalse() {
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.NotContains(actual, "redirect")
+	s.NotContains(actual, "Strict-Transport-Security")
+}
+
+func (s ReconfigureTestSuite) Test_GetConsulTemplate_ReturnsTcpFrontendAndBackend_WhenReqModeIsTcp() {
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "5432"
+	s.reconfigure.Hsts = true
+	expected := `frontend myService-fe
+	bind *:5432
+	mode tcp
+	default_backend myService-be
+
+backend myService-be
+	mode tcp
+	{{range $i, $e := service "myService" "any"}}
+	server {{$e.Node}}_{{$i}}_{{$e.Port}} {{$e.Address}}:{{$e.Port}} check
+	{{end}}`
+
+	actual, _ := s.reconfigure.GetConsulTemplate(s.reconfigure.ServiceReconfigure)
+
+	s.Equal(expected, actual)
+}
+
 // Execute
 
-func (s ReconfigureTestSuite) Test_Execute_CreatesConsulTemplate() {
+func (s ReconfigureTestSuite) Test_Execute_RemovesFrontendRulesFile_WhenReqModeIsTcp() {
+	var actual []string
+	osRemove = func(name string) error {
+		actual = append(actual, name)
+		return nil
+	}
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "5432"
+
+	s.reconfigure.Execute([]string{})
+
+	s.Contains(actual, fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName))
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenReqModeIsNotSupported() {
+	s.reconfigure.ReqMode = "udp"
+
+	s.Error(s.reconfigure.Execute([]string{}))
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenSrcPortIsNotValid() {
+	s.reconfigure.ReqMode = "tcp"
+	for _, port := range []string{"", "abc", "0", "70000"} {
+		s.reconfigure.SrcPort = port
+
+		s.Error(s.reconfigure.Execute([]string{}))
+	}
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenSrcPortIsUsedBySharedFrontend() {
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "443"
+
+	s.Error(s.reconfigure.Execute([]string{}))
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenSrcPortIsUsedByAnotherService() {
+	dir, _ := ioutil.TempDir("", "reconfigure")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(fmt.Sprintf("%s/postgres.cfg", dir), []byte(`frontend postgres-fe
+	bind *:5432
+	mode tcp`), 0664)
+	ioutil.WriteFile(fmt.Sprintf("%s/%s.cfg", dir, s.ServiceName), []byte(`frontend myService-fe
+	bind *:6543`), 0664)
+	s.reconfigure.TemplatesPath = dir
+	s.reconfigure.ReqMode = "tcp"
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	s.reconfigure.SrcPort = "5432"
+	err := s.reconfigure.Execute([]string{})
+
+	s.Error(err)
+	s.Contains(err.Error(), "postgres")
+	mockObj.AssertNotCalled(s.T(), "CreateConfigFromTemplates", mock.Anything, mock.Anything)
+
+	s.reconfigure.SrcPort = "6543"
+	s.NoError(s.reconfigure.Execute([]string{}))
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenServiceWeightIsNotValid() {
+	for _, weights := range []map[string]int{
+		{"blue": 257},
+		{"blue": -1},
+		{"blue": 0, "green": 0},
+		{"": 10},
+	} {
+		s.reconfigure.ServiceWeights = weights
+		err := s.reconfigure.Execute([]string{})
+		s.Error(err, "%v", weights)
+	}
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenPathRewriteIsNotValid() {
+	for _, sr := range []ServiceReconfigure{
+		{ReqPathSearch: "/demo/\n\treqdeny ."},
+		{ReqPathSearch: "/demo/", ReqPathReplace: "/\r"},
+		{StripPrefix: "/api\x00"},
+		{ReqPathReplace: "/"},
+		{StripPrefix: "api/v1"},
+		{StripPrefix: "/api v1"},
+		{StripPrefix: "/api/v1", ReqMode: "tcp", SrcPort: "5432"},
+	} {
+		sr.ServiceName = s.ServiceName
+		sr.ServicePath = s.ServicePath
+		s.reconfigure.ServiceReconfigure = sr
+
+		err := s.reconfigure.Execute([]string{})
+
+		s.Error(err, "%v", sr)
+	}
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenUsersAreNotValid() {
+	for _, users := range [][]string{
+		{"admin"},
+		{"admin:password"},
+		{"ad min:$6$salt$hash"},
+		{"admin:$6$salt hash"},
+	} {
+		s.reconfigure.Users = users
+		err := s.reconfigure.Execute([]string{})
+		s.Error(err, "%v", users)
+	}
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenDefaultUsersAreNotValid() {
+	s.reconfigure.DefaultUsers = []string{"admin:password"}
+
+	err := s.reconfigure.Execute([]string{})
+
+	s.Error(err)
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenUsersAreSetForTcpService() {
+	s.reconfigure.Users = []string{"admin:$6$salt$hash"}
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "5432"
+
+	err := s.reconfigure.Execute([]string{})
+
+	s.Error(err)
+}
+
+func (s ReconfigureTestSuite) Test_Execute_WritesRenderedConfigToFile() {
 	var actual string
-	writeConsulTemplateFile = func(filename string, data []byte, perm os.FileMode) error {
-		if len(actual) == 0 {
-			actual = string(data)
-		}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
 		return nil
 	}
 
 	s.reconfigure.Execute([]string{})
 
-	s.Equal(s.ConsulTemplate, actual)
+	s.Equal(s.ServiceConfig, actual)
 }
 
-func (s ReconfigureTestSuite) Test_Execute_WritesTemplateToFile() {
+func (s ReconfigureTestSuite) Test_Execute_WritesServiceConfigFile() {
 	var actual string
-	expected := fmt.Sprintf("%s/%s", s.TemplatesPath, ServiceTemplateFilename)
-	writeConsulTemplateFile = func(filename string, data []byte, perm os.FileMode) error {
+	expected := fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
 		actual = filename
 		return nil
 	}
@@ -184,93 +526,113 @@ func (s ReconfigureTestSuite) Test_Execute_WritesTemplateToFile() {
 	s.Equal(expected, actual)
 }
 
-func (s ReconfigureTestSuite) Test_Execute_SetsFilePermissions() {
-	var actual os.FileMode
-	var expected os.FileMode = 0664
-	writeConsulTemplateFile = func(filename string, data []byte, perm os.FileMode) error {
-		actual = perm
+func (s ReconfigureTestSuite) Test_Execute_WritesFrontendRulesFile_WhenPerServiceFrontendsIsFalse() {
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
 		return nil
 	}
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.ServiceDomain = s.ServiceDomain
+	expected := `	acl url_myService path_beg path/to/my/service/api path_beg path/to/my/other/service/api
+	acl domain_myService req.hdr(host),field(1,:) -i my-domain.com
+	use_backend myService-be if url_myService domain_myService`
 
 	s.reconfigure.Execute([]string{})
 
-	s.Equal(expected, actual)
+	s.Equal(expected, actual[fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName)])
+	s.NotContains(actual[fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)], "frontend")
 }
 
-func (s ReconfigureTestSuite) Test_Execute_RunsConsulTemplate() {
-	actual := ReconfigureTestSuite{}.mockConsulExecCmd()
-	expected := []string{
-		"consul-template",
-		"-consul",
-		strings.Replace(s.ConsulAddress, "http://", "", -1),
-		"-template",
-		fmt.Sprintf(
-			`%s/%s:%s/%s.cfg`,
-			s.TemplatesPath,
-			ServiceTemplateFilename,
-			s.TemplatesPath,
-			s.ServiceName,
-		),
-		"-once",
+func (s ReconfigureTestSuite) Test_Execute_RemovesFrontendRulesFile_WhenConsulTemplatePathIsSet() {
+	osRemoveOrig := osRemove
+	defer func() { osRemove = osRemoveOrig }()
+	actual := []string{}
+	osRemove = func(name string) error {
+		actual = append(actual, name)
+		return nil
 	}
+	s.reconfigure.PerServiceFrontends = false
+	s.reconfigure.ConsulTemplatePath = "test_configs/tmpl/my-service.tmpl"
 
 	s.reconfigure.Execute([]string{})
 
-	s.Equal(expected, *actual)
+	s.Contains(actual, fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName))
 }
 
-func (s ReconfigureTestSuite) Test_Execute_RunsConsulTemplateWithTrimmedHttp() {
-	actual := ReconfigureTestSuite{}.mockConsulExecCmd()
-	expected := []string{
-		"consul-template",
-		"-consul",
-		strings.Replace(s.ConsulAddress, "http://", "", -1),
-		"-template",
-		fmt.Sprintf(
-			`%s/%s:%s/%s.cfg`,
-			s.TemplatesPath,
-			ServiceTemplateFilename,
-			s.TemplatesPath,
-			s.ServiceName,
-		),
-		"-once",
+func (s ReconfigureTestSuite) Test_Execute_WritesSniFile_WhenServiceCertIsSet() {
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
 	}
-	s.reconfigure.ConsulAddress = fmt.Sprintf("HttP://%s", s.ConsulAddress)
+	s.reconfigure.ServiceDomain = s.ServiceDomain
+	s.reconfigure.ServiceCert = "my-cert"
 
 	s.reconfigure.Execute([]string{})
 
-	s.Equal(expected, *actual)
+	s.Equal(
+		fmt.Sprintf("%s/certs/my-cert.pem %s\n", s.ConfigsPath, strings.Join(s.ServiceDomain, " ")),
+		actual[fmt.Sprintf("%s/%s.sni", s.TemplatesPath, s.ServiceName)],
+	)
 }
 
-func (s ReconfigureTestSuite) Test_Execute_RunsConsulTemplateWithTrimmedHttps() {
-	actual := ReconfigureTestSuite{}.mockConsulExecCmd()
-	expected := []string{
-		"consul-template",
-		"-consul",
-		strings.Replace(s.ConsulAddress, "http://", "", -1),
-		"-template",
-		fmt.Sprintf(
-			`%s/%s:%s/%s.cfg`,
-			s.TemplatesPath,
-			ServiceTemplateFilename,
-			s.TemplatesPath,
-			s.ServiceName,
-		),
-		"-once",
+func (s ReconfigureTestSuite) Test_Execute_RemovesSniFile_WhenServiceCertIsNotSet() {
+	osRemoveOrig := osRemove
+	defer func() { osRemove = osRemoveOrig }()
+	actual := []string{}
+	osRemove = func(name string) error {
+		actual = append(actual, name)
+		return nil
 	}
-	s.reconfigure.ConsulAddress = fmt.Sprintf("HttPs://%s", s.ConsulAddress)
-	s.reconfigure.ConsulAddress = s.ConsulAddress
 
 	s.reconfigure.Execute([]string{})
 
-	s.Equal(expected, *actual)
+	s.Contains(actual, fmt.Sprintf("%s/%s.sni", s.TemplatesPath, s.ServiceName))
 }
 
-func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenConsulTemplateCommandFails() {
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		return fmt.Errorf("This is an error")
+func (s ReconfigureTestSuite) Test_Execute_SetsFilePermissions() {
+	var actual os.FileMode
+	var expected os.FileMode = 0664
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = perm
+		return nil
 	}
 
+	s.reconfigure.Execute([]string{})
+
+	s.Equal(expected, actual)
+}
+
+func (s ReconfigureTestSuite) Test_Execute_AddsHttpToConsulAddressWhenRendering() {
+	var actual string
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = string(data)
+		return nil
+	}
+	s.reconfigure.ConsulAddress = strings.Replace(s.ConsulAddress, "http://", "", -1)
+
+	s.reconfigure.Execute([]string{})
+
+	s.Equal(s.ServiceConfig, actual)
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenConsulIsNotAvailable() {
+	s.reconfigure.ConsulAddress = "http:///THIS/URL/DOES/NOT/EXIST"
+
+	err := s.reconfigure.Execute([]string{})
+
+	s.Error(err)
+}
+
+func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenTemplateCannotBeParsed() {
+	readTemplateFileOrig := readTemplateFile
+	defer func() { readTemplateFile = readTemplateFileOrig }()
+	readTemplateFile = func(dirname string) ([]byte, error) {
+		return []byte("{{range"), nil
+	}
+	s.reconfigure.ServiceReconfigure.ConsulTemplatePath = "/path/to/my/consul/template"
+
 	err := s.reconfigure.Execute([]string{})
 
 	s.Error(err)
@@ -314,6 +676,13 @@ func (s *ReconfigureTestSuite) Test_Execute_PutsDataToConsul() {
 	s.reconfigure.SkipCheck = true
 	s.reconfigure.ServiceDomain = s.ServiceDomain
 	s.reconfigure.ConsulTemplatePath = consulTemplatePath
+	s.reconfigure.Port = "8080"
+	s.reconfigure.ServiceCert = "my-cert"
+	s.reconfigure.HttpsOnly = true
+	s.reconfigure.Hsts = true
+	s.reconfigure.ReqMode = "tcp"
+	s.reconfigure.SrcPort = "5432"
+	s.reconfigure.ServiceWeights = map[string]int{"green": 10, "blue": 90}
 	s.reconfigure.Execute([]string{})
 
 	type data struct{ key, value, expected string }
@@ -321,16 +690,224 @@ func (s *ReconfigureTestSuite) Test_Execute_PutsDataToConsul() {
 	d := []data{
 		data{"color", s.ConsulRequestBody.ServiceColor, s.ServiceColor},
 		data{"path", strings.Join(s.ConsulRequestBody.ServicePath, ","), strings.Join(s.ServicePath, ",")},
-		data{"domain", s.ConsulRequestBody.ServiceDomain, s.ServiceDomain},
+		data{"domain", strings.Join(s.ConsulRequestBody.ServiceDomain, ","), strings.Join(s.ServiceDomain, ",")},
 		data{"pathType", s.ConsulRequestBody.PathType, s.PathType},
 		data{"skipCheck", fmt.Sprintf("%t", s.ConsulRequestBody.SkipCheck), fmt.Sprintf("%t", s.SkipCheck)},
 		data{"consulTemplatePath", s.ConsulRequestBody.ConsulTemplatePath, consulTemplatePath},
+		data{"port", s.ConsulRequestBody.Port, "8080"},
+		data{"cert", s.ConsulRequestBody.ServiceCert, "my-cert"},
+		data{"httpsOnly", fmt.Sprintf("%t", s.ConsulRequestBody.HttpsOnly), "true"},
+		data{"hsts", fmt.Sprintf("%t", s.ConsulRequestBody.Hsts), "true"},
+		data{"reqMode", s.ConsulRequestBody.ReqMode, "tcp"},
+		data{"srcPort", s.ConsulRequestBody.SrcPort, "5432"},
+		data{"weights", formatServiceWeights(s.ConsulRequestBody.ServiceWeights), "blue:90,green:10"},
 	}
 	for _, e := range d {
 		s.Equal(e.expected, e.value)
 	}
 }
 
+func (s *ReconfigureTestSuite) Test_Execute_PutsPathRewriteToConsul() {
+	s.reconfigure.ReqPathSearch = "/demo/"
+	s.reconfigure.ReqPathReplace = "/"
+	s.reconfigure.StripPrefix = "/api/v1"
+
+	s.reconfigure.Execute([]string{})
+
+	s.Equal("/demo/", s.ConsulRequestBody.ReqPathSearch)
+	s.Equal("/", s.ConsulRequestBody.ReqPathReplace)
+	s.Equal("/api/v1", s.ConsulRequestBody.StripPrefix)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_PutsUsersToConsul() {
+	s.reconfigure.Users = []string{"admin:$6$salt$hash", "other:$1$salt$hash"}
+
+	s.reconfigure.Execute([]string{})
+
+	s.Equal([]string{"admin:$6$salt$hash", "other:$1$salt$hash"}, s.ConsulRequestBody.Users)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_PutsSkipDefaultUsersToConsul() {
+	s.reconfigure.SkipDefaultUsers = true
+
+	s.reconfigure.Execute([]string{})
+
+	s.True(s.ConsulRequestBody.SkipDefaultUsers)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_RestoresServiceConfig_WhenProxyConfigIsNotValid() {
+	readConfigsFileOrig := readConfigsFile
+	defer func() { readConfigsFile = readConfigsFileOrig }()
+	readConfigsFile = func(filename string) ([]byte, error) {
+		return []byte("previous config"), nil
+	}
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("The proxy configuration is not valid"))
+	proxy = mockObj
+
+	err := s.reconfigure.Execute([]string{})
+
+	s.Error(err)
+	s.Equal("previous config", actual[fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)])
+	s.Equal("previous config", actual[fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName)])
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_RemovesServiceConfig_WhenProxyConfigIsNotValidAndThereWasNoPreviousConfig() {
+	osRemoveOrig := osRemove
+	defer func() { osRemove = osRemoveOrig }()
+	actual := []string{}
+	osRemove = func(name string) error {
+		actual = append(actual, name)
+		return nil
+	}
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("The proxy configuration is not valid"))
+	proxy = mockObj
+
+	s.reconfigure.Execute([]string{})
+
+	s.Contains(actual, fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName))
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_DoesNotChangeProxyAndRegistry_WhenDryRunIsTrue() {
+	s.ConsulRequestBody = ServiceReconfigure{}
+	actual := []string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = append(actual, filename)
+		return nil
+	}
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	s.reconfigure.DryRun = true
+
+	err := s.reconfigure.Execute([]string{})
+
+	s.NoError(err)
+	s.NotContains(actual, fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName))
+	mockObj.AssertNotCalled(s.T(), "CreateConfigFromTemplates", mock.Anything, mock.Anything)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+	s.Empty(s.ConsulRequestBody.ServicePath)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_RecordsHistoryWhileHoldingTheLock_WhenHistoryRequestIsSet() {
+	orig := NewHistory
+	defer func() { NewHistory = orig }()
+	locked := false
+	mockObj := getHistoryMock("Record")
+	mockObj.On("Record", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if mu.TryLock() {
+			mu.Unlock()
+		} else {
+			locked = true
+		}
+	})
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	proxy = getProxyMock("")
+	s.reconfigure.BaseReconfigure = s.reconfigure.BaseReconfigure.withHistoryRequest("GET /v1/docker-flow-proxy/reconfigure")
+
+	s.reconfigure.Execute([]string{})
+
+	mockObj.AssertCalled(s.T(), "Record", "reconfigure", "GET /v1/docker-flow-proxy/reconfigure", s.reconfigure.ServiceReconfigure)
+	s.True(locked)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_DoesNotRecordHistory_WhenProxyConfigIsNotValid() {
+	orig := NewHistory
+	defer func() { NewHistory = orig }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	proxyMock := getProxyMock("CreateConfigFromTemplates")
+	proxyMock.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("The proxy configuration is not valid"))
+	proxy = proxyMock
+	s.reconfigure.BaseReconfigure = s.reconfigure.BaseReconfigure.withHistoryRequest("GET /v1/docker-flow-proxy/reconfigure")
+
+	s.reconfigure.Execute([]string{})
+
+	mockObj.AssertNotCalled(s.T(), "Record", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ReconfigureTestSuite) Test_Execute_DoesNotRecordHistory_WhenHistoryRequestIsNotSet() {
+	orig := NewHistory
+	defer func() { NewHistory = orig }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	proxy = getProxyMock("")
+
+	s.reconfigure.Execute([]string{})
+
+	mockObj.AssertNotCalled(s.T(), "Record", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// GetConfigDiff
+
+func (s *ReconfigureTestSuite) Test_GetConfigDiff_ReturnsDiffAgainstCurrentConfig() {
+	readConfigsFileOrig := readConfigsFile
+	defer func() { readConfigsFile = readConfigsFileOrig }()
+	readConfigsFile = func(filename string) ([]byte, error) {
+		return []byte("global\n"), nil
+	}
+	mockObj := getProxyMock("RenderConfigFromTemplates")
+	mockObj.On("RenderConfigFromTemplates", mock.Anything, s.ConfigsPath).Return("global\nbackend myService-be\n", nil)
+	proxy = mockObj
+	path := fmt.Sprintf("%s/haproxy.cfg", s.ConfigsPath)
+	expected := fmt.Sprintf("--- %s\n+++ %s.new\n@@ -1,1 +1,2 @@\n global\n+backend myService-be\n", path, path)
+
+	actual, err := s.reconfigure.GetConfigDiff()
+
+	s.NoError(err)
+	s.Equal(expected, actual)
+	mockObj.AssertNotCalled(s.T(), "RenderConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+}
+
+func (s *ReconfigureTestSuite) Test_GetConfigDiff_WritesServiceConfigIntoTemporaryDirectory() {
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+	var templatesPath string
+	mockObj := getProxyMock("RenderConfigFromTemplates")
+	mockObj.On("RenderConfigFromTemplates", mock.Anything, s.ConfigsPath).Run(func(args mock.Arguments) {
+		templatesPath = args.String(0)
+	}).Return("", nil)
+	proxy = mockObj
+
+	s.reconfigure.GetConfigDiff()
+
+	s.Equal(s.ServiceConfig, actual[fmt.Sprintf("%s/%s.cfg", templatesPath, s.ServiceName)])
+	s.NotContains(actual, fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName))
+}
+
+func (s *ReconfigureTestSuite) Test_GetConfigDiff_ReturnsError_WhenConfigIsNotValid() {
+	mockObj := getProxyMock("RenderConfigFromTemplates")
+	mockObj.On("RenderConfigFromTemplates", mock.Anything, mock.Anything).Return("", fmt.Errorf("The proxy configuration is not valid"))
+	proxy = mockObj
+
+	_, err := s.reconfigure.GetConfigDiff()
+
+	s.Error(err)
+}
+
+func (s *ReconfigureTestSuite) Test_GetConfigDiff_ReturnsError_WhenServiceIsNotValid() {
+	s.reconfigure.ReqMode = "udp"
+
+	_, err := s.reconfigure.GetConfigDiff()
+
+	s.Error(err)
+}
+
 func (s *ReconfigureTestSuite) Test_Execute_ReturnsError_WhenPutToConsulFails() {
 	s.reconfigure.ConsulAddress = "http:///THIS/URL/DOES/NOT/EXIST"
 	actual := s.reconfigure.Execute([]string{})
@@ -366,6 +943,114 @@ func (s ReconfigureTestSuite) Test_Execute_ReturnsError_WhenConsulTemplateFileIs
 
 // NewReconfigure
 
+// Shift
+
+func (s ReconfigureTestSuite) Test_Shift_MovesWeightFromOneColorToAnother() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := s.getShiftRegistryMock("", "blue:90,green:10")
+	var actual map[string]string
+	registry.On("PutServiceAttributes", s.ServiceName, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		actual = args.Get(1).(map[string]string)
+	})
+
+	sr, err := s.reconfigure.Shift("blue", "green", 20)
+
+	s.NoError(err)
+	s.Equal(map[string]int{"blue": 70, "green": 30}, sr.ServiceWeights)
+	s.Equal("blue:70,green:30", actual[WEIGHTS_KEY])
+}
+
+func (s ReconfigureTestSuite) Test_Shift_WaitsForOtherReconfigurations() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := s.getShiftRegistryMock("", "blue:90,green:10")
+	registry.On("PutServiceAttributes", mock.Anything, mock.Anything).Return(nil)
+	done := make(chan bool)
+	mu.Lock()
+
+	go func() {
+		s.reconfigure.Shift("blue", "green", 10)
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		s.Fail("Shift did not wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	registry.AssertNotCalled(s.T(), "GetServiceAttribute", mock.Anything, mock.Anything)
+	mu.Unlock()
+	<-done
+}
+
+func (s ReconfigureTestSuite) Test_Shift_StartsFromServiceColor_WhenServiceWeightsAreNotSet() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := s.getShiftRegistryMock("blue", "")
+	registry.On("PutServiceAttributes", mock.Anything, mock.Anything).Return(nil)
+
+	sr, _ := s.reconfigure.Shift("blue", "green", 10)
+
+	s.Equal(map[string]int{"blue": 90, "green": 10}, sr.ServiceWeights)
+}
+
+func (s ReconfigureTestSuite) Test_Shift_SwitchesToColor_WhenNoWeightIsLeft() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := s.getShiftRegistryMock("blue", "blue:5,green:95")
+	var actual map[string]string
+	registry.On("PutServiceAttributes", s.ServiceName, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		actual = args.Get(1).(map[string]string)
+	})
+
+	sr, _ := s.reconfigure.Shift("blue", "green", 10)
+
+	s.Equal("green", sr.ServiceColor)
+	s.Empty(sr.ServiceWeights)
+	s.Equal("green", actual[COLOR_KEY])
+	s.Equal("", actual[WEIGHTS_KEY])
+}
+
+func (s ReconfigureTestSuite) Test_Shift_ReturnsError_WhenServiceDoesNotHaveColor() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := s.getShiftRegistryMock("blue", "")
+	registry.On("PutServiceAttributes", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := s.reconfigure.Shift("red", "green", 10)
+
+	s.Error(err)
+	registry.AssertNotCalled(s.T(), "PutServiceAttributes", mock.Anything, mock.Anything)
+}
+
+func (s ReconfigureTestSuite) Test_Shift_ReturnsError_WhenServiceIsNotConfigured() {
+	newRegistryOrig := NewRegistry
+	defer func() { NewRegistry = newRegistryOrig }()
+	registry := new(RegistryMock)
+	registry.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registry, nil
+	}
+
+	_, err := s.reconfigure.Shift("blue", "green", 10)
+
+	s.Error(err)
+}
+
+func (s ReconfigureTestSuite) getShiftRegistryMock(color, weights string) *RegistryMock {
+	registry := new(RegistryMock)
+	registry.On("GetServiceAttribute", s.ServiceName, PATH_KEY).Return("/demo", true)
+	registry.On("GetServiceAttribute", s.ServiceName, COLOR_KEY).Return(color, true)
+	registry.On("GetServiceAttribute", s.ServiceName, WEIGHTS_KEY).Return(weights, true)
+	registry.On("GetServiceAttribute", s.ServiceName, mock.Anything).Return("", true)
+	registry.On("GetInstances", mock.Anything, mock.Anything).Return([]ServiceInstance{}, nil)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registry, nil
+	}
+	return registry
+}
+
 func (s ReconfigureTestSuite) Test_NewReconfigure_AddsBaseAndService() {
 	br := BaseReconfigure{ConsulAddress: "myConsulAddress"}
 	sr := ServiceReconfigure{ServiceName: "myService"}
@@ -398,10 +1083,10 @@ func (s ReconfigureTestSuite) Test_ReloadAllServices_ReturnsError_WhenFail() {
 	s.Error(err)
 }
 
-func (s ReconfigureTestSuite) Test_ReloadAllServices_WriteTemplateToFile() {
+func (s ReconfigureTestSuite) Test_ReloadAllServices_WritesServiceConfigFile() {
 	var actual string
-	expected := fmt.Sprintf("%s/%s", s.TemplatesPath, ServiceTemplateFilename)
-	writeConsulTemplateFile = func(filename string, data []byte, perm os.FileMode) error {
+	expected := fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
 		actual = filename
 		return nil
 	}
@@ -456,6 +1141,37 @@ func (s ReconfigureTestSuite) Test_ReloadAllServices_AddsHttpIfNotPresent() {
 	s.NoError(err)
 }
 
+// getService
+
+func (s ReconfigureTestSuite) Test_GetService_LeavesServicePathEmpty_WhenServiceIsTcp() {
+	registry := new(RegistryMock)
+	registry.On("GetServiceAttribute", "my-tcp-service", PATH_KEY).Return("", true)
+	registry.On("GetServiceAttribute", "my-tcp-service", REQ_MODE_KEY).Return("tcp", true)
+	registry.On("GetServiceAttribute", "my-tcp-service", SRC_PORT_KEY).Return("6379", true)
+	registry.On("GetServiceAttribute", "my-tcp-service", mock.Anything).Return("", true)
+	c := make(chan ServiceReconfigure, 1)
+
+	s.reconfigure.getService(registry, "my-tcp-service", c)
+
+	sr := <-c
+	s.Empty(sr.ServicePath)
+	s.Equal("tcp", sr.ReqMode)
+	s.Equal("6379", sr.SrcPort)
+	s.True(isServiceConfigured(sr))
+}
+
+func (s ReconfigureTestSuite) Test_GetService_ReturnsServiceThatIsNotConfigured_WhenServiceIsNotInRegistry() {
+	registry := new(RegistryMock)
+	registry.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	c := make(chan ServiceReconfigure, 1)
+
+	s.reconfigure.getService(registry, "my-service", c)
+
+	sr := <-c
+	s.Equal("my-service", sr.ServiceName)
+	s.False(isServiceConfigured(sr))
+}
+
 // Suite
 
 func TestReconfigureTestSuite(t *testing.T) {
@@ -463,28 +1179,74 @@ func TestReconfigureTestSuite(t *testing.T) {
 	s := new(ReconfigureTestSuite)
 	s.ServiceName = "myService"
 	s.PutPathResponse = "PUT_PATH_OK"
+	putKV := func(path string, body []byte) {
+		switch path {
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/color", s.ServiceName):
+			s.ConsulRequestBody.ServiceColor = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/path", s.ServiceName):
+			s.ConsulRequestBody.ServicePath = strings.Split(string(body), ",")
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/domain", s.ServiceName):
+			s.ConsulRequestBody.ServiceDomain = strings.Split(string(body), ",")
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/pathtype", s.ServiceName):
+			s.ConsulRequestBody.PathType = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/skipcheck", s.ServiceName):
+			v, _ := strconv.ParseBool(string(body))
+			s.ConsulRequestBody.SkipCheck = v
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/consultemplatepath", s.ServiceName):
+			s.ConsulRequestBody.ConsulTemplatePath = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/port", s.ServiceName):
+			s.ConsulRequestBody.Port = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/cert", s.ServiceName):
+			s.ConsulRequestBody.ServiceCert = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/httpsonly", s.ServiceName):
+			s.ConsulRequestBody.HttpsOnly, _ = strconv.ParseBool(string(body))
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/hsts", s.ServiceName):
+			s.ConsulRequestBody.Hsts, _ = strconv.ParseBool(string(body))
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/reqmode", s.ServiceName):
+			s.ConsulRequestBody.ReqMode = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/srcport", s.ServiceName):
+			s.ConsulRequestBody.SrcPort = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/weights", s.ServiceName):
+			s.ConsulRequestBody.ServiceWeights, _ = parseServiceWeights(string(body))
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/reqpathsearch", s.ServiceName):
+			s.ConsulRequestBody.ReqPathSearch = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/reqpathreplace", s.ServiceName):
+			s.ConsulRequestBody.ReqPathReplace = string(body)
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/users", s.ServiceName):
+			s.ConsulRequestBody.Users = strings.Split(string(body), ",")
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/skipdefaultusers", s.ServiceName):
+			s.ConsulRequestBody.SkipDefaultUsers, _ = strconv.ParseBool(string(body))
+		case fmt.Sprintf("/v1/kv/docker-flow/%s/stripprefix", s.ServiceName):
+			s.ConsulRequestBody.StripPrefix = string(body)
+		}
+	}
 	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		actualPath := r.URL.Path
 		if r.Method == "PUT" {
 			defer r.Body.Close()
 			body, _ := ioutil.ReadAll(r.Body)
-			switch actualPath {
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/color", s.ServiceName):
-				s.ConsulRequestBody.ServiceColor = string(body)
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/path", s.ServiceName):
-				s.ConsulRequestBody.ServicePath = strings.Split(string(body), ",")
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/domain", s.ServiceName):
-				s.ConsulRequestBody.ServiceDomain = string(body)
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/pathtype", s.ServiceName):
-				s.ConsulRequestBody.PathType = string(body)
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/skipcheck", s.ServiceName):
-				v, _ := strconv.ParseBool(string(body))
-				s.ConsulRequestBody.SkipCheck = v
-			case fmt.Sprintf("/v1/kv/docker-flow/%s/consultemplatepath", s.ServiceName):
-				s.ConsulRequestBody.ConsulTemplatePath = string(body)
+			if actualPath == "/v1/txn" {
+				var operations []struct {
+					KV struct {
+						Key   string
+						Value []byte
+					}
+				}
+				json.Unmarshal(body, &operations)
+				for _, o := range operations {
+					putKV("/v1/kv/"+o.KV.Key, o.KV.Value)
+				}
+			} else {
+				putKV(actualPath, body)
 			}
 		} else if r.Method == "GET" {
 			switch actualPath {
+			case fmt.Sprintf("/v1/health/service/%s", s.ServiceName):
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`[
+					{"Node": {"Node": "node1", "Address": "10.0.0.1"}, "Service": {"ID": "id1", "Service": "myService", "Port": 1111}, "Checks": []},
+					{"Node": {"Node": "node2", "Address": "10.0.0.2"}, "Service": {"ID": "id2", "Service": "myService", "Address": "10.0.0.22", "Port": 2222}, "Checks": [{"Status": "critical"}]}
+				]`))
 			case "/v1/catalog/services":
 				w.WriteHeader(http.StatusOK)
 				w.Header().Set("Content-Type", "application/json")
@@ -504,7 +1266,7 @@ func TestReconfigureTestSuite(t *testing.T) {
 			case fmt.Sprintf("/v1/kv/docker-flow/%s/%s", s.ServiceName, DOMAIN_KEY):
 				if r.URL.RawQuery == "raw" {
 					w.WriteHeader(http.StatusOK)
-					w.Write([]byte(s.ServiceDomain))
+					w.Write([]byte(strings.Join(s.ServiceDomain, ",")))
 				}
 			case fmt.Sprintf("/v1/kv/docker-flow/%s/%s", s.ServiceName, PATH_TYPE_KEY):
 				if r.URL.RawQuery == "raw" {
@@ -517,7 +1279,12 @@ func TestReconfigureTestSuite(t *testing.T) {
 					w.Write([]byte(fmt.Sprintf("%t", s.SkipCheck)))
 				}
 			default:
-				w.WriteHeader(http.StatusNotFound)
+				if strings.HasPrefix(actualPath, "/v1/health/service/") {
+					w.WriteHeader(http.StatusOK)
+					w.Write([]byte("[]"))
+				} else {
+					w.WriteHeader(http.StatusNotFound)
+				}
 			}
 		}
 	}))
@@ -527,15 +1294,6 @@ func TestReconfigureTestSuite(t *testing.T) {
 
 // Mock
 
-func (s ReconfigureTestSuite) mockConsulExecCmd() *[]string {
-	var actualCommand []string
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		actualCommand = cmd.Args
-		return nil
-	}
-	return &actualCommand
-}
-
 type ReconfigureMock struct {
 	mock.Mock
 }
@@ -560,6 +1318,16 @@ func (m *ReconfigureMock) GetConsulTemplate(sr ServiceReconfigure) (string, erro
 	return params.String(0), params.Error(1)
 }
 
+func (m *ReconfigureMock) GetConfigDiff() (string, error) {
+	params := m.Called()
+	return params.String(0), params.Error(1)
+}
+
+func (m *ReconfigureMock) Shift(from, to string, step int) (ServiceReconfigure, error) {
+	params := m.Called(from, to, step)
+	return params.Get(0).(ServiceReconfigure), params.Error(1)
+}
+
 func getReconfigureMock(skipMethod string) *ReconfigureMock {
 	mockObj := new(ReconfigureMock)
 	if skipMethod != "Execute" {
@@ -574,5 +1342,11 @@ func getReconfigureMock(skipMethod string) *ReconfigureMock {
 	if skipMethod != "GetConsulTemplate" {
 		mockObj.On("GetConsulTemplate", mock.Anything).Return("", nil)
 	}
+	if skipMethod != "GetConfigDiff" {
+		mockObj.On("GetConfigDiff").Return("", nil)
+	}
+	if skipMethod != "Shift" {
+		mockObj.On("Shift", mock.Anything, mock.Anything, mock.Anything).Return(ServiceReconfigure{}, nil)
+	}
 	return mockObj
 }
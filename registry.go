@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Registry interface {
+	GetKey(key string) (string, error)
+	GetServiceAttribute(serviceName, key string) (string, bool)
+	PutServiceAttributes(serviceName string, attributes map[string]string) error
+	DeleteService(serviceName string) error
+	GetServices() ([]string, error)
+	GetInstances(serviceName, tag string) ([]ServiceInstance, error)
+	PutCert(certName string, content []byte) error
+	GetCerts() (map[string][]byte, error)
+	DeleteCert(certName string) error
+}
+
+type ServiceInstance struct {
+	ID      string
+	Name    string
+	Node    string
+	Address string
+	Port    int
+	Tags    []string
+	Status  string
+}
+
+var NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+	switch baseData.RegistryType {
+	case "", "consul":
+		if len(baseData.ConsulAddress) == 0 {
+			return nil, fmt.Errorf("Consul address is mandatory when the consul registry is used")
+		}
+		return &ConsulRegistry{Address: getRegistryAddress(baseData.ConsulAddress)}, nil
+	case "etcd":
+		if len(baseData.EtcdAddress) == 0 {
+			return nil, fmt.Errorf("etcd address is mandatory when the etcd registry is used")
+		}
+		return &EtcdRegistry{Address: getRegistryAddress(baseData.EtcdAddress)}, nil
+	case "file":
+		if len(baseData.FileRegistryPath) == 0 {
+			return nil, fmt.Errorf("Registry path is mandatory when the file registry is used")
+		}
+		return &FileRegistry{Path: strings.TrimRight(baseData.FileRegistryPath, "/")}, nil
+	}
+	return nil, fmt.Errorf("The registry %s is not supported", baseData.RegistryType)
+}
+
+func getRegistryAddress(address string) string {
+	address = strings.ToLower(address)
+	if !strings.HasPrefix(address, "http") {
+		address = fmt.Sprintf("http://%s", address)
+	}
+	return strings.TrimRight(address, "/")
+}
@@ -0,0 +1,158 @@
+// +build !integration
+
+package main
+
+import (
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type RegistryTestSuite struct {
+	suite.Suite
+}
+
+// NewRegistry
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsConsulRegistry_WhenTypeIsEmpty() {
+	actual, _ := NewRegistry(BaseReconfigure{ConsulAddress: "http://my-consul:8500"})
+
+	s.Equal(&ConsulRegistry{Address: "http://my-consul:8500"}, actual)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsConsulRegistry_WhenTypeIsConsul() {
+	actual, _ := NewRegistry(BaseReconfigure{RegistryType: "consul", ConsulAddress: "http://my-consul:8500"})
+
+	s.Equal(&ConsulRegistry{Address: "http://my-consul:8500"}, actual)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_AddsHttpIfNotPresent() {
+	actual, _ := NewRegistry(BaseReconfigure{ConsulAddress: "My-Consul:8500"})
+
+	s.Equal(&ConsulRegistry{Address: "http://my-consul:8500"}, actual)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsError_WhenConsulAddressIsEmpty() {
+	_, err := NewRegistry(BaseReconfigure{RegistryType: "consul"})
+
+	s.Error(err)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsEtcdRegistry_WhenTypeIsEtcd() {
+	actual, _ := NewRegistry(BaseReconfigure{RegistryType: "etcd", EtcdAddress: "my-etcd:2379/"})
+
+	s.Equal(&EtcdRegistry{Address: "http://my-etcd:2379"}, actual)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsError_WhenEtcdAddressIsEmpty() {
+	_, err := NewRegistry(BaseReconfigure{RegistryType: "etcd", ConsulAddress: "my-consul:8500"})
+
+	s.Error(err)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsFileRegistry_WhenTypeIsFile() {
+	actual, _ := NewRegistry(BaseReconfigure{RegistryType: "file", FileRegistryPath: "/path/to/registry/"})
+
+	s.Equal(&FileRegistry{Path: "/path/to/registry"}, actual)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsError_WhenFileRegistryPathIsEmpty() {
+	_, err := NewRegistry(BaseReconfigure{RegistryType: "file"})
+
+	s.Error(err)
+}
+
+func (s RegistryTestSuite) Test_NewRegistry_ReturnsError_WhenTypeIsUnknown() {
+	_, err := NewRegistry(BaseReconfigure{RegistryType: "zookeeper"})
+
+	s.Error(err)
+}
+
+// Suite
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
+
+// Mock
+
+type RegistryMock struct {
+	mock.Mock
+}
+
+func (m *RegistryMock) GetKey(key string) (string, error) {
+	params := m.Called(key)
+	return params.String(0), params.Error(1)
+}
+
+func (m *RegistryMock) GetServiceAttribute(serviceName, key string) (string, bool) {
+	params := m.Called(serviceName, key)
+	return params.String(0), params.Bool(1)
+}
+
+func (m *RegistryMock) PutServiceAttributes(serviceName string, attributes map[string]string) error {
+	params := m.Called(serviceName, attributes)
+	return params.Error(0)
+}
+
+func (m *RegistryMock) DeleteService(serviceName string) error {
+	params := m.Called(serviceName)
+	return params.Error(0)
+}
+
+func (m *RegistryMock) GetServices() ([]string, error) {
+	params := m.Called()
+	return params.Get(0).([]string), params.Error(1)
+}
+
+func (m *RegistryMock) GetInstances(serviceName, tag string) ([]ServiceInstance, error) {
+	params := m.Called(serviceName, tag)
+	return params.Get(0).([]ServiceInstance), params.Error(1)
+}
+
+func (m *RegistryMock) PutCert(certName string, content []byte) error {
+	params := m.Called(certName, content)
+	return params.Error(0)
+}
+
+func (m *RegistryMock) GetCerts() (map[string][]byte, error) {
+	params := m.Called()
+	return params.Get(0).(map[string][]byte), params.Error(1)
+}
+
+func (m *RegistryMock) DeleteCert(certName string) error {
+	params := m.Called(certName)
+	return params.Error(0)
+}
+
+func getRegistryMock(skipMethod string) *RegistryMock {
+	mockObj := new(RegistryMock)
+	if skipMethod != "GetKey" {
+		mockObj.On("GetKey", mock.Anything).Return("", nil)
+	}
+	if skipMethod != "GetServiceAttribute" {
+		mockObj.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	}
+	if skipMethod != "PutServiceAttributes" {
+		mockObj.On("PutServiceAttributes", mock.Anything, mock.Anything).Return(nil)
+	}
+	if skipMethod != "DeleteService" {
+		mockObj.On("DeleteService", mock.Anything).Return(nil)
+	}
+	if skipMethod != "GetServices" {
+		mockObj.On("GetServices").Return([]string{}, nil)
+	}
+	if skipMethod != "GetInstances" {
+		mockObj.On("GetInstances", mock.Anything, mock.Anything).Return([]ServiceInstance{}, nil)
+	}
+	if skipMethod != "PutCert" {
+		mockObj.On("PutCert", mock.Anything, mock.Anything).Return(nil)
+	}
+	if skipMethod != "GetCerts" {
+		mockObj.On("GetCerts").Return(map[string][]byte{}, nil)
+	}
+	if skipMethod != "DeleteCert" {
+		mockObj.On("DeleteCert", mock.Anything).Return(nil)
+	}
+	return mockObj
+}
@@ -1,36 +1,82 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 type Removable interface {
 	Executable
 }
 
 type Remove struct {
-	ServiceName   string `short:"s" long:"service-name" required:"true" description:"The name of the service that should be removed (e.g. my-service)."`
-	ConfigsPath   string `short:"c" long:"configs-path" default:"/cfg" description:"The path to the configurations directory"`
-	TemplatesPath string `short:"t" long:"templates-path" default:"/cfg/tmpl" description:"The path to the templates directory"`
+	ServiceName  string `short:"s" long:"service-name" required:"true" description:"The name of the service that should be removed (e.g. my-service)."`
+	KeepRegistry bool   `long:"keep-registry" description:"Whether to keep the service definition in the registry. Useful when the service is removed only temporarily."`
+	BaseReconfigure
 }
 
 var remove Remove
 
-var NewRemove = func(serviceName, configsPath, templatesPath string) Removable {
+var NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
 	return &Remove{
-		ServiceName:   serviceName,
-		TemplatesPath: templatesPath,
-		ConfigsPath:   configsPath,
+		ServiceName:     serviceName,
+		KeepRegistry:    keepRegistry,
+		BaseReconfigure: baseData,
 	}
 }
 
+// Execute removes the service files and, unless KeepRegistry is set, the service data from the registry.
+// The service files are restored if the proxy configuration cannot be created or the registry cannot be updated.
 func (m *Remove) Execute(args []string) error {
-	path := fmt.Sprintf("%s/%s.cfg", m.TemplatesPath, m.ServiceName)
 	mu.Lock()
 	defer mu.Unlock()
-	if err := osRemove(path); err != nil {
-		return err
+	paths := []string{
+		fmt.Sprintf("%s/%s.cfg", m.TemplatesPath, m.ServiceName),
+		fmt.Sprintf("%s/%s.fe", m.TemplatesPath, m.ServiceName),
+		fmt.Sprintf("%s/%s.sni", m.TemplatesPath, m.ServiceName),
+	}
+	previous := map[string][]byte{}
+	for _, path := range paths {
+		if content, err := readConfigsFile(path); err == nil {
+			previous[path] = content
+		}
+	}
+	for _, path := range paths {
+		if err := osRemove(path); err != nil && !os.IsNotExist(err) {
+			m.restore(previous)
+			return err
+		}
 	}
 	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		m.restore(previous)
+		return err
+	}
+	if !m.KeepRegistry {
+		if err := m.deleteFromRegistry(); err != nil {
+			m.restore(previous)
+			proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath)
+			return err
+		}
+	}
+	if err := proxy.Reload(); err != nil {
 		return err
 	}
-	return proxy.Reload()
+	m.recordHistory("remove", ServiceReconfigure{ServiceName: m.ServiceName})
+	return nil
+}
+
+// The proxy can run without a registry so the service is removed only from the proxy when none is configured.
+func (m *Remove) deleteFromRegistry() error {
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		logPrintf("The service %s was not removed from the registry\n%s", m.ServiceName, err.Error())
+		return nil
+	}
+	return registry.DeleteService(m.ServiceName)
+}
+
+func (m *Remove) restore(previous map[string][]byte) {
+	for path, content := range previous {
+		writeServiceConfigFile(path, content, 0664)
+	}
 }
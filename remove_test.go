@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
-	"os/exec"
+	"os"
 	"testing"
 )
 
@@ -25,20 +25,35 @@ func (s *RemoveTestSuite) SetupTest() {
 	osRemove = func(name string) error {
 		return nil
 	}
+	readConfigsFile = func(filename string) ([]byte, error) {
+		return nil, os.ErrNotExist
+	}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		return nil
+	}
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
 	s.remove = Remove{
-		ServiceName:   s.ServiceName,
-		ConfigsPath:   s.ConfigsPath,
-		TemplatesPath: s.TemplatesPath,
+		ServiceName: s.ServiceName,
+		BaseReconfigure: BaseReconfigure{
+			ConfigsPath:   s.ConfigsPath,
+			TemplatesPath: s.TemplatesPath,
+		},
 	}
 }
 
 // Execute
 
 func (s RemoveTestSuite) Test_Execute_RemovesConfigurationFile() {
-	var actual string
-	expected := fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)
+	actual := []string{}
+	expected := []string{
+		fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName),
+		fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName),
+		fmt.Sprintf("%s/%s.sni", s.TemplatesPath, s.ServiceName),
+	}
 	osRemove = func(name string) error {
-		actual = name
+		actual = append(actual, name)
 		return nil
 	}
 
@@ -57,6 +72,16 @@ func (s RemoveTestSuite) Test_Execute_ReturnsError_WhenFailure() {
 	s.Error(err)
 }
 
+func (s RemoveTestSuite) Test_Execute_DoesNotReturnError_WhenFilesDoNotExist() {
+	osRemove = func(name string) error {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	err := s.remove.Execute([]string{})
+
+	s.NoError(err)
+}
+
 func (s RemoveTestSuite) Test_Execute_Invokes_HaProxyCreateConfigFromTemplates() {
 	proxyOrig := proxy
 	defer func() {
@@ -111,24 +136,200 @@ func (s RemoveTestSuite) Test_Execute_ReturnsError_WhenHaProxyReloadFails() {
 	s.Error(err)
 }
 
+func (s RemoveTestSuite) Test_Execute_DeletesServiceFromRegistry() {
+	mockObj := getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return mockObj, nil
+	}
+
+	s.remove.Execute([]string{})
+
+	mockObj.AssertCalled(s.T(), "DeleteService", s.ServiceName)
+}
+
+func (s RemoveTestSuite) Test_Execute_ReturnsError_WhenRegistryDeleteServiceFails() {
+	mockObj := getRegistryMock("DeleteService")
+	mockObj.On("DeleteService", mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return mockObj, nil
+	}
+
+	err := s.remove.Execute([]string{})
+
+	s.Error(err)
+}
+
+func (s RemoveTestSuite) Test_Execute_RestoresServiceFiles_WhenRegistryDeleteServiceFails() {
+	proxyOrig := proxy
+	defer func() {
+		proxy = proxyOrig
+	}()
+	proxyMock := getProxyMock("")
+	proxy = proxyMock
+	mockObj := getRegistryMock("DeleteService")
+	mockObj.On("DeleteService", mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return mockObj, nil
+	}
+	cfgPath := fmt.Sprintf("%s/%s.cfg", s.TemplatesPath, s.ServiceName)
+	readConfigsFile = func(filename string) ([]byte, error) {
+		if filename == cfgPath {
+			return []byte("backend myService-be"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+
+	s.remove.Execute([]string{})
+
+	s.Equal(map[string]string{cfgPath: "backend myService-be"}, actual)
+	proxyMock.AssertNumberOfCalls(s.T(), "CreateConfigFromTemplates", 2)
+	proxyMock.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s RemoveTestSuite) Test_Execute_RestoresServiceFiles_WhenHaProxyCreateConfigFromTemplatesFails() {
+	proxyOrig := proxy
+	defer func() {
+		proxy = proxyOrig
+	}()
+	proxyMock := getProxyMock("CreateConfigFromTemplates")
+	proxyMock.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = proxyMock
+	registryMock := getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	fePath := fmt.Sprintf("%s/%s.fe", s.TemplatesPath, s.ServiceName)
+	readConfigsFile = func(filename string) ([]byte, error) {
+		if filename == fePath {
+			return []byte("use_backend myService-be"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	actual := map[string]string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual[filename] = string(data)
+		return nil
+	}
+
+	s.remove.Execute([]string{})
+
+	s.Equal(map[string]string{fePath: "use_backend myService-be"}, actual)
+	registryMock.AssertNotCalled(s.T(), "DeleteService", mock.Anything)
+}
+
+func (s RemoveTestSuite) Test_Execute_DoesNotDeleteServiceFromRegistry_WhenKeepRegistryIsTrue() {
+	mockObj := getRegistryMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return mockObj, nil
+	}
+	s.remove.KeepRegistry = true
+
+	err := s.remove.Execute([]string{})
+
+	s.NoError(err)
+	mockObj.AssertNotCalled(s.T(), "DeleteService", mock.Anything)
+}
+
+func (s RemoveTestSuite) Test_Execute_RemovesServiceFromProxy_WhenRegistryIsNotConfigured() {
+	proxyOrig := proxy
+	defer func() {
+		proxy = proxyOrig
+	}()
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return nil, fmt.Errorf("Consul address is mandatory when the consul registry is used")
+	}
+
+	err := s.remove.Execute([]string{})
+
+	s.NoError(err)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s RemoveTestSuite) Test_Execute_RecordsHistoryWhileHoldingTheLock_WhenHistoryRequestIsSet() {
+	proxyOrig := proxy
+	orig := NewHistory
+	defer func() {
+		proxy = proxyOrig
+		NewHistory = orig
+	}()
+	proxy = getProxyMock("")
+	locked := false
+	mockObj := getHistoryMock("Record")
+	mockObj.On("Record", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if mu.TryLock() {
+			mu.Unlock()
+		} else {
+			locked = true
+		}
+	})
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	s.remove.BaseReconfigure = s.remove.BaseReconfigure.withHistoryRequest("DELETE /v1/docker-flow-proxy/services/myService")
+
+	s.remove.Execute([]string{})
+
+	mockObj.AssertCalled(s.T(), "Record", "remove", "DELETE /v1/docker-flow-proxy/services/myService", ServiceReconfigure{ServiceName: s.ServiceName})
+	s.True(locked)
+}
+
+func (s RemoveTestSuite) Test_Execute_DoesNotRecordHistory_WhenFailure() {
+	orig := NewHistory
+	defer func() { NewHistory = orig }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	osRemove = func(name string) error {
+		return fmt.Errorf("The file could not be removed")
+	}
+	s.remove.BaseReconfigure = s.remove.BaseReconfigure.withHistoryRequest("DELETE /v1/docker-flow-proxy/services/myService")
+
+	s.remove.Execute([]string{})
+
+	mockObj.AssertNotCalled(s.T(), "Record", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// NewRemove
+
+func (s RemoveTestSuite) Test_NewRemove_AddsServiceNameAndBase() {
+	br := BaseReconfigure{ConsulAddress: "myConsulAddress", TemplatesPath: s.TemplatesPath}
+
+	actual := NewRemove(s.ServiceName, false, br)
+
+	s.Equal(&Remove{ServiceName: s.ServiceName, BaseReconfigure: br}, actual)
+}
+
+func (s RemoveTestSuite) Test_NewRemove_AddsKeepRegistry() {
+	actual := NewRemove(s.ServiceName, true, BaseReconfigure{})
+
+	s.True(actual.(*Remove).KeepRegistry)
+}
+
 // Suite
 
 func TestRemoveTestSuite(t *testing.T) {
 	logPrintf = func(format string, v ...interface{}) {}
+	newRegistryOrig := NewRegistry
+	readConfigsFileOrig := readConfigsFile
+	writeServiceConfigFileOrig := writeServiceConfigFile
+	defer func() {
+		NewRegistry = newRegistryOrig
+		readConfigsFile = readConfigsFileOrig
+		writeServiceConfigFile = writeServiceConfigFileOrig
+	}()
 	suite.Run(t, new(RemoveTestSuite))
 }
 
 // Mock
 
-func (s RemoveTestSuite) mockConsulExecCmd() *[]string {
-	var actualCommand []string
-	cmdRunConsul = func(cmd *exec.Cmd) error {
-		actualCommand = cmd.Args
-		return nil
-	}
-	return &actualCommand
-}
-
 type RemoveMock struct {
 	mock.Mock
 }
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const REPLICAS_PREFIX = "docker-flow-replicas"
+
+type Replicable interface {
+	Watch() error
+	Publish(action, serviceName string) error
+	GetStatus() (ReplicasStatus, error)
+	Stop()
+}
+
+type Replicas struct {
+	BaseReconfigure
+	Name          string
+	Address       string
+	RetryInterval time.Duration
+	LockTimeout   time.Duration
+	ChangesLimit  int
+	MaxAttempts   int
+	address       string
+	applied       int
+	failures      int
+	stop          chan bool
+	mu            *sync.Mutex
+}
+
+// ReplicaChange is a change published by one of the replicas.
+// ServiceName holds the name of the certificate when the action is putCert or deleteCert.
+type ReplicaChange struct {
+	Revision    int
+	Action      string
+	ServiceName string
+	Replica     string
+}
+
+type ReplicaStatus struct {
+	Name     string
+	Address  string
+	Revision int
+	InSync   bool
+	Updated  time.Time
+}
+
+type ReplicasStatus struct {
+	Revision int
+	Replicas []ReplicaStatus
+}
+
+var NewReplicas = func(baseData BaseReconfigure, name, address string) Replicable {
+	return &Replicas{
+		BaseReconfigure: baseData,
+		Name:            name,
+		Address:         address,
+		RetryInterval:   5 * time.Second,
+		LockTimeout:     10 * time.Second,
+		ChangesLimit:    100,
+		MaxAttempts:     5,
+		address:         getRegistryAddress(baseData.ConsulAddress),
+		applied:         -1,
+		stop:            make(chan bool),
+		mu:              &sync.Mutex{},
+	}
+}
+
+// Watch applies changes published by other replicas in the order of their revisions.
+func (m *Replicas) Watch() error {
+	logPrintf("Synchronizing with other replicas through Consul running on %s", m.address)
+	index := "0"
+	for {
+		select {
+		case <-m.stop:
+			return nil
+		default:
+		}
+		revision, newIndex, err := m.getRevision(index)
+		if err != nil {
+			logPrintf("Could not watch the revision of the proxy configuration\n%s", err.Error())
+			m.wait()
+			continue
+		}
+		if len(newIndex) == 0 {
+			logPrintf("Consul running on %s did not return the X-Consul-Index header", m.address)
+			m.wait()
+			continue
+		}
+		index = newIndex
+		if err := m.applyChanges(revision); err != nil {
+			logPrintf("Could not apply changes published by other replicas\n%s", err.Error())
+			m.wait()
+			index = "0"
+			continue
+		}
+		if err := m.putStatus(); err != nil {
+			logPrintf(err.Error())
+		}
+	}
+}
+
+func (m *Replicas) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+}
+
+// Publish stores a change applied by this replica so that other replicas apply it as well.
+// Revisions are incremented while holding a Consul session lock so that all replicas see changes in the same order.
+func (m *Replicas) Publish(action, serviceName string) error {
+	session, err := m.createSession()
+	if err != nil {
+		return err
+	}
+	defer m.put(fmt.Sprintf("/v1/session/destroy/%s", session), nil)
+	lock := fmt.Sprintf("/v1/kv/%s/lock", REPLICAS_PREFIX)
+	if err := m.acquire(lock, session); err != nil {
+		return err
+	}
+	// The value of the lock is sent with the release as well since Consul replaces it with the body of the request.
+	defer m.put(fmt.Sprintf("%s?release=%s", lock, session), []byte(m.Name))
+	revision, _, err := m.getRevision("")
+	if err != nil {
+		return err
+	}
+	change := ReplicaChange{
+		Revision:    revision + 1,
+		Action:      action,
+		ServiceName: serviceName,
+		Replica:     m.Name,
+	}
+	content, _ := json.Marshal(change)
+	operations := []consulTxnOperation{
+		{Verb: "set", Key: m.getChangeKey(change.Revision), Value: content},
+		{Verb: "set", Key: fmt.Sprintf("%s/revision", REPLICAS_PREFIX), Value: []byte(strconv.Itoa(change.Revision))},
+	}
+	if change.Revision > m.ChangesLimit {
+		operations = append(operations, consulTxnOperation{Verb: "delete", Key: m.getChangeKey(change.Revision - m.ChangesLimit)})
+	}
+	if err := (&ConsulRegistry{Address: m.address}).sendTxn(operations); err != nil {
+		return fmt.Errorf("Could not publish the change of the service %s\n%s", serviceName, err.Error())
+	}
+	return nil
+}
+
+func (m *Replicas) GetStatus() (ReplicasStatus, error) {
+	status := ReplicasStatus{Replicas: []ReplicaStatus{}}
+	revision, _, err := m.getRevision("")
+	if err != nil {
+		return status, err
+	}
+	status.Revision = revision
+	addr := fmt.Sprintf("%s/v1/kv/%s/status/?recurse", m.address, REPLICAS_PREFIX)
+	resp, err := http.Get(addr)
+	if err != nil {
+		return status, fmt.Errorf("Could not retrieve the status of replicas from Consul running on %s\n%s", m.address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return status, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("Consul running on %s returned status %d while retrieving the status of replicas", m.address, resp.StatusCode)
+	}
+	var entries []struct {
+		Value []byte
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return status, fmt.Errorf("Could not parse the response from Consul\n%s", err.Error())
+	}
+	for _, e := range entries {
+		replica := ReplicaStatus{}
+		if err := json.Unmarshal(e.Value, &replica); err != nil {
+			continue
+		}
+		replica.InSync = replica.Revision >= revision
+		status.Replicas = append(status.Replicas, replica)
+	}
+	sort.Slice(status.Replicas, func(i, j int) bool {
+		return status.Replicas[i].Name < status.Replicas[j].Name
+	})
+	return status, nil
+}
+
+func (m *Replicas) applyChanges(revision int) error {
+	if m.applied < 0 || m.applied > revision {
+		// The configuration was loaded from the registry on startup so only later changes are applied.
+		m.applied = revision
+		return nil
+	}
+	for m.applied < revision {
+		next := m.applied + 1
+		change, found, err := m.getChange(next)
+		if err != nil {
+			return err
+		}
+		if !found {
+			logPrintf("The change %d is not available any more. Reloading all services", next)
+			if err := m.reloadAllServices(); err != nil {
+				return err
+			}
+			m.applied = revision
+			return nil
+		}
+		if change.Replica != m.Name {
+			logPrintf("Applying the change %d (%s %s) published by %s", change.Revision, change.Action, change.ServiceName, change.Replica)
+			if err := m.applyChange(change); err != nil {
+				m.failures++
+				if m.failures < m.MaxAttempts {
+					return err
+				}
+				// The change would fail forever and block all later changes.
+				logPrintf("Could not apply the change %d after %d attempts. Reloading all services\n%s", next, m.failures, err.Error())
+				m.failures = 0
+				if err := m.reloadAllServices(); err != nil {
+					logPrintf("Could not reload all services. Skipping the change %d\n%s", next, err.Error())
+					m.applied = next
+					continue
+				}
+				m.applied = revision
+				return nil
+			}
+		}
+		m.failures = 0
+		m.applied = next
+	}
+	return nil
+}
+
+// reloadAllServices brings the proxy in line with the registry when changes cannot be applied one by one.
+func (m *Replicas) reloadAllServices() error {
+	if err := NewCert(m.BaseReconfigure).Restore(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := NewReconfigure(m.BaseReconfigure, ServiceReconfigure{}).ReloadAllServices(m.ConsulAddress); err != nil {
+		return err
+	}
+	recordChange(m.BaseReconfigure, "reload", "replicas sync", ServiceReconfigure{})
+	return nil
+}
+
+func (m *Replicas) applyChange(change ReplicaChange) error {
+	switch change.Action {
+	case "reconfigure":
+		registry, err := NewRegistry(m.BaseReconfigure)
+		if err != nil {
+			return err
+		}
+		r := &Reconfigure{BaseReconfigure: m.BaseReconfigure}
+		c := make(chan ServiceReconfigure, 1)
+		r.getService(registry, change.ServiceName, c)
+		sr := <-c
+		if !isServiceConfigured(sr) {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := r.configure(registry, sr); err != nil {
+			return err
+		}
+		if err := proxy.Reload(); err != nil {
+			return err
+		}
+		recordChange(m.BaseReconfigure, change.Action, m.getChangeRequest(change), sr)
+		return nil
+	case "remove":
+		base := m.BaseReconfigure.withHistoryRequest(m.getChangeRequest(change))
+		if err := NewRemove(change.ServiceName, true, base).Execute([]string{}); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case "putCert":
+		if err := NewCert(m.BaseReconfigure).Restore(); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+			return err
+		}
+		return proxy.Reload()
+	case "deleteCert":
+		cert := NewCert(m.BaseReconfigure)
+		certs, err := cert.GetAll()
+		if err != nil {
+			return err
+		}
+		for _, c := range certs {
+			if c.Name == change.ServiceName {
+				return cert.Delete(change.ServiceName)
+			}
+		}
+		return nil
+	}
+	logPrintf("The action %s is not supported", change.Action)
+	return nil
+}
+
+func (m *Replicas) getChangeRequest(change ReplicaChange) string {
+	return fmt.Sprintf("change %d published by %s", change.Revision, change.Replica)
+}
+
+func (m *Replicas) getRevision(index string) (int, string, error) {
+	addr := fmt.Sprintf("%s/v1/kv/%s/revision?raw", m.address, REPLICAS_PREFIX)
+	if len(index) > 0 {
+		addr = fmt.Sprintf("%s&index=%s&wait=5m", addr, index)
+	}
+	resp, err := http.Get(addr)
+	if err != nil {
+		return 0, index, fmt.Errorf("Could not retrieve the revision from Consul running on %s\n%s", m.address, err.Error())
+	}
+	defer resp.Body.Close()
+	newIndex := resp.Header.Get("X-Consul-Index")
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, newIndex, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return 0, index, fmt.Errorf("Consul running on %s returned status %d while retrieving the revision", m.address, resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	revision, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		return 0, index, fmt.Errorf("The revision %s stored in Consul is not valid", string(body))
+	}
+	return revision, newIndex, nil
+}
+
+func (m *Replicas) getChange(revision int) (ReplicaChange, bool, error) {
+	change := ReplicaChange{}
+	addr := fmt.Sprintf("%s/v1/kv/%s?raw", m.address, m.getChangeKey(revision))
+	resp, err := http.Get(addr)
+	if err != nil {
+		return change, false, fmt.Errorf("Could not retrieve the change %d from Consul running on %s\n%s", revision, m.address, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return change, false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return change, false, fmt.Errorf("Consul running on %s returned status %d while retrieving the change %d", m.address, resp.StatusCode, revision)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &change); err != nil {
+		return change, false, fmt.Errorf("Could not parse the change %d\n%s", revision, err.Error())
+	}
+	return change, true, nil
+}
+
+func (m *Replicas) putStatus() error {
+	content, _ := json.Marshal(ReplicaStatus{
+		Name:     m.Name,
+		Address:  m.Address,
+		Revision: m.applied,
+		Updated:  time.Now().UTC(),
+	})
+	if _, err := m.put(fmt.Sprintf("/v1/kv/%s/status/%s", REPLICAS_PREFIX, m.Name), content); err != nil {
+		return fmt.Errorf("Could not store the status of the replica %s\n%s", m.Name, err.Error())
+	}
+	return nil
+}
+
+func (m *Replicas) createSession() (string, error) {
+	content, _ := json.Marshal(map[string]string{
+		"Name": fmt.Sprintf("docker-flow-proxy-%s", m.Name),
+		"TTL":  "30s",
+	})
+	body, err := m.put("/v1/session/create", content)
+	if err != nil {
+		return "", fmt.Errorf("Could not create a Consul session\n%s", err.Error())
+	}
+	var data struct {
+		ID string
+	}
+	json.Unmarshal(body, &data)
+	if len(data.ID) == 0 {
+		return "", fmt.Errorf("Consul running on %s did not return the session ID", m.address)
+	}
+	return data.ID, nil
+}
+
+func (m *Replicas) acquire(lock, session string) error {
+	timeout := time.After(m.LockTimeout)
+	for {
+		body, err := m.put(fmt.Sprintf("%s?acquire=%s", lock, session), []byte(m.Name))
+		if err != nil {
+			return fmt.Errorf("Could not acquire the lock\n%s", err.Error())
+		}
+		if strings.TrimSpace(string(body)) == "true" {
+			return nil
+		}
+		select {
+		case <-timeout:
+			return fmt.Errorf("Could not acquire the lock within %s", m.LockTimeout)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (m *Replicas) put(path string, content []byte) ([]byte, error) {
+	request, _ := http.NewRequest("PUT", fmt.Sprintf("%s%s", m.address, path), bytes.NewReader(content))
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Consul running on %s returned status %d for %s", m.address, resp.StatusCode, path)
+	}
+	return body, nil
+}
+
+func (m *Replicas) getChangeKey(revision int) string {
+	return fmt.Sprintf("%s/changes/%010d", REPLICAS_PREFIX, revision)
+}
+
+func (m *Replicas) wait() {
+	select {
+	case <-m.stop:
+	case <-time.After(m.RetryInterval):
+	}
+}
@@ -0,0 +1,664 @@
+// +build !integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ReplicasTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Server   *httptest.Server
+	KV       map[string][]byte
+	Index    int
+	LockedBy string
+	mu       *sync.Mutex
+}
+
+func (s *ReplicasTestSuite) SetupTest() {
+	s.mu.Lock()
+	s.KV = map[string][]byte{}
+	s.Index = 1
+	s.LockedBy = ""
+	s.mu.Unlock()
+	s.TemplatesPath = "test_configs/tmpl"
+	s.ConfigsPath = "path/to/configs/dir"
+	s.ConsulAddress = s.Server.URL
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		return nil
+	}
+	proxy = getProxyMock("")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
+}
+
+// NewReplicas
+
+func (s *ReplicasTestSuite) Test_NewReplicas_AddsHttpIfNotPresent() {
+	s.ConsulAddress = strings.Replace(s.ConsulAddress, "http://", "", -1)
+
+	r := NewReplicas(s.BaseReconfigure, "proxy-1", "proxy-1:8080").(*Replicas)
+
+	s.Equal(s.Server.URL, r.address)
+}
+
+// Publish
+
+func (s *ReplicasTestSuite) Test_Publish_StoresChangesInOrder() {
+	r := s.getReplicas("proxy-1")
+
+	s.NoError(r.Publish("reconfigure", "go-demo"))
+	s.NoError(r.Publish("remove", "other-service"))
+
+	s.Equal("2", s.getValue("docker-flow-replicas/revision"))
+	change := ReplicaChange{}
+	json.Unmarshal([]byte(s.getValue("docker-flow-replicas/changes/0000000002")), &change)
+	s.Equal(ReplicaChange{Revision: 2, Action: "remove", ServiceName: "other-service", Replica: "proxy-1"}, change)
+}
+
+func (s *ReplicasTestSuite) Test_Publish_ReleasesLock() {
+	r := s.getReplicas("proxy-1")
+
+	r.Publish("reconfigure", "go-demo")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Empty(s.LockedBy)
+}
+
+func (s *ReplicasTestSuite) Test_Publish_KeepsLockValue() {
+	r := s.getReplicas("proxy-1")
+
+	r.Publish("reconfigure", "go-demo")
+
+	s.Equal("proxy-1", s.getValue("docker-flow-replicas/lock"))
+}
+
+func (s *ReplicasTestSuite) Test_Publish_DeletesOldChanges() {
+	r := s.getReplicas("proxy-1")
+	r.ChangesLimit = 1
+
+	r.Publish("reconfigure", "go-demo")
+	r.Publish("reconfigure", "go-demo")
+
+	s.Empty(s.getValue("docker-flow-replicas/changes/0000000001"))
+	s.NotEmpty(s.getValue("docker-flow-replicas/changes/0000000002"))
+}
+
+func (s *ReplicasTestSuite) Test_Publish_ReturnsError_WhenLockIsHeldByAnotherReplica() {
+	s.mu.Lock()
+	s.LockedBy = "another-session"
+	s.mu.Unlock()
+	r := s.getReplicas("proxy-1")
+	r.LockTimeout = 10 * time.Millisecond
+
+	err := r.Publish("reconfigure", "go-demo")
+
+	s.Error(err)
+	s.Empty(s.getValue("docker-flow-replicas/revision"))
+}
+
+func (s *ReplicasTestSuite) Test_Publish_ReturnsError_WhenConsulIsNotAvailable() {
+	s.ConsulAddress = "http:///THIS/URL/DOES/NOT/EXIST"
+	r := s.getReplicas("proxy-1")
+
+	err := r.Publish("reconfigure", "go-demo")
+
+	s.Error(err)
+}
+
+// applyChanges
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_OnlyStoresRevision_WhenInvokedForTheFirstTime() {
+	r := s.getReplicas("proxy-1")
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	err := r.applyChanges(5)
+
+	s.NoError(err)
+	s.Equal(5, r.applied)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_ReconfiguresServicesChangedByOtherReplicas() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	actualFiles := []string{}
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actualFiles = append(actualFiles, filename)
+		return nil
+	}
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.NoError(err)
+	s.Equal(1, r.applied)
+	s.Contains(actualFiles, fmt.Sprintf("%s/go-demo.cfg", s.TemplatesPath))
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_RecordsHistory() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	s.getReplicas("proxy-2").Publish("remove", "books-ms")
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	removeData := BaseReconfigure{}
+	origRemove := NewRemove
+	defer func() { NewRemove = origRemove }()
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		removeData = baseData
+		return getRemoveMock("")
+	}
+	origHistory := NewHistory
+	defer func() { NewHistory = origHistory }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	r.applyChanges(2)
+
+	mockObj.AssertCalled(s.T(), "Record", "reconfigure", "change 1 published by proxy-2", mock.MatchedBy(func(sr ServiceReconfigure) bool {
+		return sr.ServiceName == "go-demo"
+	}))
+	s.Equal(r.BaseReconfigure.withHistoryRequest("change 2 published by proxy-2"), removeData)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_RestoresServiceFiles_WhenProxyConfigIsNotValid() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	cfgPath := fmt.Sprintf("%s/go-demo.cfg", s.TemplatesPath)
+	readConfigsFileOrig := readConfigsFile
+	osRemoveOrig := osRemove
+	defer func() {
+		readConfigsFile = readConfigsFileOrig
+		osRemove = osRemoveOrig
+	}()
+	readConfigsFile = func(filename string) ([]byte, error) {
+		if filename == cfgPath {
+			return []byte("backend go-demo-be"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	actualRemoved := []string{}
+	osRemove = func(name string) error {
+		actualRemoved = append(actualRemoved, name)
+		return nil
+	}
+	var actualCfg string
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		if filename == cfgPath {
+			actualCfg = string(data)
+		}
+		return nil
+	}
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.Error(err)
+	s.Equal("backend go-demo-be", actualCfg)
+	s.Contains(actualRemoved, fmt.Sprintf("%s/go-demo.fe", s.TemplatesPath))
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_RemovesServicesAndKeepsRegistry() {
+	s.getReplicas("proxy-2").Publish("remove", "go-demo")
+	orig := NewRemove
+	defer func() { NewRemove = orig }()
+	mockObj := getRemoveMock("")
+	var actualName string
+	var actualKeepRegistry bool
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actualName = serviceName
+		actualKeepRegistry = keepRegistry
+		return mockObj
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	r.applyChanges(1)
+
+	s.Equal("go-demo", actualName)
+	s.True(actualKeepRegistry)
+	mockObj.AssertCalled(s.T(), "Execute", []string{})
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_RestoresCertificatesAndReloads_WhenCertIsPut() {
+	s.getReplicas("proxy-2").Publish("putCert", "my-cert")
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	certMock := getCertMock("")
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.NoError(err)
+	certMock.AssertCalled(s.T(), "Restore")
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_DeletesCertificate_WhenCertIsDeleted() {
+	s.getReplicas("proxy-2").Publish("deleteCert", "my-cert")
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	certMock := getCertMock("GetAll")
+	certMock.On("GetAll").Return([]CertInfo{{Name: "my-cert"}}, nil)
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.NoError(err)
+	certMock.AssertCalled(s.T(), "Delete", "my-cert")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_DoesNotDeleteCertificate_WhenItDoesNotExist() {
+	s.getReplicas("proxy-2").Publish("deleteCert", "my-cert")
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	certMock := getCertMock("")
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.NoError(err)
+	s.Equal(1, r.applied)
+	certMock.AssertNotCalled(s.T(), "Delete", mock.Anything)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_SkipsChangesPublishedByTheSameReplica() {
+	r := s.getReplicas("proxy-1")
+	r.Publish("reconfigure", "go-demo")
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.NoError(err)
+	s.Equal(1, r.applied)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_ReloadsAllServices_WhenChangeIsNotAvailable() {
+	orig := NewReconfigure
+	defer func() { NewReconfigure = orig }()
+	mockObj := getReconfigureMock("")
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(3)
+
+	s.NoError(err)
+	s.Equal(3, r.applied)
+	mockObj.AssertCalled(s.T(), "ReloadAllServices", s.ConsulAddress)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_RecordsHistory_WhenAllServicesAreReloaded() {
+	origReconfigure := NewReconfigure
+	defer func() { NewReconfigure = origReconfigure }()
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return getReconfigureMock("")
+	}
+	origHistory := NewHistory
+	defer func() { NewHistory = origHistory }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	r.applyChanges(3)
+
+	mockObj.AssertCalled(s.T(), "Record", "reload", "replicas sync", ServiceReconfigure{})
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_ReloadsAllServicesWhileHoldingTheLock() {
+	orig := NewReconfigure
+	defer func() { NewReconfigure = orig }()
+	mockObj := getReconfigureMock("")
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+	done := make(chan bool)
+	mu.Lock()
+
+	go func() {
+		r.applyChanges(3)
+		done <- true
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mockObj.AssertNotCalled(s.T(), "ReloadAllServices", mock.Anything)
+	mu.Unlock()
+	<-done
+	mockObj.AssertCalled(s.T(), "ReloadAllServices", s.ConsulAddress)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_ReloadsAllServices_WhenChangeKeepsFailing() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	s.getReplicas("proxy-2").Publish("reconfigure", "other")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return nil, fmt.Errorf("This is an error")
+	}
+	orig := NewReconfigure
+	defer func() { NewReconfigure = orig }()
+	mockObj := getReconfigureMock("")
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	origCert := NewCert
+	defer func() { NewCert = origCert }()
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return getCertMock("")
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	for i := 1; i < r.MaxAttempts; i++ {
+		s.Error(r.applyChanges(2))
+		s.Equal(0, r.applied)
+	}
+	mockObj.AssertNotCalled(s.T(), "ReloadAllServices", mock.Anything)
+	err := r.applyChanges(2)
+
+	s.NoError(err)
+	s.Equal(2, r.applied)
+	mockObj.AssertCalled(s.T(), "ReloadAllServices", s.ConsulAddress)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_SkipsChange_WhenChangeKeepsFailingAndServicesCannotBeReloaded() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	s.getReplicas("proxy-2").Publish("putCert", "my-cert")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return nil, fmt.Errorf("This is an error")
+	}
+	orig := NewReconfigure
+	defer func() { NewReconfigure = orig }()
+	reconfigureMock := getReconfigureMock("ReloadAllServices")
+	reconfigureMock.On("ReloadAllServices", mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return reconfigureMock
+	}
+	origCert := NewCert
+	defer func() { NewCert = origCert }()
+	certMock := getCertMock("")
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	for i := 1; i < r.MaxAttempts; i++ {
+		r.applyChanges(2)
+	}
+	err := r.applyChanges(2)
+
+	s.NoError(err)
+	s.Equal(2, r.applied)
+	certMock.AssertNumberOfCalls(s.T(), "Restore", 2)
+}
+
+func (s *ReplicasTestSuite) Test_ApplyChanges_ReturnsError_WhenChangeCannotBeApplied() {
+	s.getReplicas("proxy-2").Publish("reconfigure", "go-demo")
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return nil, fmt.Errorf("This is an error")
+	}
+	r := s.getReplicas("proxy-1")
+	r.applied = 0
+
+	err := r.applyChanges(1)
+
+	s.Error(err)
+	s.Equal(0, r.applied)
+}
+
+// GetStatus
+
+func (s *ReplicasTestSuite) Test_GetStatus_ReturnsRevisionAndReplicas() {
+	r1 := s.getReplicas("proxy-1")
+	r2 := s.getReplicas("proxy-2")
+	r1.Publish("reconfigure", "go-demo")
+	r1.applied = 1
+	r1.putStatus()
+	r2.applied = 0
+	r2.putStatus()
+
+	actual, err := r1.GetStatus()
+
+	s.NoError(err)
+	s.Equal(1, actual.Revision)
+	s.Len(actual.Replicas, 2)
+	s.Equal("proxy-1", actual.Replicas[0].Name)
+	s.Equal("proxy-1:8080", actual.Replicas[0].Address)
+	s.True(actual.Replicas[0].InSync)
+	s.Equal("proxy-2", actual.Replicas[1].Name)
+	s.False(actual.Replicas[1].InSync)
+}
+
+func (s *ReplicasTestSuite) Test_GetStatus_ReturnsEmptyList_WhenThereAreNoReplicas() {
+	actual, err := s.getReplicas("proxy-1").GetStatus()
+
+	s.NoError(err)
+	s.Equal(ReplicasStatus{Replicas: []ReplicaStatus{}}, actual)
+}
+
+// Watch
+
+func (s *ReplicasTestSuite) Test_Watch_StoresStatus() {
+	r := s.getReplicas("proxy-1")
+	go r.Watch()
+	defer r.Stop()
+
+	s.Eventually(func() bool {
+		return len(s.getValue("docker-flow-replicas/status/proxy-1")) > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Suite
+
+func TestReplicasTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	proxyOrig := proxy
+	newRegistryOrig := NewRegistry
+	defer func() {
+		proxy = proxyOrig
+		NewRegistry = newRegistryOrig
+	}()
+	s := new(ReplicasTestSuite)
+	s.mu = &sync.Mutex{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch {
+		case r.URL.Path == "/v1/session/create":
+			fmt.Fprint(w, `{"ID": "session-1"}`)
+		case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+			fmt.Fprint(w, "true")
+		case r.URL.Path == "/v1/txn":
+			var operations []struct {
+				KV consulTxnOperation
+			}
+			json.Unmarshal(body, &operations)
+			for _, o := range operations {
+				if o.KV.Verb == "delete" {
+					delete(s.KV, o.KV.Key)
+				} else {
+					s.KV[o.KV.Key] = o.KV.Value
+				}
+			}
+			s.Index++
+			fmt.Fprint(w, `{"Errors": null}`)
+		case r.Method == "PUT" && len(r.URL.Query().Get("acquire")) > 0:
+			session := r.URL.Query().Get("acquire")
+			if len(s.LockedBy) > 0 && s.LockedBy != session {
+				fmt.Fprint(w, "false")
+				return
+			}
+			s.LockedBy = session
+			s.KV[key] = body
+			fmt.Fprint(w, "true")
+		case r.Method == "PUT" && len(r.URL.Query().Get("release")) > 0:
+			if s.LockedBy == r.URL.Query().Get("release") {
+				s.LockedBy = ""
+				s.KV[key] = body
+			}
+			fmt.Fprint(w, "true")
+		case r.Method == "PUT":
+			s.KV[key] = body
+			s.Index++
+			fmt.Fprint(w, "true")
+		case r.URL.Query().Get("index") == strconv.Itoa(s.Index):
+			s.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			s.mu.Lock()
+			w.Header().Set("X-Consul-Index", strconv.Itoa(s.Index))
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(key, "/") && len(r.URL.Query()["recurse"]) > 0:
+			keys := []string{}
+			for k := range s.KV {
+				if strings.HasPrefix(k, key) {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sort.Strings(keys)
+			entries := []map[string]interface{}{}
+			for _, k := range keys {
+				entries = append(entries, map[string]interface{}{"Key": k, "Value": s.KV[k]})
+			}
+			js, _ := json.Marshal(entries)
+			w.Write(js)
+		default:
+			w.Header().Set("X-Consul-Index", strconv.Itoa(s.Index))
+			if value, ok := s.KV[key]; ok {
+				w.Write(value)
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
+
+func (s *ReplicasTestSuite) getReplicas(name string) *Replicas {
+	r := NewReplicas(s.BaseReconfigure, name, fmt.Sprintf("%s:8080", name)).(*Replicas)
+	r.RetryInterval = 10 * time.Millisecond
+	return r
+}
+
+func (s *ReplicasTestSuite) getValue(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.KV[key])
+}
+
+// Mock
+
+type ReplicasMock struct {
+	mock.Mock
+}
+
+func (m *ReplicasMock) Watch() error {
+	params := m.Called()
+	return params.Error(0)
+}
+
+func (m *ReplicasMock) Publish(action, serviceName string) error {
+	params := m.Called(action, serviceName)
+	return params.Error(0)
+}
+
+func (m *ReplicasMock) GetStatus() (ReplicasStatus, error) {
+	params := m.Called()
+	return params.Get(0).(ReplicasStatus), params.Error(1)
+}
+
+func (m *ReplicasMock) Stop() {
+	m.Called()
+}
+
+func getReplicasMock(skipMethod string) *ReplicasMock {
+	mockObj := new(ReplicasMock)
+	if skipMethod != "Watch" {
+		mockObj.On("Watch").Return(nil)
+	}
+	if skipMethod != "Publish" {
+		mockObj.On("Publish", mock.Anything, mock.Anything).Return(nil)
+	}
+	if skipMethod != "GetStatus" {
+		mockObj.On("GetStatus").Return(ReplicasStatus{Replicas: []ReplicaStatus{}}, nil)
+	}
+	if skipMethod != "Stop" {
+		mockObj.On("Stop")
+	}
+	return mockObj
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Serverable interface {
@@ -14,35 +18,173 @@ type Serverable interface {
 }
 
 type Server struct {
-	IP   string `short:"i" long:"ip" default:"0.0.0.0" env:"IP" description:"IP the server listens to."`
-	Port string `short:"p" long:"port" default:"8080" env:"PORT" description:"Port the server listens to."`
+	IP            string        `short:"i" long:"ip" default:"0.0.0.0" env:"IP" description:"IP the server listens to."`
+	Port          string        `short:"p" long:"port" default:"8080" env:"PORT" description:"Port the server listens to."`
+	Watch         bool          `short:"w" long:"watch" env:"WATCH" description:"Whether to watch Consul and reconfigure the proxy whenever instances of registered services change."`
+	WatchDebounce time.Duration `long:"watch-debounce" default:"1s" env:"WATCH_DEBOUNCE" description:"The period the watcher waits for further changes before reloading the proxy."`
+	ListenDocker  bool          `long:"listen-docker" env:"LISTEN_DOCKER" description:"Whether to listen to Docker events and reconfigure the proxy whenever containers with com.df.* labels are started or stopped."`
+	DockerHost    string        `long:"docker-host" default:"unix:///var/run/docker.sock" env:"DOCKER_HOST" description:"The address of the Docker Engine API."`
+	ListenSwarm   bool          `long:"listen-swarm" env:"LISTEN_SWARM" description:"Whether to poll Docker Swarm services and reconfigure the proxy whenever services with com.df.* labels are created, updated, or removed."`
+	SwarmInterval time.Duration `long:"swarm-interval" default:"5s" env:"SWARM_INTERVAL" description:"The period between two consecutive requests for the list of Swarm services."`
+	AcmeDirectory string        `long:"acme-directory" env:"ACME_DIRECTORY" description:"The directory URL of the ACME server (e.g. https://acme-v02.api.letsencrypt.org/directory). If specified, certificates for service domains are obtained and renewed automatically."`
+	AcmeEmail     string        `long:"acme-email" env:"ACME_EMAIL" description:"The email of the ACME account."`
+	AcmeCACert    string        `long:"acme-ca-cert" env:"ACME_CA_CERT" description:"The path to the CA certificate of the ACME server. Needed only when the ACME server uses a certificate that is not trusted by the system (e.g. Pebble)."`
+	ReplicasSync  bool          `long:"replicas-sync" env:"REPLICAS_SYNC" description:"Whether to propagate reconfigure and remove requests, as well as certificate changes, to other replicas of the proxy through Consul."`
+	ReplicaName   string        `long:"replica-name" env:"REPLICA_NAME" description:"The name of this replica used when synchronizing with other replicas. Defaults to the hostname."`
+	acme          Acmeable
+	replicas      Replicable
 	BaseReconfigure
+	ProxySettings
 }
 
 var server = Server{}
 
+type ServiceResponse struct {
+	ServiceName        string
+	ServiceColor       string
+	ServicePath        []string
+	ServiceDomain      []string
+	ConsulTemplatePath string
+	ServiceCert        string
+	HttpsOnly          bool
+	Hsts               bool
+	ReqMode            string
+	SrcPort            string
+	ServiceWeights     map[string]int
+	ReqPathSearch      string
+	ReqPathReplace     string
+	StripPrefix        string
+	Users              []string
+	SkipDefaultUsers   bool
+	PathType           string
+	SkipCheck          bool
+	Port               string
+	Servers            []BackendServer
+}
+
+type BackendServer struct {
+	Name    string
+	Address string
+}
+
+type CertResponse struct {
+	Status   string
+	Message  string
+	CertName string
+	Certs    []CertInfo
+}
+
+type StatusResponse struct {
+	Status   string
+	Message  string
+	Revision int
+	Replicas []ReplicaStatus
+}
+
+type HistoryResponse struct {
+	Status   string
+	Message  string
+	Versions []ConfigVersion
+}
+
+type SettingsResponse struct {
+	Status   string
+	Message  string
+	Settings ProxySettings
+}
+
 type Response struct {
 	Status             string
 	Message            string
 	ServiceName        string
 	ServiceColor       string
 	ServicePath        []string
-	ServiceDomain      string
+	ServiceDomain      []string
 	ConsulTemplatePath string
+	ServiceCert        string
+	HttpsOnly          bool
+	Hsts               bool
+	ReqMode            string
+	SrcPort            string
+	ServiceWeights     map[string]int
+	ReqPathSearch      string
+	ReqPathReplace     string
+	StripPrefix        string
+	Users              []string
+	SkipDefaultUsers   bool
 	PathType           string
 	SkipCheck          bool
+	Port               string
+	Diff               string `json:",omitempty"`
 }
 
 func (m Server) Execute(args []string) error {
+	if m.Watch && len(m.RegistryType) > 0 && m.RegistryType != "consul" {
+		return fmt.Errorf("Watching is supported only with the consul registry")
+	}
+	if m.ReplicasSync && len(m.RegistryType) > 0 && m.RegistryType != "consul" {
+		return fmt.Errorf("Synchronizing replicas is supported only with the consul registry")
+	}
 	logPrintf("Starting HAProxy")
 	NewRun().Execute([]string{})
 	address := fmt.Sprintf("%s:%s", m.IP, m.Port)
+	if err := NewSettings(m.BaseReconfigure).Init(m.ProxySettings); err != nil {
+		return err
+	}
+	if err := NewCert(m.BaseReconfigure).Restore(); err != nil {
+		return err
+	}
 	if err := NewReconfigure(
 		m.BaseReconfigure,
 		ServiceReconfigure{},
 	).ReloadAllServices(m.ConsulAddress); err != nil {
 		return err
 	}
+	mu.Lock()
+	if err := NewHistory(m.BaseReconfigure, m.HistoryLimit).Record("start", "start", ServiceReconfigure{}); err != nil {
+		logPrintf("Could not record the initial configuration\n%s", err.Error())
+	}
+	mu.Unlock()
+	if m.ReplicasSync {
+		m.replicas = NewReplicas(m.BaseReconfigure, m.getReplicaName(), m.getReplicaAddress())
+		go func() {
+			if err := m.replicas.Watch(); err != nil {
+				logPrintf("Could not synchronize with other replicas\n%s", err.Error())
+			}
+		}()
+	}
+	if m.Watch {
+		watcher := NewWatcher(m.BaseReconfigure, m.WatchDebounce)
+		go func() {
+			if err := watcher.Watch(); err != nil {
+				logPrintf("Could not watch Consul\n%s", err.Error())
+			}
+		}()
+	}
+	if m.ListenDocker {
+		listener := NewDockerListener(m.BaseReconfigure, m.DockerHost)
+		go func() {
+			if err := listener.Watch(); err != nil {
+				logPrintf("Could not listen to Docker events\n%s", err.Error())
+			}
+		}()
+	}
+	if m.ListenSwarm {
+		listener := NewSwarmListener(m.BaseReconfigure, m.DockerHost, m.SwarmInterval)
+		go func() {
+			if err := listener.Watch(); err != nil {
+				logPrintf("Could not poll Swarm services\n%s", err.Error())
+			}
+		}()
+	}
+	if len(m.AcmeDirectory) > 0 {
+		m.acme = NewAcme(m.BaseReconfigure, m.AcmeDirectory, m.AcmeEmail, m.AcmeCACert, m.getLocalAddress(), m.replicas)
+		go func() {
+			if err := m.acme.Watch(); err != nil {
+				logPrintf("Could not obtain certificates\n%s", err.Error())
+			}
+		}()
+	}
 	logPrintf(`Starting "Docker Flow: Proxy"`)
 	if err := httpListenAndServe(address, m); err != nil {
 		return err
@@ -57,39 +199,69 @@ func (m Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		sr := ServiceReconfigure{
 			ServiceName:        req.URL.Query().Get("serviceName"),
 			ServiceColor:       req.URL.Query().Get("serviceColor"),
-			ServiceDomain:      req.URL.Query().Get("serviceDomain"),
 			ConsulTemplatePath: req.URL.Query().Get("consulTemplatePath"),
+			ServiceCert:        req.URL.Query().Get("serviceCert"),
 			PathType:           req.URL.Query().Get("pathType"),
+			ReqMode:            req.URL.Query().Get("reqMode"),
+			SrcPort:            req.URL.Query().Get("srcPort"),
+			ReqPathSearch:      req.URL.Query().Get("reqPathSearch"),
+			ReqPathReplace:     req.URL.Query().Get("reqPathReplace"),
+			StripPrefix:        req.URL.Query().Get("stripPrefix"),
 		}
 		if len(req.URL.Query().Get("servicePath")) > 0 {
 			sr.ServicePath = strings.Split(req.URL.Query().Get("servicePath"), ",")
 		}
+		if len(req.URL.Query().Get("serviceDomain")) > 0 {
+			sr.ServiceDomain = strings.Split(req.URL.Query().Get("serviceDomain"), ",")
+		}
+		if len(req.URL.Query().Get("users")) > 0 {
+			sr.Users = strings.Split(req.URL.Query().Get("users"), ",")
+		}
 		if len(req.URL.Query().Get("skipCheck")) > 0 {
 			sr.SkipCheck, _ = strconv.ParseBool(req.URL.Query().Get("skipCheck"))
 		}
-		response := Response{
-			Status:             "OK",
-			ServiceName:        sr.ServiceName,
-			ServiceColor:       sr.ServiceColor,
-			ServicePath:        sr.ServicePath,
-			ServiceDomain:      sr.ServiceDomain,
-			ConsulTemplatePath: sr.ConsulTemplatePath,
-			PathType:           sr.PathType,
-			SkipCheck:          sr.SkipCheck,
+		if len(req.URL.Query().Get("httpsOnly")) > 0 {
+			sr.HttpsOnly, _ = strconv.ParseBool(req.URL.Query().Get("httpsOnly"))
+		}
+		if len(req.URL.Query().Get("skipDefaultUsers")) > 0 {
+			sr.SkipDefaultUsers, _ = strconv.ParseBool(req.URL.Query().Get("skipDefaultUsers"))
 		}
-		if len(sr.ServiceName) > 0 && (len(sr.ServicePath) > 0 || len(sr.ConsulTemplatePath) > 0) {
+		if len(req.URL.Query().Get("hsts")) > 0 {
+			sr.Hsts, _ = strconv.ParseBool(req.URL.Query().Get("hsts"))
+		}
+		weights, weightsErr := parseServiceWeights(req.URL.Query().Get("serviceWeight"))
+		sr.ServiceWeights = weights
+		response := m.getResponse(sr)
+		if weightsErr != nil {
+			response.Status = "NOK"
+			response.Message = weightsErr.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		} else if len(sr.ServiceName) > 0 && isServiceConfigured(sr) {
 			action := NewReconfigure(
-				m.BaseReconfigure,
+				m.getChangeData(req),
 				sr,
 			)
-			if err := action.Execute([]string{}); err != nil {
+			dryRun, _ := strconv.ParseBool(req.URL.Query().Get("dryRun"))
+			if dryRun {
+				diff, err := action.GetConfigDiff()
+				if err != nil {
+					response.Status = "NOK"
+					response.Message = err.Error()
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				response.Diff = diff
+			} else if err := action.Execute([]string{}); err != nil {
 				response.Status = "NOK"
 				response.Message = fmt.Sprintf("%s", err.Error())
 				w.WriteHeader(http.StatusInternalServerError)
+			} else if err := m.onChange("reconfigure", sr.ServiceName); err != nil {
+				response.Status = "NOK"
+				response.Message = err.Error()
+				w.WriteHeader(http.StatusInternalServerError)
 			}
 		} else {
 			response.Status = "NOK"
-			response.Message = "The following queries are mandatory: serviceName and (servicePath or consulTemplatePath)"
+			response.Message = "The following queries are mandatory: serviceName and (servicePath, consulTemplatePath, or reqMode=tcp)"
 			w.WriteHeader(http.StatusBadRequest)
 		}
 		httpWriterSetContentType(w, "application/json")
@@ -106,16 +278,56 @@ func (m Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			response.Message = "The following queries are mandatory: serviceName and servicePath"
 			w.WriteHeader(http.StatusBadRequest)
 		} else {
-			action := NewRemove(
-				serviceName,
-				m.BaseReconfigure.ConfigsPath,
-				m.BaseReconfigure.TemplatesPath,
-			)
-			action.Execute([]string{})
+			keepRegistry, _ := strconv.ParseBool(req.URL.Query().Get("keepRegistry"))
+			action := NewRemove(serviceName, keepRegistry, m.getChangeData(req))
+			if err := action.Execute([]string{}); err != nil {
+				response.Status = "NOK"
+				response.Message = err.Error()
+				w.WriteHeader(http.StatusInternalServerError)
+			} else if err := m.onChange("remove", serviceName); err != nil {
+				response.Status = "NOK"
+				response.Message = err.Error()
+				w.WriteHeader(http.StatusInternalServerError)
+			}
 		}
 		httpWriterSetContentType(w, "application/json")
 		js, _ := json.Marshal(response)
 		w.Write(js)
+	case "/v1/docker-flow-proxy/shift":
+		m.serveShift(w, req)
+	case "/v1/docker-flow-proxy/services":
+		services, err := m.getServices()
+		httpWriterSetContentType(w, "application/json")
+		if err != nil {
+			js, _ := json.Marshal(Response{Status: "NOK", Message: err.Error()})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(js)
+			return
+		}
+		js, _ := json.Marshal(services)
+		w.Write(js)
+	case "/v1/docker-flow-proxy/config":
+		path := fmt.Sprintf("%s/haproxy.cfg", m.ConfigsPath)
+		content, err := readConfigsFile(path)
+		if err != nil {
+			httpWriterSetContentType(w, "application/json")
+			js, _ := json.Marshal(Response{Status: "NOK", Message: fmt.Sprintf("Could not read the file %s\n%s", path, err.Error())})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(js)
+			return
+		}
+		httpWriterSetContentType(w, "text/plain")
+		w.Write(content)
+	case "/v1/docker-flow-proxy/cert":
+		m.serveCert(w, req)
+	case "/v1/docker-flow-proxy/settings":
+		m.serveSettings(w, req)
+	case "/v1/docker-flow-proxy/status":
+		m.serveStatus(w, req)
+	case "/v1/docker-flow-proxy/history":
+		m.serveHistory(w, req)
+	case "/v1/docker-flow-proxy/rollback":
+		m.serveRollback(w, req)
 	case "/v1/test", "/v2/test":
 		js, _ := json.Marshal(Response{Status: "OK"})
 		httpWriterSetContentType(w, "application/json")
@@ -123,6 +335,480 @@ func (m Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write(js)
 	default:
+		if strings.HasPrefix(req.URL.Path, "/v1/docker-flow-proxy/services/") {
+			m.serveService(w, req)
+		} else if strings.HasPrefix(req.URL.Path, ACME_CHALLENGE_PATH) && m.acme != nil {
+			m.acme.ServeHTTP(w, req)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func (m Server) serveService(w http.ResponseWriter, req *http.Request) {
+	serviceName := strings.TrimPrefix(req.URL.Path, "/v1/docker-flow-proxy/services/")
+	response := Response{
+		Status:      "OK",
+		ServiceName: serviceName,
+	}
+	switch {
+	case len(serviceName) == 0 || strings.Contains(serviceName, "/"):
+		response.Status = "NOK"
+		response.Message = "The service name is mandatory (e.g. /v1/docker-flow-proxy/services/my-service)"
+		w.WriteHeader(http.StatusBadRequest)
+	case req.Method == "POST" || req.Method == "PUT":
+		sr := ServiceReconfigure{}
+		defer req.Body.Close()
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+			response.Status = "NOK"
+			response.Message = fmt.Sprintf("Could not parse the request body\n%s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			break
+		}
+		sr.ServiceName = serviceName
+		response = m.getResponse(sr)
+		if !isServiceConfigured(sr) {
+			response.Status = "NOK"
+			response.Message = "The following fields are mandatory: ServicePath, ConsulTemplatePath, or ReqMode tcp"
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := NewReconfigure(m.getChangeData(req), sr).Execute([]string{}); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if err := m.onChange("reconfigure", sr.ServiceName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	case req.Method == "DELETE":
+		keepRegistry, _ := strconv.ParseBool(req.URL.Query().Get("keepRegistry"))
+		if err := NewRemove(serviceName, keepRegistry, m.getChangeData(req)).Execute([]string{}); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if err := m.onChange("remove", serviceName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	case req.Method == "GET":
+		registry, err := NewRegistry(m.BaseReconfigure)
+		if err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+			break
+		}
+		c := make(chan ServiceReconfigure, 1)
+		(&Reconfigure{BaseReconfigure: m.BaseReconfigure}).getService(registry, serviceName, c)
+		sr := <-c
+		if !isServiceConfigured(sr) {
+			response.Status = "NOK"
+			response.Message = fmt.Sprintf("The service %s is not configured", serviceName)
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			response = m.getResponse(sr)
+		}
+	default:
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The method %s is not allowed", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveShift(w http.ResponseWriter, req *http.Request) {
+	serviceName := req.URL.Query().Get("serviceName")
+	from := req.URL.Query().Get("from")
+	to := req.URL.Query().Get("to")
+	step, _ := strconv.Atoi(req.URL.Query().Get("step"))
+	response := Response{
+		Status:      "OK",
+		ServiceName: serviceName,
+	}
+	if len(serviceName) == 0 || len(from) == 0 || len(to) == 0 || step <= 0 {
+		response.Status = "NOK"
+		response.Message = "The following queries are mandatory: serviceName, from, to, and step (greater than 0)"
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		sr, err := NewReconfigure(m.getChangeData(req), ServiceReconfigure{ServiceName: serviceName}).Shift(from, to, step)
+		sr.ServiceName = serviceName
+		response = m.getResponse(sr)
+		if err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if err := m.onChange("reconfigure", sr.ServiceName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveCert(w http.ResponseWriter, req *http.Request) {
+	certName := req.URL.Query().Get("certName")
+	response := CertResponse{
+		Status:   "OK",
+		CertName: certName,
+	}
+	cert := NewCert(m.BaseReconfigure)
+	switch req.Method {
+	case "PUT", "POST":
+		defer req.Body.Close()
+		content, _ := ioutil.ReadAll(req.Body)
+		if len(certName) == 0 {
+			response.Status = "NOK"
+			response.Message = "The following queries are mandatory: certName"
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := cert.Put(certName, content); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := m.publishCert("putCert", certName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	case "GET":
+		certs, err := cert.GetAll()
+		if err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Certs = certs
+	case "DELETE":
+		if len(certName) == 0 {
+			response.Status = "NOK"
+			response.Message = "The following queries are mandatory: certName"
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := cert.Delete(certName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if err := m.publishCert("deleteCert", certName); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	default:
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The method %s is not allowed", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveSettings(w http.ResponseWriter, req *http.Request) {
+	response := SettingsResponse{Status: "OK"}
+	settings := NewSettings(m.BaseReconfigure)
+	current, err := settings.Get()
+	switch {
+	case err != nil:
+		response.Status = "NOK"
+		response.Message = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	case req.Method == "GET":
+		response.Settings = current
+	case req.Method == "PUT" || req.Method == "POST":
+		defer req.Body.Close()
+		if err := json.NewDecoder(req.Body).Decode(&current); err != nil {
+			response.Status = "NOK"
+			response.Message = fmt.Sprintf("Could not parse the request body\n%s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err := settings.Put(current); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			response.Settings, _ = settings.Get()
+		}
+	default:
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The method %s is not allowed", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveHistory(w http.ResponseWriter, req *http.Request) {
+	response := HistoryResponse{Status: "OK", Versions: []ConfigVersion{}}
+	history := NewHistory(m.BaseReconfigure, m.HistoryLimit)
+	if len(req.URL.Query().Get("version")) == 0 {
+		if versions, err := history.GetAll(); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			response.Versions = versions
+		}
+	} else if version, err := strconv.Atoi(req.URL.Query().Get("version")); err != nil {
+		response.Status = "NOK"
+		response.Message = "The version query must be a number"
+		w.WriteHeader(http.StatusBadRequest)
+	} else if data, found, err := history.Get(version); err != nil {
+		response.Status = "NOK"
+		response.Message = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	} else if !found {
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The version %d does not exist", version)
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		response.Versions = []ConfigVersion{data}
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveRollback(w http.ResponseWriter, req *http.Request) {
+	response := HistoryResponse{Status: "OK", Versions: []ConfigVersion{}}
+	history := NewHistory(m.BaseReconfigure, m.HistoryLimit)
+	version, versionErr := strconv.Atoi(req.URL.Query().Get("version"))
+	if req.Method != "POST" && req.Method != "PUT" {
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The method %s is not allowed", req.Method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	} else if versionErr != nil {
+		response.Status = "NOK"
+		response.Message = "The version query is mandatory and must be a number"
+		w.WriteHeader(http.StatusBadRequest)
+	} else if _, found, err := history.Get(version); err == nil && !found {
+		response.Status = "NOK"
+		response.Message = fmt.Sprintf("The version %d does not exist", version)
+		w.WriteHeader(http.StatusNotFound)
+	} else if versions, err := history.GetAll(); err != nil {
+		response.Status = "NOK"
+		response.Message = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	} else if data, err := history.Rollback(version); err != nil {
+		response.Status = "NOK"
+		response.Message = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		response.Versions = []ConfigVersion{data}
+		previous := map[string]ServiceReconfigure{}
+		if len(versions) > 0 {
+			previous = versions[0].Services
+		}
+		if err := m.publishRollback(previous, data.Services); err != nil {
+			response.Status = "NOK"
+			response.Message = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+func (m Server) serveStatus(w http.ResponseWriter, req *http.Request) {
+	response := StatusResponse{Status: "OK", Replicas: []ReplicaStatus{}}
+	if m.replicas == nil {
+		response.Status = "NOK"
+		response.Message = "Replicas are not synchronized. Please start the server with the --replicas-sync argument"
 		w.WriteHeader(http.StatusNotFound)
+	} else if status, err := m.replicas.GetStatus(); err != nil {
+		response.Status = "NOK"
+		response.Message = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		response.Revision = status.Revision
+		response.Replicas = status.Replicas
+	}
+	httpWriterSetContentType(w, "application/json")
+	js, _ := json.Marshal(response)
+	w.Write(js)
+}
+
+// publishCert propagates a certificate change to other replicas. The certificate itself is shared through the registry.
+func (m Server) publishCert(action, certName string) error {
+	if m.replicas == nil {
+		return nil
+	}
+	if err := m.replicas.Publish(action, certName); err != nil {
+		return fmt.Errorf("The certificate was changed but it could not be propagated to other replicas\n%s", err.Error())
+	}
+	return nil
+}
+
+// publishRollback propagates services restored or removed by a rollback to other replicas.
+func (m Server) publishRollback(previous, restored map[string]ServiceReconfigure) error {
+	if m.replicas == nil {
+		return nil
+	}
+	names := []string{}
+	for name := range restored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := m.replicas.Publish("reconfigure", name); err != nil {
+			return fmt.Errorf("The rollback was applied but it could not be propagated to other replicas\n%s", err.Error())
+		}
+	}
+	names = []string{}
+	for name := range previous {
+		if _, ok := restored[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := m.replicas.Publish("remove", name); err != nil {
+			return fmt.Errorf("The rollback was applied but it could not be propagated to other replicas\n%s", err.Error())
+		}
+	}
+	return nil
+}
+
+// getChangeData returns the data for changes applied through the API.
+// Reconfigure and Remove record those changes in the history under the request while they still hold mu.
+func (m Server) getChangeData(req *http.Request) BaseReconfigure {
+	return m.BaseReconfigure.withHistoryRequest(fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI()))
+}
+
+// onChange propagates a change applied through the API to other replicas.
+func (m Server) onChange(action, serviceName string) error {
+	if m.replicas == nil {
+		return nil
+	}
+	if err := m.replicas.Publish(action, serviceName); err != nil {
+		return fmt.Errorf("The change was applied but it could not be propagated to other replicas\n%s", err.Error())
+	}
+	return nil
+}
+
+func (m Server) getServices() ([]ServiceResponse, error) {
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		return nil, err
+	}
+	files, err := readConfigsDir(m.TemplatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the directory %s\n%s", m.TemplatesPath, err.Error())
+	}
+	services := []ServiceResponse{}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".cfg") || fi.Name() == ACME_SERVICE_NAME+".cfg" {
+			continue
+		}
+		c := make(chan ServiceReconfigure, 1)
+		(&Reconfigure{BaseReconfigure: m.BaseReconfigure}).getService(registry, strings.TrimSuffix(fi.Name(), ".cfg"), c)
+		sr := <-c
+		servers, err := m.getBackendServers(fmt.Sprintf("%s/%s", m.TemplatesPath, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, ServiceResponse{
+			ServiceName:        sr.ServiceName,
+			ServiceColor:       sr.ServiceColor,
+			ServicePath:        sr.ServicePath,
+			ServiceDomain:      sr.ServiceDomain,
+			ConsulTemplatePath: sr.ConsulTemplatePath,
+			ServiceCert:        sr.ServiceCert,
+			PathType:           sr.PathType,
+			HttpsOnly:          sr.HttpsOnly,
+			Hsts:               sr.Hsts,
+			ReqMode:            sr.ReqMode,
+			SrcPort:            sr.SrcPort,
+			ServiceWeights:     sr.ServiceWeights,
+			ReqPathSearch:      sr.ReqPathSearch,
+			ReqPathReplace:     sr.ReqPathReplace,
+			StripPrefix:        sr.StripPrefix,
+			Users:              m.getUserNames(sr.Users),
+			SkipDefaultUsers:   sr.SkipDefaultUsers,
+			SkipCheck:          sr.SkipCheck,
+			Port:               sr.Port,
+			Servers:            servers,
+		})
+	}
+	return services, nil
+}
+
+func (m Server) getBackendServers(path string) ([]BackendServer, error) {
+	content, err := readConfigsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the file %s\n%s", path, err.Error())
+	}
+	servers := []BackendServer{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "server" {
+			servers = append(servers, BackendServer{Name: fields[1], Address: fields[2]})
+		}
+	}
+	return servers, nil
+}
+
+func (m Server) getLocalAddress() string {
+	ip := m.IP
+	if len(ip) == 0 || ip == "0.0.0.0" {
+		ip = "127.0.0.1"
+	}
+	return fmt.Sprintf("%s:%s", ip, m.Port)
+}
+
+func (m Server) getReplicaName() string {
+	if len(m.ReplicaName) > 0 {
+		return m.ReplicaName
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func (m Server) getReplicaAddress() string {
+	if len(m.IP) > 0 && m.IP != "0.0.0.0" {
+		return fmt.Sprintf("%s:%s", m.IP, m.Port)
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%s", hostname, m.Port)
+}
+
+// getUserNames strips password hashes so that they are kept only in the registry and the proxy configuration.
+func (m Server) getUserNames(users []string) []string {
+	if len(users) == 0 {
+		return users
+	}
+	names := []string{}
+	for _, user := range users {
+		names = append(names, strings.SplitN(user, ":", 2)[0])
+	}
+	return names
+}
+
+func (m Server) getResponse(sr ServiceReconfigure) Response {
+	return Response{
+		Status:             "OK",
+		ServiceName:        sr.ServiceName,
+		ServiceColor:       sr.ServiceColor,
+		ServicePath:        sr.ServicePath,
+		ServiceDomain:      sr.ServiceDomain,
+		ConsulTemplatePath: sr.ConsulTemplatePath,
+		ServiceCert:        sr.ServiceCert,
+		PathType:           sr.PathType,
+		HttpsOnly:          sr.HttpsOnly,
+		Hsts:               sr.Hsts,
+		ReqMode:            sr.ReqMode,
+		SrcPort:            sr.SrcPort,
+		ServiceWeights:     sr.ServiceWeights,
+		ReqPathSearch:      sr.ReqPathSearch,
+		ReqPathReplace:     sr.ReqPathReplace,
+		StripPrefix:        sr.StripPrefix,
+		Users:              m.getUserNames(sr.Users),
+		SkipDefaultUsers:   sr.SkipDefaultUsers,
+		SkipCheck:          sr.SkipCheck,
+		Port:               sr.Port,
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 type ServerTestSuite struct {
@@ -29,7 +30,7 @@ func (s *ServerTestSuite) SetupTest() {
 	s.ConsulAddress = "http://1.2.3.4:1234"
 	s.ServiceName = "myService"
 	s.ServiceColor = "pink"
-	s.ServiceDomain = "my-domain.com"
+	s.ServiceDomain = []string{"my-domain.com"}
 	s.ServicePath = []string{"/path/to/my/service/api", "/path/to/my/other/service/api"}
 	s.ReconfigureBaseUrl = "/v1/docker-flow-proxy/reconfigure"
 	s.RemoveBaseUrl = "/v1/docker-flow-proxy/remove"
@@ -39,7 +40,7 @@ func (s *ServerTestSuite) SetupTest() {
 		s.ServiceName,
 		s.ServiceColor,
 		strings.Join(s.ServicePath, ","),
-		s.ServiceDomain,
+		strings.Join(s.ServiceDomain, ","),
 	)
 	s.RemoveUrl = fmt.Sprintf(
 		"%s?serviceName=%s",
@@ -60,6 +61,15 @@ func (s *ServerTestSuite) SetupTest() {
 	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
 		return getReconfigureMock("")
 	}
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return getCertMock("")
+	}
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return getSettingsMock("")
+	}
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return getHistoryMock("")
+	}
 	logPrintf = func(format string, v ...interface{}) {}
 }
 
@@ -120,6 +130,70 @@ func (s *ServerTestSuite) Test_Execute_InvokesReloadAllServices() {
 	mockObj.AssertCalled(s.T(), "ReloadAllServices", s.ConsulAddress)
 }
 
+func (s *ServerTestSuite) Test_Execute_RestoresCertificatesBeforeReloadingServices() {
+	certMock := getCertMock("Restore")
+	reconfigureMock := getReconfigureMock("")
+	calls := []string{}
+	certMock.On("Restore").Return(nil).Run(func(args mock.Arguments) { calls = append(calls, "Restore") })
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		calls = append(calls, "ReloadAllServices")
+		return reconfigureMock
+	}
+
+	server.Execute([]string{})
+
+	certMock.AssertCalled(s.T(), "Restore")
+	s.Equal([]string{"Restore", "ReloadAllServices"}, calls)
+}
+
+func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenRestoringCertificatesFails() {
+	certMock := getCertMock("Restore")
+	certMock.On("Restore").Return(fmt.Errorf("This is an error"))
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+
+	actual := server.Execute([]string{})
+
+	s.Error(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_InitializesSettingsBeforeReloadingServices() {
+	settingsMock := getSettingsMock("Init")
+	reconfigureMock := getReconfigureMock("")
+	calls := []string{}
+	settingsMock.On("Init", mock.Anything).Return(nil).Run(func(args mock.Arguments) { calls = append(calls, "Init") })
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return settingsMock
+	}
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		calls = append(calls, "ReloadAllServices")
+		return reconfigureMock
+	}
+	srv := server
+	srv.ProxySettings = ProxySettings{TimeoutServer: "60s"}
+
+	srv.Execute([]string{})
+
+	settingsMock.AssertCalled(s.T(), "Init", ProxySettings{TimeoutServer: "60s"})
+	s.Equal([]string{"Init", "ReloadAllServices"}, calls)
+}
+
+func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenSettingsInitFails() {
+	settingsMock := getSettingsMock("Init")
+	settingsMock.On("Init", mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return settingsMock
+	}
+
+	actual := server.Execute([]string{})
+
+	s.Error(actual)
+}
+
 func (s *ServerTestSuite) Test_Execute_ReturnsErrro_WhenReloadAllServicesFails() {
 	mockObj := getReconfigureMock("ReloadAllServices")
 	mockObj.On("ReloadAllServices", mock.Anything).Return(fmt.Errorf("This is an error"))
@@ -132,8 +206,244 @@ func (s *ServerTestSuite) Test_Execute_ReturnsErrro_WhenReloadAllServicesFails()
 	s.Error(actual)
 }
 
+func (s *ServerTestSuite) Test_Execute_StartsWatcher_WhenWatchIsTrue() {
+	orig := NewWatcher
+	defer func() { NewWatcher = orig }()
+	mockObj := getWatcherMock("")
+	var actualBase BaseReconfigure
+	var actualDebounce time.Duration
+	called := make(chan bool, 1)
+	NewWatcher = func(baseData BaseReconfigure, debounce time.Duration) Watchable {
+		actualBase = baseData
+		actualDebounce = debounce
+		called <- true
+		return mockObj
+	}
+	srv := Server{
+		Watch:           true,
+		WatchDebounce:   time.Second,
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress},
+	}
+
+	srv.Execute([]string{})
+
+	<-called
+	s.Equal(srv.BaseReconfigure, actualBase)
+	s.Equal(time.Second, actualDebounce)
+}
+
+func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenWatchIsUsedWithoutConsulRegistry() {
+	srv := Server{
+		Watch:           true,
+		BaseReconfigure: BaseReconfigure{RegistryType: "etcd"},
+	}
+
+	actual := srv.Execute([]string{})
+
+	s.Error(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_StartsReplicasSync_WhenReplicasSyncIsTrue() {
+	orig := NewReplicas
+	defer func() { NewReplicas = orig }()
+	mockObj := getReplicasMock("")
+	var actualName string
+	called := make(chan bool, 1)
+	NewReplicas = func(baseData BaseReconfigure, name, address string) Replicable {
+		actualName = name
+		called <- true
+		return mockObj
+	}
+	srv := Server{
+		ReplicasSync:    true,
+		ReplicaName:     "proxy-1",
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress},
+	}
+
+	srv.Execute([]string{})
+
+	<-called
+	s.Equal("proxy-1", actualName)
+}
+
+func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenReplicasSyncIsUsedWithoutConsulRegistry() {
+	srv := Server{
+		ReplicasSync:    true,
+		BaseReconfigure: BaseReconfigure{RegistryType: "file"},
+	}
+
+	actual := srv.Execute([]string{})
+
+	s.Error(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_RecordsInitialConfiguration() {
+	mockObj := getHistoryMock("")
+	actualLimit := 0
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		actualLimit = limit
+		return mockObj
+	}
+	srv := Server{
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress, HistoryLimit: 5},
+	}
+
+	srv.Execute([]string{})
+
+	s.Equal(5, actualLimit)
+	mockObj.AssertCalled(s.T(), "Record", "start", "start", ServiceReconfigure{})
+}
+
+func (s *ServerTestSuite) Test_Execute_DoesNotStartWatcher_WhenWatchIsFalse() {
+	orig := NewWatcher
+	defer func() { NewWatcher = orig }()
+	actual := false
+	NewWatcher = func(baseData BaseReconfigure, debounce time.Duration) Watchable {
+		actual = true
+		return getWatcherMock("")
+	}
+
+	server.Execute([]string{})
+
+	s.False(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_StartsDockerListener_WhenListenDockerIsTrue() {
+	orig := NewDockerListener
+	defer func() { NewDockerListener = orig }()
+	mockObj := getWatcherMock("")
+	var actualHost string
+	called := make(chan bool, 1)
+	NewDockerListener = func(baseData BaseReconfigure, host string) Watchable {
+		actualHost = host
+		called <- true
+		return mockObj
+	}
+	srv := Server{
+		ListenDocker:    true,
+		DockerHost:      "unix:///var/run/docker.sock",
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress},
+	}
+
+	srv.Execute([]string{})
+
+	<-called
+	s.Equal("unix:///var/run/docker.sock", actualHost)
+}
+
+func (s *ServerTestSuite) Test_Execute_DoesNotStartDockerListener_WhenListenDockerIsFalse() {
+	orig := NewDockerListener
+	defer func() { NewDockerListener = orig }()
+	actual := false
+	NewDockerListener = func(baseData BaseReconfigure, host string) Watchable {
+		actual = true
+		return getWatcherMock("")
+	}
+
+	server.Execute([]string{})
+
+	s.False(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_StartsSwarmListener_WhenListenSwarmIsTrue() {
+	orig := NewSwarmListener
+	defer func() { NewSwarmListener = orig }()
+	mockObj := getWatcherMock("")
+	var actualHost string
+	var actualInterval time.Duration
+	called := make(chan bool, 1)
+	NewSwarmListener = func(baseData BaseReconfigure, host string, interval time.Duration) Watchable {
+		actualHost = host
+		actualInterval = interval
+		called <- true
+		return mockObj
+	}
+	srv := Server{
+		ListenSwarm:     true,
+		DockerHost:      "unix:///var/run/docker.sock",
+		SwarmInterval:   time.Second,
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress},
+	}
+
+	srv.Execute([]string{})
+
+	<-called
+	s.Equal("unix:///var/run/docker.sock", actualHost)
+	s.Equal(time.Second, actualInterval)
+}
+
+func (s *ServerTestSuite) Test_Execute_DoesNotStartSwarmListener_WhenListenSwarmIsFalse() {
+	orig := NewSwarmListener
+	defer func() { NewSwarmListener = orig }()
+	actual := false
+	NewSwarmListener = func(baseData BaseReconfigure, host string, interval time.Duration) Watchable {
+		actual = true
+		return getWatcherMock("")
+	}
+
+	server.Execute([]string{})
+
+	s.False(actual)
+}
+
+func (s *ServerTestSuite) Test_Execute_StartsAcme_WhenAcmeDirectoryIsSet() {
+	orig := NewAcme
+	defer func() { NewAcme = orig }()
+	mockObj := getAcmeMock("")
+	var actualDirectory, actualEmail, actualAddress string
+	NewAcme = func(baseData BaseReconfigure, directory, email, caCert, address string, replicas Replicable) Acmeable {
+		actualDirectory = directory
+		actualEmail = email
+		actualAddress = address
+		return mockObj
+	}
+	var handler http.Handler
+	httpListenAndServe = func(addr string, h http.Handler) error {
+		handler = h
+		return nil
+	}
+	srv := Server{
+		IP:              "0.0.0.0",
+		Port:            "8080",
+		AcmeDirectory:   "https://acme.example.com/directory",
+		AcmeEmail:       "me@example.com",
+		BaseReconfigure: BaseReconfigure{ConsulAddress: s.ConsulAddress},
+	}
+
+	srv.Execute([]string{})
+	req, _ := http.NewRequest("GET", "/.well-known/acme-challenge/my-token", nil)
+	handler.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal("https://acme.example.com/directory", actualDirectory)
+	s.Equal("me@example.com", actualEmail)
+	s.Equal("127.0.0.1:8080", actualAddress)
+	mockObj.AssertCalled(s.T(), "ServeHTTP", s.ResponseWriter, req)
+}
+
+func (s *ServerTestSuite) Test_Execute_DoesNotStartAcme_WhenAcmeDirectoryIsEmpty() {
+	orig := NewAcme
+	defer func() { NewAcme = orig }()
+	actual := false
+	NewAcme = func(baseData BaseReconfigure, directory, email, caCert, address string, replicas Replicable) Acmeable {
+		actual = true
+		return getAcmeMock("")
+	}
+
+	server.Execute([]string{})
+
+	s.False(actual)
+}
+
 // ServeHTTP
 
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404_WhenAcmeIsDisabled() {
+	req, _ := http.NewRequest("GET", "/.well-known/acme-challenge/my-token", nil)
+
+	Server{}.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 404)
+}
+
 func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404WhenURLIsUnknown() {
 	req, _ := http.NewRequest("GET", "/this/url/does/not/exist", nil)
 
@@ -199,6 +509,24 @@ func (s *ServerTestSuite) Test_ServeHTTP_ReturnsJsonWithPathType_WhenPresent() {
 	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
 }
 
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsJsonWithHttpsOnlyAndHsts_WhenPresent() {
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&httpsOnly=true&hsts=true", nil)
+	expected, _ := json.Marshal(Response{
+		Status:        "OK",
+		ServiceName:   s.ServiceName,
+		ServiceColor:  s.ServiceColor,
+		ServicePath:   s.ServicePath,
+		ServiceDomain: s.ServiceDomain,
+		PathType:      s.PathType,
+		HttpsOnly:     true,
+		Hsts:          true,
+	})
+
+	Server{}.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
+}
+
 func (s *ServerTestSuite) Test_ServeHTTP_ReturnsJsonWithSkipCheck_WhenPresent() {
 	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&skipCheck=true", nil)
 	expected, _ := json.Marshal(Response{
@@ -249,7 +577,7 @@ func (s *ServerTestSuite) Test_ServeHTTP_InvokesReconfigureExecute() {
 
 	server.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
 
-	s.Equal(expectedBase, actualBase)
+	s.Equal(expectedBase.withHistoryRequest("GET "+s.ReconfigureUrl), actualBase)
 	s.Equal(s.ServiceReconfigure, actualService)
 	mockObj.AssertCalled(s.T(), "Execute", []string{})
 }
@@ -266,6 +594,67 @@ func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenReconfigureExecute
 	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
 }
 
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsErrorMessage_WhenReconfigureExecuteFails() {
+	mockObj := getReconfigureMock("Execute")
+	mockObj.On("Execute", []string{}).Return(fmt.Errorf("The proxy configuration is not valid\n[ALERT] unknown keyword"))
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	expected := Response{
+		Status:        "NOK",
+		Message:       "The proxy configuration is not valid\n[ALERT] unknown keyword",
+		ServiceName:   s.ServiceName,
+		ServiceColor:  s.ServiceColor,
+		ServicePath:   s.ServicePath,
+		ServiceDomain: s.ServiceDomain,
+	}
+	js, _ := json.Marshal(expected)
+
+	Server{}.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", js)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsDiff_WhenDryRunIsTrue() {
+	mockObj := getReconfigureMock("GetConfigDiff")
+	mockObj.On("GetConfigDiff").Return("--- haproxy.cfg\n+++ haproxy.cfg.new\n", nil)
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	historyMock := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return historyMock
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&dryRun=true", nil)
+	expected, _ := json.Marshal(Response{
+		Status:        "OK",
+		ServiceName:   s.ServiceName,
+		ServiceColor:  s.ServiceColor,
+		ServicePath:   s.ServicePath,
+		ServiceDomain: s.ServiceDomain,
+		Diff:          "--- haproxy.cfg\n+++ haproxy.cfg.new\n",
+	})
+
+	Server{}.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+	mockObj.AssertNotCalled(s.T(), "Execute", mock.Anything)
+	historyMock.AssertNotCalled(s.T(), "Record", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenDryRunFails() {
+	mockObj := getReconfigureMock("GetConfigDiff")
+	mockObj.On("GetConfigDiff").Return("", fmt.Errorf("The proxy configuration is not valid"))
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&dryRun=true", nil)
+
+	Server{}.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
 func (s *ServerTestSuite) Test_ServeHTTP_ReturnsJson_WhenConsulTemplatePathIsPresent() {
 	path := "/path/to/consul/template"
 	req, _ := http.NewRequest(
@@ -312,7 +701,7 @@ func (s *ServerTestSuite) Test_ServeHTTP_InvokesReconfigureExecute_WhenConsulTem
 
 	server.ServeHTTP(s.ResponseWriter, req)
 
-	s.Equal(expectedBase, actualBase)
+	s.Equal(expectedBase.withHistoryRequest("GET "+req.URL.RequestURI()), actualBase)
 	s.Equal(expectedService, actualService)
 	mockObj.AssertCalled(s.T(), "Execute", []string{})
 }
@@ -354,13 +743,13 @@ func (s *ServerTestSuite) Test_ServeHTTP_InvokesRemoveExecute() {
 	mockObj := getRemoveMock("")
 	var actual Remove
 	expected := Remove{
-		ServiceName: s.ServiceName,
+		ServiceName:     s.ServiceName,
+		BaseReconfigure: server.BaseReconfigure.withHistoryRequest("GET " + s.RemoveUrl),
 	}
-	NewRemove = func(serviceName, configsPath, templatesPath string) Removable {
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
 		actual = Remove{
-			ServiceName:   serviceName,
-			TemplatesPath: templatesPath,
-			ConfigsPath:   configsPath,
+			ServiceName:     serviceName,
+			BaseReconfigure: baseData,
 		}
 		return mockObj
 	}
@@ -371,10 +760,960 @@ func (s *ServerTestSuite) Test_ServeHTTP_InvokesRemoveExecute() {
 	mockObj.AssertCalled(s.T(), "Execute", []string{})
 }
 
-// Suite
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesRemoveWithKeepRegistry_WhenKeepRegistryQueryIsTrue() {
+	actual := false
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = keepRegistry
+		return getRemoveMock("")
+	}
+	req, _ := http.NewRequest("GET", s.RemoveUrl+"&keepRegistry=true", nil)
 
-func TestServerTestSuite(t *testing.T) {
-	logPrintf = func(format string, v ...interface{}) {}
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.True(actual)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenRemoveExecuteFails() {
+	mockObj := getRemoveMock("Execute")
+	mockObj.On("Execute", []string{}).Return(fmt.Errorf("This is an error"))
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		return mockObj
+	}
+
+	server.ServeHTTP(s.ResponseWriter, s.RequestRemove)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+// ServeHTTP > Replicas
+
+func (s *ServerTestSuite) Test_ServeHTTP_PublishesReconfigure_WhenReplicasAreSynchronized() {
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+
+	srv.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
+
+	mockObj.AssertCalled(s.T(), "Publish", "reconfigure", s.ServiceName)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_PublishesRemove_WhenReplicasAreSynchronized() {
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		return getRemoveMock("")
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+
+	srv.ServeHTTP(s.ResponseWriter, s.RequestRemove)
+
+	mockObj.AssertCalled(s.T(), "Publish", "remove", s.ServiceName)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_DoesNotPublish_WhenReconfigureFails() {
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		mockObj := getReconfigureMock("Execute")
+		mockObj.On("Execute", mock.Anything).Return(fmt.Errorf("This is an error"))
+		return mockObj
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+
+	srv.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
+
+	mockObj.AssertNotCalled(s.T(), "Publish", mock.Anything, mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenPublishFails() {
+	mockObj := getReplicasMock("Publish")
+	mockObj.On("Publish", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+
+	srv.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_PublishesRollback_WhenReplicasAreSynchronized() {
+	historyMock := new(HistoryMock)
+	historyMock.On("Get", 3).Return(ConfigVersion{}, true, nil)
+	historyMock.On("GetAll").Return([]ConfigVersion{{
+		Version:  4,
+		Services: map[string]ServiceReconfigure{"go-demo": {ServiceName: "go-demo"}, "books-ms": {ServiceName: "books-ms"}},
+	}}, nil)
+	historyMock.On("Rollback", 3).Return(ConfigVersion{
+		Version:  5,
+		Services: map[string]ServiceReconfigure{"go-demo": {ServiceName: "go-demo"}},
+	}, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return historyMock
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=3", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertCalled(s.T(), "Publish", "reconfigure", "go-demo")
+	mockObj.AssertCalled(s.T(), "Publish", "remove", "books-ms")
+	mockObj.AssertNumberOfCalls(s.T(), "Publish", 2)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_DoesNotPublishRollback_WhenRollbackFails() {
+	historyMock := getHistoryMock("Rollback")
+	historyMock.On("Rollback", mock.Anything).Return(ConfigVersion{}, fmt.Errorf("This is an error"))
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return historyMock
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=3", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertNotCalled(s.T(), "Publish", mock.Anything, mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenRollbackPublishFails() {
+	historyMock := getHistoryMock("Rollback")
+	historyMock.On("Rollback", mock.Anything).Return(ConfigVersion{
+		Services: map[string]ServiceReconfigure{"go-demo": {ServiceName: "go-demo"}},
+	}, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return historyMock
+	}
+	mockObj := getReplicasMock("Publish")
+	mockObj.On("Publish", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=3", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsReplicasStatus_WhenStatusIsRequested() {
+	status := ReplicasStatus{
+		Revision: 3,
+		Replicas: []ReplicaStatus{{Name: "proxy-1", Revision: 3, InSync: true}},
+	}
+	mockObj := getReplicasMock("GetStatus")
+	mockObj.On("GetStatus").Return(status, nil)
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/status", nil)
+	expected, _ := json.Marshal(StatusResponse{Status: "OK", Revision: 3, Replicas: status.Replicas})
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404_WhenStatusIsRequestedAndReplicasAreNotSynchronized() {
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/status", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 404)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenReplicasStatusFails() {
+	mockObj := getReplicasMock("GetStatus")
+	mockObj.On("GetStatus").Return(ReplicasStatus{}, fmt.Errorf("This is an error"))
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/status", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+// ServeHTTP > History
+
+func (s *ServerTestSuite) Test_ServeHTTP_RecordsHistory_WhenServiceIsReconfigured() {
+	actual := BaseReconfigure{}
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actual = baseData
+		return getReconfigureMock("")
+	}
+
+	server.ServeHTTP(s.ResponseWriter, s.RequestReconfigure)
+
+	s.Equal(server.BaseReconfigure.withHistoryRequest("GET "+s.ReconfigureUrl), actual)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_RecordsHistory_WhenServiceIsRemoved() {
+	actual := BaseReconfigure{}
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = baseData
+		return getRemoveMock("")
+	}
+
+	server.ServeHTTP(s.ResponseWriter, s.RequestRemove)
+
+	s.Equal(server.BaseReconfigure.withHistoryRequest("GET "+s.RemoveUrl), actual)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsHistory_WhenHistoryIsRequested() {
+	versions := []ConfigVersion{{Version: 2, Request: "second"}, {Version: 1, Request: "first"}}
+	mockObj := getHistoryMock("GetAll")
+	mockObj.On("GetAll").Return(versions, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/history", nil)
+	expected, _ := json.Marshal(HistoryResponse{Status: "OK", Versions: versions})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsVersion_WhenHistoryIsRequestedWithVersion() {
+	version := ConfigVersion{Version: 2, Config: "my-config"}
+	mockObj := getHistoryMock("Get")
+	mockObj.On("Get", 2).Return(version, true, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/history?version=2", nil)
+	expected, _ := json.Marshal(HistoryResponse{Status: "OK", Versions: []ConfigVersion{version}})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404_WhenHistoryVersionDoesNotExist() {
+	mockObj := getHistoryMock("Get")
+	mockObj.On("Get", 42).Return(ConfigVersion{}, false, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/history?version=42", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 404)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesRollback() {
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=3", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertCalled(s.T(), "Rollback", 3)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenRollbackVersionIsNotValid() {
+	for _, query := range []string{"", "?version=latest"} {
+		rw := getResponseWriterMock()
+		req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback"+query, nil)
+
+		server.ServeHTTP(rw, req)
+
+		rw.AssertCalled(s.T(), "WriteHeader", 400)
+	}
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404_WhenRollbackVersionDoesNotExist() {
+	mockObj := getHistoryMock("Get")
+	mockObj.On("Get", 42).Return(ConfigVersion{}, false, nil)
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=42", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 404)
+	mockObj.AssertNotCalled(s.T(), "Rollback", mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenRollbackFails() {
+	mockObj := getHistoryMock("Rollback")
+	mockObj.On("Rollback", mock.Anything).Return(ConfigVersion{}, fmt.Errorf("This is an error"))
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/rollback?version=1", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus405_WhenRollbackMethodIsNotAllowed() {
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/rollback?version=1", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 405)
+}
+
+// ServeHTTP > Services
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesReconfigureExecute_WhenReqModeIsTcp() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest(
+		"GET",
+		fmt.Sprintf("%s?serviceName=%s&reqMode=tcp&srcPort=5432", s.ReconfigureBaseUrl, s.ServiceName),
+		nil,
+	)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal(ServiceReconfigure{ServiceName: s.ServiceName, ReqMode: "tcp", SrcPort: "5432"}, actualService)
+	mockObj.AssertCalled(s.T(), "Execute", []string{})
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_PassesPathRewriteQueries() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest(
+		"GET",
+		s.ReconfigureUrl+"&reqPathSearch=/demo/(.%2B)&reqPathReplace=/%5C1&stripPrefix=/api/v1",
+		nil,
+	)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal("/demo/(.+)", actualService.ReqPathSearch)
+	s.Equal("/\\1", actualService.ReqPathReplace)
+	s.Equal("/api/v1", actualService.StripPrefix)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_SplitsUsers() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&users=admin:$6$salt$hash,other:$1$salt$hash", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal([]string{"admin:$6$salt$hash", "other:$1$salt$hash"}, actualService.Users)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsUserNamesWithoutHashes_WhenServiceIsReconfigured() {
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return getReconfigureMock("")
+	}
+	var actual Response
+	rw := new(ResponseWriterMock)
+	rw.On("Header").Return(nil)
+	rw.On("WriteHeader", mock.Anything)
+	rw.On("Write", mock.Anything).Run(func(args mock.Arguments) {
+		json.Unmarshal(args.Get(0).([]byte), &actual)
+	}).Return(0, nil)
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&users=admin:$6$salt$hash,other:$1$salt$hash", nil)
+
+	server.ServeHTTP(rw, req)
+
+	s.Equal([]string{"admin", "other"}, actual.Users)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_SetsSkipDefaultUsers() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&skipDefaultUsers=true", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.True(actualService.SkipDefaultUsers)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_SplitsServiceDomain() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest(
+		"GET",
+		fmt.Sprintf("%s?serviceName=%s&servicePath=/demo&serviceDomain=my-domain.com,*.my-domain.com", s.ReconfigureBaseUrl, s.ServiceName),
+		nil,
+	)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal([]string{"my-domain.com", "*.my-domain.com"}, actualService.ServiceDomain)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ParsesServiceWeights() {
+	mockObj := getReconfigureMock("")
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&serviceWeight=blue:90,green:10", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal(map[string]int{"blue": 90, "green": 10}, actualService.ServiceWeights)
+	mockObj.AssertCalled(s.T(), "Execute", []string{})
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenServiceWeightsCannotBeParsed() {
+	mockObj := getReconfigureMock("")
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", s.ReconfigureUrl+"&serviceWeight=blue-90", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+	mockObj.AssertNotCalled(s.T(), "Execute", mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesReconfigureShift_WhenUrlIsShift() {
+	mockObj := getReconfigureMock("Shift")
+	mockObj.On("Shift", "blue", "green", 10).Return(ServiceReconfigure{
+		ServiceName:    s.ServiceName,
+		ServiceWeights: map[string]int{"blue": 80, "green": 20},
+	}, nil)
+	var actualService ServiceReconfigure
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actualService = serviceData
+		return mockObj
+	}
+	req, _ := http.NewRequest(
+		"PUT",
+		fmt.Sprintf("/v1/docker-flow-proxy/shift?serviceName=%s&from=blue&to=green&step=10", s.ServiceName),
+		nil,
+	)
+	expected, _ := json.Marshal(Response{
+		Status:         "OK",
+		ServiceName:    s.ServiceName,
+		ServiceWeights: map[string]int{"blue": 80, "green": 20},
+	})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal(s.ServiceName, actualService.ServiceName)
+	mockObj.AssertCalled(s.T(), "Shift", "blue", "green", 10)
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenShiftQueriesAreNotPresent() {
+	mockObj := getReconfigureMock("")
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	for _, query := range []string{
+		"from=blue&to=green&step=10",
+		"serviceName=go-demo&to=green&step=10",
+		"serviceName=go-demo&from=blue&step=10",
+		"serviceName=go-demo&from=blue&to=green",
+		"serviceName=go-demo&from=blue&to=green&step=-10",
+	} {
+		rw := getResponseWriterMock()
+		req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/shift?"+query, nil)
+
+		server.ServeHTTP(rw, req)
+
+		rw.AssertCalled(s.T(), "WriteHeader", 400)
+	}
+	mockObj.AssertNotCalled(s.T(), "Shift", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenShiftFails() {
+	mockObj := getReconfigureMock("Shift")
+	mockObj.On("Shift", mock.Anything, mock.Anything, mock.Anything).Return(ServiceReconfigure{}, fmt.Errorf("This is an error"))
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/shift?serviceName=go-demo&from=blue&to=green&step=10", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesReconfigureExecute_WhenServiceIsPosted() {
+	for _, method := range []string{"POST", "PUT"} {
+		mockObj := getReconfigureMock("")
+		var actualBase BaseReconfigure
+		var actualService ServiceReconfigure
+		NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+			actualBase = baseData
+			actualService = serviceData
+			return mockObj
+		}
+		body := `{"ServicePath": ["/demo,with,commas", "/demo2"], "ServiceDomain": ["my-domain.com"], "SkipCheck": true}`
+		req, _ := http.NewRequest(method, "/v1/docker-flow-proxy/services/go-demo", strings.NewReader(body))
+		expected := ServiceReconfigure{
+			ServiceName:   "go-demo",
+			ServicePath:   []string{"/demo,with,commas", "/demo2"},
+			ServiceDomain: []string{"my-domain.com"},
+			SkipCheck:     true,
+		}
+
+		server.ServeHTTP(s.ResponseWriter, req)
+
+		s.Equal(server.BaseReconfigure.withHistoryRequest(method+" /v1/docker-flow-proxy/services/go-demo"), actualBase)
+		s.Equal(expected, actualService)
+		mockObj.AssertCalled(s.T(), "Execute", []string{})
+	}
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsJSON_WhenServiceIsPosted() {
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/services/go-demo", strings.NewReader(`{"ServicePath": ["/demo"]}`))
+	expected, _ := json.Marshal(Response{
+		Status:      "OK",
+		ServiceName: "go-demo",
+		ServicePath: []string{"/demo"},
+	})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenServiceBodyIsNotValid() {
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/services/go-demo", strings.NewReader(`this is not JSON`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenServiceBodyDoesNotContainPath() {
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/services/go-demo", strings.NewReader(`{"ServiceDomain": ["my-domain.com"]}`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenServiceNameIsEmpty() {
+	req, _ := http.NewRequest("POST", "/v1/docker-flow-proxy/services/", strings.NewReader(`{"ServicePath": ["/demo"]}`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenServiceReconfigureFails() {
+	mockObj := getReconfigureMock("Execute")
+	mockObj.On("Execute", []string{}).Return(fmt.Errorf("This is an error"))
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/services/go-demo", strings.NewReader(`{"ServicePath": ["/demo"]}`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesRemoveExecute_WhenServiceIsDeleted() {
+	mockObj := getRemoveMock("")
+	var actual string
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = serviceName
+		return mockObj
+	}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/services/go-demo", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal("go-demo", actual)
+	mockObj.AssertCalled(s.T(), "Execute", []string{})
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesRemoveWithKeepRegistry_WhenServiceIsDeletedWithKeepRegistryQuery() {
+	actual := false
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = keepRegistry
+		return getRemoveMock("")
+	}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/services/go-demo?keepRegistry=true", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.True(actual)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenServiceRemoveFails() {
+	mockObj := getRemoveMock("Execute")
+	mockObj.On("Execute", []string{}).Return(fmt.Errorf("This is an error"))
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/services/go-demo", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStoredService_WhenServiceIsRequested() {
+	orig := NewRegistry
+	defer func() { NewRegistry = orig }()
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo,/demo2", true)
+	registryMock.On("GetServiceAttribute", "go-demo", DOMAIN_KEY).Return("my-domain.com", true)
+	registryMock.On("GetServiceAttribute", "go-demo", SKIP_CHECK_KEY).Return("true", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", true)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/services/go-demo", nil)
+	expected, _ := json.Marshal(Response{
+		Status:        "OK",
+		ServiceName:   "go-demo",
+		ServicePath:   []string{"/demo", "/demo2"},
+		ServiceDomain: []string{"my-domain.com"},
+		SkipCheck:     true,
+	})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsUserNamesWithoutHashes_WhenServiceIsRequested() {
+	orig := NewRegistry
+	defer func() { NewRegistry = orig }()
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "go-demo", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", "go-demo", USERS_KEY).Return("admin:$6$salt$hash,other:$1$salt$hash", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/services/go-demo", nil)
+	expected, _ := json.Marshal(Response{
+		Status:      "OK",
+		ServiceName: "go-demo",
+		ServicePath: []string{"/demo"},
+		Users:       []string{"admin", "other"},
+	})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte(expected))
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus404_WhenRequestedServiceDoesNotExist() {
+	orig := NewRegistry
+	defer func() { NewRegistry = orig }()
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/services/go-demo", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 404)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus405_WhenServiceMethodIsNotSupported() {
+	req, _ := http.NewRequest("PATCH", "/v1/docker-flow-proxy/services/go-demo", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 405)
+}
+
+// ServeHTTP > Services list
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsAllServices_WhenUrlIsServices() {
+	registryOrig := NewRegistry
+	readConfigsFileOrig := readConfigsFile
+	defer func() {
+		NewRegistry = registryOrig
+		readConfigsFile = readConfigsFileOrig
+	}()
+	registryMock := getRegistryMock("GetServiceAttribute")
+	registryMock.On("GetServiceAttribute", "config1", PATH_KEY).Return("/demo", true)
+	registryMock.On("GetServiceAttribute", "config1", COLOR_KEY).Return("blue", true)
+	registryMock.On("GetServiceAttribute", mock.Anything, mock.Anything).Return("", false)
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return registryMock, nil
+	}
+	readConfigsFile = func(filename string) ([]byte, error) {
+		if filename == "test_configs/tmpl/config1.cfg" {
+			return []byte(`backend config1-be
+	server node1_0_1111 10.0.0.1:1111 check
+	server node2_1_2222 10.0.0.2:2222 check`), nil
+		}
+		return []byte("backend config2-be"), nil
+	}
+	srv := Server{BaseReconfigure: BaseReconfigure{TemplatesPath: "test_configs/tmpl"}}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/services", nil)
+	expected, _ := json.Marshal([]ServiceResponse{
+		{
+			ServiceName:  "config1",
+			ServiceColor: "blue",
+			ServicePath:  []string{"/demo"},
+			Servers: []BackendServer{
+				{Name: "node1_0_1111", Address: "10.0.0.1:1111"},
+				{Name: "node2_1_2222", Address: "10.0.0.2:2222"},
+			},
+		},
+		{
+			ServiceName: "config2",
+			Servers:     []BackendServer{},
+		},
+	})
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenServicesCannotBeRead() {
+	registryOrig := NewRegistry
+	defer func() { NewRegistry = registryOrig }()
+	NewRegistry = func(baseData BaseReconfigure) (Registry, error) {
+		return getRegistryMock(""), nil
+	}
+	srv := Server{BaseReconfigure: BaseReconfigure{TemplatesPath: "/this/path/does/not/exist"}}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/services", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+// ServeHTTP > Config
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsConfig_WhenUrlIsConfig() {
+	readConfigsFileOrig := readConfigsFile
+	defer func() { readConfigsFile = readConfigsFileOrig }()
+	var actualFilename string
+	readConfigsFile = func(filename string) ([]byte, error) {
+		actualFilename = filename
+		return []byte("This is a config"), nil
+	}
+	var actualContentType string
+	httpWriterSetContentType = func(w http.ResponseWriter, value string) {
+		actualContentType = value
+	}
+	srv := Server{BaseReconfigure: BaseReconfigure{ConfigsPath: "/path/to/configs"}}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/config", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	s.Equal("/path/to/configs/haproxy.cfg", actualFilename)
+	s.Equal("text/plain", actualContentType)
+	s.ResponseWriter.AssertCalled(s.T(), "Write", []byte("This is a config"))
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus500_WhenConfigCannotBeRead() {
+	readConfigsFileOrig := readConfigsFile
+	defer func() { readConfigsFile = readConfigsFileOrig }()
+	readConfigsFile = func(filename string) ([]byte, error) {
+		return nil, fmt.Errorf("This is an error")
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/config", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 500)
+}
+
+// ServeHTTP > Cert
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesCertPut_WhenCertIsPut() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	mockObj := getCertMock("")
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/cert?certName=my-cert", strings.NewReader("PEM content"))
+	expected, _ := json.Marshal(CertResponse{Status: "OK", CertName: "my-cert"})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertCalled(s.T(), "Put", "my-cert", []byte("PEM content"))
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsSettings_WhenSettingsAreRequested() {
+	expected, _ := json.Marshal(SettingsResponse{Status: "OK", Settings: defaultProxySettings})
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/settings", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesSettingsPutWithMergedSettings_WhenSettingsArePut() {
+	mockObj := getSettingsMock("")
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return mockObj
+	}
+	expected := defaultProxySettings
+	expected.TimeoutServer = "60s"
+	expected.MaxConn = 100
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/settings", strings.NewReader(`{"TimeoutServer": "60s", "MaxConn": 100}`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertCalled(s.T(), "Put", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenSettingsPutFails() {
+	mockObj := getSettingsMock("Put")
+	mockObj.On("Put", mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/settings", strings.NewReader(`{"Balance": "random"}`))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenSettingsCannotBeParsed() {
+	mockObj := getSettingsMock("")
+	NewSettings = func(baseData BaseReconfigure) Settingsable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/settings", strings.NewReader("not json"))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+	mockObj.AssertNotCalled(s.T(), "Put", mock.Anything)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus405_WhenSettingsMethodIsNotAllowed() {
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/settings", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 405)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenCertNameIsNotPresent() {
+	for _, method := range []string{"PUT", "DELETE"} {
+		rw := getResponseWriterMock()
+		req, _ := http.NewRequest(method, "/v1/docker-flow-proxy/cert", strings.NewReader("PEM content"))
+
+		server.ServeHTTP(rw, req)
+
+		rw.AssertCalled(s.T(), "WriteHeader", 400)
+	}
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsStatus400_WhenCertPutFails() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	mockObj := getCertMock("Put")
+	mockObj.On("Put", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/cert?certName=my-cert", strings.NewReader("PEM content"))
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "WriteHeader", 400)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_ReturnsCerts_WhenCertsAreRequested() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	certs := []CertInfo{{Name: "my-cert", CommonName: "my-domain.com"}}
+	mockObj := getCertMock("GetAll")
+	mockObj.On("GetAll").Return(certs, nil)
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-proxy/cert", nil)
+	expected, _ := json.Marshal(CertResponse{Status: "OK", Certs: certs})
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	s.ResponseWriter.AssertCalled(s.T(), "Write", expected)
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_InvokesCertDelete_WhenCertIsDeleted() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	mockObj := getCertMock("")
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return mockObj
+	}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/cert?certName=my-cert", nil)
+
+	server.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertCalled(s.T(), "Delete", "my-cert")
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_PublishesCertChanges_WhenReplicasAreSynchronized() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return getCertMock("")
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	putReq, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/cert?certName=my-cert", strings.NewReader("PEM content"))
+	deleteReq, _ := http.NewRequest("DELETE", "/v1/docker-flow-proxy/cert?certName=other-cert", nil)
+
+	srv.ServeHTTP(s.ResponseWriter, putReq)
+	srv.ServeHTTP(s.ResponseWriter, deleteReq)
+
+	mockObj.AssertCalled(s.T(), "Publish", "putCert", "my-cert")
+	mockObj.AssertCalled(s.T(), "Publish", "deleteCert", "other-cert")
+}
+
+func (s *ServerTestSuite) Test_ServeHTTP_DoesNotPublishCert_WhenCertPutFails() {
+	orig := NewCert
+	defer func() { NewCert = orig }()
+	certMock := getCertMock("Put")
+	certMock.On("Put", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	NewCert = func(baseData BaseReconfigure) Certable {
+		return certMock
+	}
+	mockObj := getReplicasMock("")
+	srv := Server{BaseReconfigure: server.BaseReconfigure, replicas: mockObj}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-proxy/cert?certName=my-cert", strings.NewReader("PEM content"))
+
+	srv.ServeHTTP(s.ResponseWriter, req)
+
+	mockObj.AssertNotCalled(s.T(), "Publish", mock.Anything, mock.Anything)
+}
+
+// Suite
+
+func TestServerTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	newCertOrig := NewCert
+	newSettingsOrig := NewSettings
+	newHistoryOrig := NewHistory
+	defer func() {
+		NewCert = newCertOrig
+		NewSettings = newSettingsOrig
+		NewHistory = newHistoryOrig
+	}()
 	suite.Run(t, new(ServerTestSuite))
 }
 
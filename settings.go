@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type Settingsable interface {
+	Get() (ProxySettings, error)
+	Put(settings ProxySettings) error
+	Init(settings ProxySettings) error
+}
+
+type Settings struct {
+	BaseReconfigure
+}
+
+type ProxySettings struct {
+	Balance              string `long:"balance" env:"BALANCE" description:"The load balancing algorithm used by backends (e.g. leastconn). Defaults to roundrobin."`
+	MaxConn              int    `long:"maxconn" env:"MAXCONN" description:"The maximum number of concurrent connections per process. Defaults to 5000."`
+	TimeoutConnect       string `long:"timeout-connect" env:"TIMEOUT_CONNECT" description:"The time to wait for a connection to a server. Defaults to 5s."`
+	TimeoutClient        string `long:"timeout-client" env:"TIMEOUT_CLIENT" description:"The maximum inactivity time on the client side. Defaults to 20s."`
+	TimeoutServer        string `long:"timeout-server" env:"TIMEOUT_SERVER" description:"The maximum inactivity time on the server side. Defaults to 20s."`
+	TimeoutQueue         string `long:"timeout-queue" env:"TIMEOUT_QUEUE" description:"The maximum time to wait in the queue for a connection slot. Defaults to 30s."`
+	TimeoutHttpRequest   string `long:"timeout-http-request" env:"TIMEOUT_HTTP_REQUEST" description:"The maximum time to wait for a complete HTTP request. Defaults to 5s."`
+	TimeoutHttpKeepAlive string `long:"timeout-http-keep-alive" env:"TIMEOUT_HTTP_KEEP_ALIVE" description:"The maximum time to wait for a new HTTP request. Defaults to 15s."`
+	StatsUser            string `long:"stats-user" env:"STATS_USER" description:"The user of the HAProxy statistics page. Defaults to admin."`
+	StatsPass            string `long:"stats-pass" env:"STATS_PASS" description:"The password of the HAProxy statistics page. Defaults to admin."`
+}
+
+var defaultProxySettings = ProxySettings{
+	Balance:              "roundrobin",
+	MaxConn:              5000,
+	TimeoutConnect:       "5s",
+	TimeoutClient:        "20s",
+	TimeoutServer:        "20s",
+	TimeoutQueue:         "30s",
+	TimeoutHttpRequest:   "5s",
+	TimeoutHttpKeepAlive: "15s",
+	StatsUser:            "admin",
+	StatsPass:            "admin",
+}
+
+var balanceAlgorithms = []string{"roundrobin", "static-rr", "leastconn", "first", "source", "uri"}
+
+var timeoutRegexp = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+
+var NewSettings = func(baseData BaseReconfigure) Settingsable {
+	return &Settings{BaseReconfigure: baseData}
+}
+
+func (m *Settings) Get() (ProxySettings, error) {
+	return getProxySettings(m.TemplatesPath)
+}
+
+// Put applies the settings to the running proxy. The previous settings are restored if the new configuration is not valid.
+func (m *Settings) Put(settings ProxySettings) error {
+	settings = settings.withDefaults()
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	path := getProxySettingsPath(m.TemplatesPath)
+	previous, previousErr := readConfigsFile(path)
+	if err := m.write(settings); err != nil {
+		return err
+	}
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		if previousErr == nil {
+			writeFile(path, previous, 0664)
+		} else {
+			osRemove(path)
+		}
+		return err
+	}
+	return proxy.Reload()
+}
+
+// Init stores the settings the proxy was started with. They are applied with the next reload.
+// Only the settings that were specified override those already stored so that changes made through the API are kept after a restart.
+func (m *Settings) Init(settings ProxySettings) error {
+	mu.Lock()
+	defer mu.Unlock()
+	current, err := getProxySettings(m.TemplatesPath)
+	if err != nil {
+		return err
+	}
+	settings = current.withOverrides(settings)
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	return m.write(settings)
+}
+
+func (m *Settings) write(settings ProxySettings) error {
+	path := getProxySettingsPath(m.TemplatesPath)
+	content, _ := json.Marshal(settings)
+	if err := writeFile(path, content, 0664); err != nil {
+		return fmt.Errorf("Could not write the file %s\n%s", path, err.Error())
+	}
+	return nil
+}
+
+func (m ProxySettings) withDefaults() ProxySettings {
+	if len(m.Balance) == 0 {
+		m.Balance = defaultProxySettings.Balance
+	}
+	if m.MaxConn == 0 {
+		m.MaxConn = defaultProxySettings.MaxConn
+	}
+	if len(m.TimeoutConnect) == 0 {
+		m.TimeoutConnect = defaultProxySettings.TimeoutConnect
+	}
+	if len(m.TimeoutClient) == 0 {
+		m.TimeoutClient = defaultProxySettings.TimeoutClient
+	}
+	if len(m.TimeoutServer) == 0 {
+		m.TimeoutServer = defaultProxySettings.TimeoutServer
+	}
+	if len(m.TimeoutQueue) == 0 {
+		m.TimeoutQueue = defaultProxySettings.TimeoutQueue
+	}
+	if len(m.TimeoutHttpRequest) == 0 {
+		m.TimeoutHttpRequest = defaultProxySettings.TimeoutHttpRequest
+	}
+	if len(m.TimeoutHttpKeepAlive) == 0 {
+		m.TimeoutHttpKeepAlive = defaultProxySettings.TimeoutHttpKeepAlive
+	}
+	if len(m.StatsUser) == 0 {
+		m.StatsUser = defaultProxySettings.StatsUser
+	}
+	if len(m.StatsPass) == 0 {
+		m.StatsPass = defaultProxySettings.StatsPass
+	}
+	return m
+}
+
+func (m ProxySettings) withOverrides(overrides ProxySettings) ProxySettings {
+	if len(overrides.Balance) > 0 {
+		m.Balance = overrides.Balance
+	}
+	if overrides.MaxConn != 0 {
+		m.MaxConn = overrides.MaxConn
+	}
+	if len(overrides.TimeoutConnect) > 0 {
+		m.TimeoutConnect = overrides.TimeoutConnect
+	}
+	if len(overrides.TimeoutClient) > 0 {
+		m.TimeoutClient = overrides.TimeoutClient
+	}
+	if len(overrides.TimeoutServer) > 0 {
+		m.TimeoutServer = overrides.TimeoutServer
+	}
+	if len(overrides.TimeoutQueue) > 0 {
+		m.TimeoutQueue = overrides.TimeoutQueue
+	}
+	if len(overrides.TimeoutHttpRequest) > 0 {
+		m.TimeoutHttpRequest = overrides.TimeoutHttpRequest
+	}
+	if len(overrides.TimeoutHttpKeepAlive) > 0 {
+		m.TimeoutHttpKeepAlive = overrides.TimeoutHttpKeepAlive
+	}
+	if len(overrides.StatsUser) > 0 {
+		m.StatsUser = overrides.StatsUser
+	}
+	if len(overrides.StatsPass) > 0 {
+		m.StatsPass = overrides.StatsPass
+	}
+	return m
+}
+
+func (m ProxySettings) validate() error {
+	found := false
+	for _, algorithm := range balanceAlgorithms {
+		if m.Balance == algorithm {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("The balance %s is not supported. Please use one of the following: %s", m.Balance, strings.Join(balanceAlgorithms, ", "))
+	}
+	if m.MaxConn < 0 {
+		return fmt.Errorf("The maxconn must be greater than 0")
+	}
+	timeouts := map[string]string{
+		"timeout connect":         m.TimeoutConnect,
+		"timeout client":          m.TimeoutClient,
+		"timeout server":          m.TimeoutServer,
+		"timeout queue":           m.TimeoutQueue,
+		"timeout http-request":    m.TimeoutHttpRequest,
+		"timeout http-keep-alive": m.TimeoutHttpKeepAlive,
+	}
+	for name, value := range timeouts {
+		if !timeoutRegexp.MatchString(value) {
+			return fmt.Errorf("The %s %s is not valid (e.g. 20s)", name, value)
+		}
+	}
+	if strings.ContainsAny(m.StatsUser, ": \t#\\") || strings.ContainsAny(m.StatsPass, " \t#\\") {
+		return fmt.Errorf("The stats user and password cannot contain spaces, # or \\ and the user cannot contain :")
+	}
+	if strings.IndexFunc(m.StatsUser, unicode.IsControl) >= 0 || strings.IndexFunc(m.StatsPass, unicode.IsControl) >= 0 {
+		return fmt.Errorf("The stats user and password cannot contain control characters")
+	}
+	return nil
+}
+
+func getProxySettingsPath(templatesPath string) string {
+	return fmt.Sprintf("%s/settings.json", templatesPath)
+}
+
+func getProxySettings(templatesPath string) (ProxySettings, error) {
+	settings := ProxySettings{}
+	path := getProxySettingsPath(templatesPath)
+	content, err := readConfigsFile(path)
+	if os.IsNotExist(err) {
+		return settings.withDefaults(), nil
+	} else if err != nil {
+		return settings, fmt.Errorf("Could not read the file %s\n%s", path, err.Error())
+	}
+	if err := json.Unmarshal(content, &settings); err != nil {
+		return settings, fmt.Errorf("Could not parse the file %s\n%s", path, err.Error())
+	}
+	return settings.withDefaults(), nil
+}
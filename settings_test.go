@@ -0,0 +1,214 @@
+// +build !integration
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type SettingsTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	settings *Settings
+}
+
+func (s *SettingsTestSuite) SetupTest() {
+	s.TemplatesPath, _ = ioutil.TempDir("", "settings")
+	s.settings = NewSettings(s.BaseReconfigure).(*Settings)
+	proxy = getProxyMock("")
+	readConfigsFile = ioutil.ReadFile
+	writeFile = ioutil.WriteFile
+	osRemove = os.Remove
+}
+
+func (s *SettingsTestSuite) TearDownTest() {
+	os.RemoveAll(s.TemplatesPath)
+}
+
+// Get
+
+func (s SettingsTestSuite) Test_Get_ReturnsDefaults_WhenSettingsAreNotStored() {
+	actual, err := s.settings.Get()
+
+	s.NoError(err)
+	s.Equal(defaultProxySettings, actual)
+}
+
+func (s SettingsTestSuite) Test_Get_ReturnsStoredSettingsWithDefaults() {
+	ioutil.WriteFile(fmt.Sprintf("%s/settings.json", s.TemplatesPath), []byte(`{"TimeoutServer": "60s"}`), 0664)
+	expected := defaultProxySettings
+	expected.TimeoutServer = "60s"
+
+	actual, _ := s.settings.Get()
+
+	s.Equal(expected, actual)
+}
+
+func (s SettingsTestSuite) Test_Get_ReturnsError_WhenStoredSettingsCannotBeParsed() {
+	ioutil.WriteFile(fmt.Sprintf("%s/settings.json", s.TemplatesPath), []byte(`not json`), 0664)
+
+	_, err := s.settings.Get()
+
+	s.Error(err)
+}
+
+// Put
+
+func (s SettingsTestSuite) Test_Put_StoresSettings() {
+	s.settings.Put(ProxySettings{MaxConn: 100, StatsPass: "secret"})
+
+	actual, _ := s.settings.Get()
+	s.Equal(100, actual.MaxConn)
+	s.Equal("secret", actual.StatsPass)
+	s.Equal("roundrobin", actual.Balance)
+}
+
+func (s SettingsTestSuite) Test_Put_CreatesConfigAndReloadsProxy() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	s.settings.Put(ProxySettings{})
+
+	mockObj.AssertCalled(s.T(), "CreateConfigFromTemplates", s.TemplatesPath, s.ConfigsPath)
+	mockObj.AssertCalled(s.T(), "Reload")
+}
+
+func (s SettingsTestSuite) Test_Put_ReturnsError_WhenSettingsAreNotValid() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	for _, settings := range []ProxySettings{
+		{Balance: "random"},
+		{MaxConn: -1},
+		{TimeoutServer: "20 seconds"},
+		{TimeoutHttpKeepAlive: "s"},
+		{StatsUser: "ad:min"},
+		{StatsPass: "my pass"},
+		{StatsPass: "x\n    option httplog"},
+		{StatsPass: "x\r\n    option httplog"},
+		{StatsUser: "admin\nstats enable"},
+		{StatsPass: "x\x00"},
+	} {
+		err := s.settings.Put(settings)
+
+		s.Error(err, "%v", settings)
+	}
+	mockObj.AssertNotCalled(s.T(), "CreateConfigFromTemplates", mock.Anything, mock.Anything)
+}
+
+func (s SettingsTestSuite) Test_Put_RestoresPreviousSettings_WhenConfigIsNotValid() {
+	s.settings.Put(ProxySettings{TimeoutServer: "30s"})
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	err := s.settings.Put(ProxySettings{TimeoutServer: "60s"})
+
+	s.Error(err)
+	actual, _ := s.settings.Get()
+	s.Equal("30s", actual.TimeoutServer)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s SettingsTestSuite) Test_Put_RemovesSettings_WhenConfigIsNotValidAndThereWereNoPreviousSettings() {
+	mockObj := getProxyMock("CreateConfigFromTemplates")
+	mockObj.On("CreateConfigFromTemplates", mock.Anything, mock.Anything).Return(fmt.Errorf("This is an error"))
+	proxy = mockObj
+
+	s.settings.Put(ProxySettings{TimeoutServer: "60s"})
+
+	_, err := os.Stat(fmt.Sprintf("%s/settings.json", s.TemplatesPath))
+	s.True(os.IsNotExist(err))
+}
+
+// Init
+
+func (s SettingsTestSuite) Test_Init_StoresSettingsWithoutReloading() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+
+	err := s.settings.Init(ProxySettings{Balance: "leastconn"})
+
+	s.NoError(err)
+	content, _ := ioutil.ReadFile(fmt.Sprintf("%s/settings.json", s.TemplatesPath))
+	actual := ProxySettings{}
+	json.Unmarshal(content, &actual)
+	s.Equal("leastconn", actual.Balance)
+	s.Equal(defaultProxySettings.TimeoutServer, actual.TimeoutServer)
+	mockObj.AssertNotCalled(s.T(), "Reload")
+}
+
+func (s SettingsTestSuite) Test_Init_KeepsStoredSettings_WhenTheyAreNotSpecified() {
+	ioutil.WriteFile(fmt.Sprintf("%s/settings.json", s.TemplatesPath), []byte(`{"TimeoutServer": "60s", "Balance": "source"}`), 0664)
+
+	err := s.settings.Init(ProxySettings{Balance: "leastconn"})
+
+	s.NoError(err)
+	actual, _ := s.settings.Get()
+	s.Equal("leastconn", actual.Balance)
+	s.Equal("60s", actual.TimeoutServer)
+	s.Equal(defaultProxySettings.MaxConn, actual.MaxConn)
+}
+
+func (s SettingsTestSuite) Test_Init_ReturnsError_WhenSettingsAreNotValid() {
+	err := s.settings.Init(ProxySettings{Balance: "random"})
+
+	s.Error(err)
+}
+
+func (s SettingsTestSuite) Test_Init_ReturnsError_WhenStoredSettingsCannotBeParsed() {
+	ioutil.WriteFile(fmt.Sprintf("%s/settings.json", s.TemplatesPath), []byte(`not json`), 0664)
+
+	err := s.settings.Init(ProxySettings{})
+
+	s.Error(err)
+}
+
+// Suite
+
+func TestSettingsTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	proxyOrig := proxy
+	defer func() { proxy = proxyOrig }()
+	suite.Run(t, new(SettingsTestSuite))
+}
+
+// Mock
+
+type SettingsMock struct {
+	mock.Mock
+}
+
+func (m *SettingsMock) Get() (ProxySettings, error) {
+	params := m.Called()
+	return params.Get(0).(ProxySettings), params.Error(1)
+}
+
+func (m *SettingsMock) Put(settings ProxySettings) error {
+	params := m.Called(settings)
+	return params.Error(0)
+}
+
+func (m *SettingsMock) Init(settings ProxySettings) error {
+	params := m.Called(settings)
+	return params.Error(0)
+}
+
+func getSettingsMock(skipMethod string) *SettingsMock {
+	mockObj := new(SettingsMock)
+	if skipMethod != "Get" {
+		mockObj.On("Get").Return(defaultProxySettings, nil)
+	}
+	if skipMethod != "Put" {
+		mockObj.On("Put", mock.Anything).Return(nil)
+	}
+	if skipMethod != "Init" {
+		mockObj.On("Init", mock.Anything).Return(nil)
+	}
+	return mockObj
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+const DOCKER_PORT_LABEL = "com.df.port"
+
+type SwarmListener struct {
+	BaseReconfigure
+	Interval time.Duration
+	address  string
+	client   *http.Client
+	services map[string]ServiceReconfigure
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+type swarmService struct {
+	ID   string
+	Spec struct {
+		Name   string
+		Labels map[string]string
+	}
+}
+
+var NewSwarmListener = func(baseData BaseReconfigure, host string, interval time.Duration) Watchable {
+	client, address := getDockerClient(host)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SwarmListener{
+		BaseReconfigure: baseData,
+		Interval:        interval,
+		address:         address,
+		client:          client,
+		services:        map[string]ServiceReconfigure{},
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+func (m *SwarmListener) Watch() error {
+	logPrintf("Polling Swarm services from %s", m.address)
+	for {
+		if err := m.update(); err != nil {
+			logPrintf("Could not retrieve Swarm services\n%s", err.Error())
+		}
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-time.After(m.Interval):
+		}
+	}
+}
+
+func (m *SwarmListener) Stop() {
+	m.cancel()
+}
+
+func (m *SwarmListener) update() error {
+	services, err := m.getServices()
+	if err != nil {
+		return err
+	}
+	current := map[string]ServiceReconfigure{}
+	for _, service := range services {
+		if sr, ok := m.getServiceReconfigure(service); ok {
+			current[sr.ServiceName] = sr
+		}
+	}
+	for name, sr := range current {
+		if prev, ok := m.services[name]; ok && reflect.DeepEqual(prev, sr) {
+			continue
+		}
+		logPrintf("Swarm service %s was created or updated", name)
+		if err := NewReconfigure(m.BaseReconfigure.withHistoryRequest("Swarm listener"), sr).Execute([]string{}); err != nil {
+			logPrintf("Could not reconfigure the service %s\n%s", name, err.Error())
+			continue
+		}
+		m.services[name] = sr
+	}
+	for name := range m.services {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		logPrintf("Swarm service %s was removed", name)
+		if err := NewRemove(name, false, m.BaseReconfigure.withHistoryRequest("Swarm listener")).Execute([]string{}); err != nil {
+			logPrintf("Could not remove the service %s\n%s", name, err.Error())
+			continue
+		}
+		delete(m.services, name)
+	}
+	return nil
+}
+
+func (m *SwarmListener) getServiceReconfigure(service swarmService) (ServiceReconfigure, bool) {
+	labels := map[string]string{DOCKER_SERVICE_NAME_LABEL: service.Spec.Name}
+	for key, value := range service.Spec.Labels {
+		labels[key] = value
+	}
+	sr, ok := getServiceReconfigureFromLabels(labels)
+	sr.Port = labels[DOCKER_PORT_LABEL]
+	if !ok || len(sr.Port) == 0 {
+		return sr, false
+	}
+	return sr, true
+}
+
+func (m *SwarmListener) getServices() ([]swarmService, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/services", m.address), nil)
+	resp, err := m.client.Do(req.WithContext(m.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker running on %s returned status %d", m.address, resp.StatusCode)
+	}
+	services := []swarmService{}
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, fmt.Errorf("Could not parse the list of Swarm services\n%s", err.Error())
+	}
+	return services, nil
+}
@@ -0,0 +1,179 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SwarmListenerTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Server       *httptest.Server
+	Services     string
+	mu           *sync.Mutex
+	Reconfigured []ServiceReconfigure
+	Removed      []string
+	origReconf   func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable
+	origRemove   func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable
+}
+
+func (s *SwarmListenerTestSuite) SetupTest() {
+	s.TemplatesPath = "test_configs/tmpl"
+	s.ConsulAddress = "http://1.2.3.4:1234"
+	s.mu.Lock()
+	s.Services = `[
+		{"ID": "1", "Spec": {"Name": "go-demo", "Labels": {"com.df.servicePath": "/demo", "com.df.port": "8080"}}},
+		{"ID": "2", "Spec": {"Name": "books-ms-blue", "Labels": {"com.df.serviceName": "books-ms", "com.df.serviceColor": "blue", "com.df.servicePath": "/api/v1/books", "com.df.port": "8081"}}},
+		{"ID": "3", "Spec": {"Name": "no-port", "Labels": {"com.df.servicePath": "/no-port"}}},
+		{"ID": "4", "Spec": {"Name": "no-labels"}}
+	]`
+	s.Reconfigured = []ServiceReconfigure{}
+	s.Removed = []string{}
+	s.mu.Unlock()
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Reconfigured = append(s.Reconfigured, serviceData)
+		return getReconfigureMock("")
+	}
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Removed = append(s.Removed, serviceName)
+		return getRemoveMock("")
+	}
+}
+
+func (s *SwarmListenerTestSuite) TearDownTest() {
+	NewReconfigure = s.origReconf
+	NewRemove = s.origRemove
+}
+
+// update
+
+func (s *SwarmListenerTestSuite) Test_Update_ReconfiguresServicesWithLabels() {
+	expected := []ServiceReconfigure{
+		{ServiceName: "go-demo", ServicePath: []string{"/demo"}, Port: "8080"},
+		{ServiceName: "books-ms", ServiceColor: "blue", ServicePath: []string{"/api/v1/books"}, Port: "8081"},
+	}
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Hour).(*SwarmListener)
+
+	l.update()
+
+	s.ElementsMatch(expected, s.Reconfigured)
+}
+
+func (s *SwarmListenerTestSuite) Test_Update_DoesNotReconfigureUnchangedServices() {
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Hour).(*SwarmListener)
+	l.update()
+
+	l.update()
+
+	s.Len(s.Reconfigured, 2)
+}
+
+func (s *SwarmListenerTestSuite) Test_Update_ReconfiguresChangedServices() {
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Hour).(*SwarmListener)
+	l.update()
+	s.Services = `[
+		{"ID": "1", "Spec": {"Name": "go-demo", "Labels": {"com.df.servicePath": "/demo", "com.df.port": "9090"}}},
+		{"ID": "2", "Spec": {"Name": "books-ms-blue", "Labels": {"com.df.serviceName": "books-ms", "com.df.serviceColor": "blue", "com.df.servicePath": "/api/v1/books", "com.df.port": "8081"}}}
+	]`
+
+	l.update()
+
+	s.Len(s.Reconfigured, 3)
+	s.Equal("9090", s.Reconfigured[2].Port)
+}
+
+func (s *SwarmListenerTestSuite) Test_Update_RemovesServicesThatNoLongerExist() {
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Hour).(*SwarmListener)
+	l.update()
+	s.Services = `[{"ID": "1", "Spec": {"Name": "go-demo", "Labels": {"com.df.servicePath": "/demo", "com.df.port": "8080"}}}]`
+
+	l.update()
+
+	s.Equal([]string{"books-ms"}, s.Removed)
+	s.NotContains(l.services, "books-ms")
+}
+
+func (s *SwarmListenerTestSuite) Test_Update_RecordsHistory() {
+	actual := []BaseReconfigure{}
+	NewReconfigure = func(baseData BaseReconfigure, serviceData ServiceReconfigure) Reconfigurable {
+		actual = append(actual, baseData)
+		return getReconfigureMock("")
+	}
+	NewRemove = func(serviceName string, keepRegistry bool, baseData BaseReconfigure) Removable {
+		actual = append(actual, baseData)
+		return getRemoveMock("")
+	}
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Hour).(*SwarmListener)
+	l.update()
+	s.Services = `[{"ID": "1", "Spec": {"Name": "go-demo", "Labels": {"com.df.servicePath": "/demo", "com.df.port": "8080"}}}]`
+
+	l.update()
+
+	s.Len(actual, 3)
+	for _, baseData := range actual {
+		s.Equal(s.BaseReconfigure.withHistoryRequest("Swarm listener"), baseData)
+	}
+}
+
+func (s *SwarmListenerTestSuite) Test_Update_ReturnsError_WhenDockerIsNotAvailable() {
+	l := NewSwarmListener(s.BaseReconfigure, "http:///THIS/URL/DOES/NOT/EXIST", time.Hour).(*SwarmListener)
+
+	s.Error(l.update())
+}
+
+// Watch
+
+func (s *SwarmListenerTestSuite) Test_Watch_PollsServicesUntilStopped() {
+	l := NewSwarmListener(s.BaseReconfigure, s.Server.URL, time.Millisecond).(*SwarmListener)
+	done := make(chan bool)
+	go func() {
+		l.Watch()
+		done <- true
+	}()
+
+	s.Eventually(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.Reconfigured) == 2
+	}, time.Second, 10*time.Millisecond)
+	l.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		s.Fail("Watch did not return after Stop")
+	}
+}
+
+// Suite
+
+func TestSwarmListenerTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	s := new(SwarmListenerTestSuite)
+	s.mu = &sync.Mutex{}
+	s.origReconf = NewReconfigure
+	s.origRemove = NewRemove
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		services := s.Services
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/services":
+			fmt.Fprint(w, services)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
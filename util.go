@@ -12,15 +12,13 @@ var readPidFile = ioutil.ReadFile
 var readConfigsDir = ioutil.ReadDir
 var readConfigsFile = ioutil.ReadFile
 var readTemplateFile = ioutil.ReadFile
-var cmdRunConsul = func(cmd *exec.Cmd) error {
-	return cmd.Run()
-}
 var cmdRunHa = func(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 var writeFile = ioutil.WriteFile
-var writeConsulTemplateFile = ioutil.WriteFile
+var writeServiceConfigFile = ioutil.WriteFile
 var osRemove = os.Remove
+var osRename = os.Rename
 var httpListenAndServe = http.ListenAndServe
 var httpWriterSetContentType = func(w http.ResponseWriter, value string) {
 	w.Header().Set("Content-Type", value)
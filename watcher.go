@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Watchable interface {
+	Watch() error
+	Stop()
+}
+
+type Watcher struct {
+	BaseReconfigure
+	Debounce      time.Duration
+	RetryInterval time.Duration
+	address       string
+	services      map[string]context.CancelFunc
+	timer         *time.Timer
+	mu            *sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+var NewWatcher = func(baseData BaseReconfigure, debounce time.Duration) Watchable {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Watcher{
+		BaseReconfigure: baseData,
+		Debounce:        debounce,
+		RetryInterval:   5 * time.Second,
+		address:         getRegistryAddress(baseData.ConsulAddress),
+		services:        map[string]context.CancelFunc{},
+		mu:              &sync.Mutex{},
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+func (m *Watcher) Watch() error {
+	logPrintf("Watching Consul running on %s for changes", m.address)
+	index := "0"
+	for {
+		if m.ctx.Err() != nil {
+			return nil
+		}
+		body, newIndex, err := m.getBlocking(m.ctx, fmt.Sprintf("%s/v1/catalog/services", m.address), index)
+		if err != nil {
+			if m.ctx.Err() == nil {
+				logPrintf("Could not watch the list of services\n%s", err.Error())
+			}
+			m.wait(m.ctx)
+			continue
+		}
+		if newIndex == index {
+			continue
+		}
+		index = newIndex
+		data := map[string]interface{}{}
+		json.Unmarshal(body, &data)
+		m.updateServices(data)
+	}
+}
+
+// Stop cancels all blocking requests sent to Consul and any pending reload.
+func (m *Watcher) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancel()
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	for name, cancel := range m.services {
+		cancel()
+		delete(m.services, name)
+	}
+}
+
+func (m *Watcher) updateServices(data map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range data {
+		if _, ok := m.services[name]; !ok {
+			ctx, cancel := context.WithCancel(m.ctx)
+			m.services[name] = cancel
+			go m.watchService(ctx, name)
+		}
+	}
+	for name, cancel := range m.services {
+		if _, ok := data[name]; !ok {
+			cancel()
+			delete(m.services, name)
+			go m.updateService(name)
+		}
+	}
+}
+
+func (m *Watcher) watchService(ctx context.Context, name string) {
+	index := "0"
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		_, newIndex, err := m.getBlocking(ctx, fmt.Sprintf("%s/v1/health/service/%s", m.address, url.QueryEscape(name)), index)
+		if err != nil {
+			if ctx.Err() == nil {
+				logPrintf("Could not watch the service %s\n%s", name, err.Error())
+			}
+			m.wait(ctx)
+			continue
+		}
+		if newIndex != index {
+			index = newIndex
+			m.updateService(name)
+		}
+	}
+}
+
+func (m *Watcher) updateService(name string) {
+	registry, err := NewRegistry(m.BaseReconfigure)
+	if err != nil {
+		logPrintf(err.Error())
+		return
+	}
+	r := &Reconfigure{BaseReconfigure: m.BaseReconfigure}
+	sr, ok := m.findService(r, registry, name)
+	if !ok {
+		return
+	}
+	logPrintf("Instances of the service %s changed", sr.ServiceName)
+	mu.Lock()
+	err = r.createConfig(registry, m.TemplatesPath, sr)
+	mu.Unlock()
+	if err != nil {
+		logPrintf("Could not update the configuration of the service %s\n%s", sr.ServiceName, err.Error())
+		return
+	}
+	m.scheduleReload()
+}
+
+func (m *Watcher) findService(r *Reconfigure, registry Registry, name string) (ServiceReconfigure, bool) {
+	c := make(chan ServiceReconfigure, 1)
+	r.getService(registry, name, c)
+	if sr := <-c; isServiceConfigured(sr) {
+		return sr, true
+	}
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		r.getService(registry, name[:i], c)
+		sr := <-c
+		color := name[i+1:]
+		_, weighted := sr.ServiceWeights[color]
+		if isServiceConfigured(sr) && (sr.ServiceColor == color || weighted) {
+			return sr, true
+		}
+	}
+	return ServiceReconfigure{}, false
+}
+
+func (m *Watcher) scheduleReload() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return
+	}
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.timer = time.AfterFunc(m.Debounce, m.reload)
+}
+
+// reload re-renders the configuration with the current instances. Instance changes are not recorded in the history
+// since they would push changes of service definitions out of the rollback window.
+func (m *Watcher) reload() {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := proxy.CreateConfigFromTemplates(m.TemplatesPath, m.ConfigsPath); err != nil {
+		logPrintf("Could not create the proxy configuration\n%s", err.Error())
+		return
+	}
+	if err := proxy.Reload(); err != nil {
+		logPrintf("Could not reload the proxy\n%s", err.Error())
+	}
+}
+
+func (m *Watcher) getBlocking(ctx context.Context, addr, index string) ([]byte, string, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s?index=%s&wait=5m", addr, index), nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, index, fmt.Errorf("%s returned status %d", addr, resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	newIndex := resp.Header.Get("X-Consul-Index")
+	if len(newIndex) == 0 {
+		return nil, index, fmt.Errorf("%s did not return the X-Consul-Index header", addr)
+	}
+	return body, newIndex, nil
+}
+
+func (m *Watcher) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(m.RetryInterval):
+	}
+}
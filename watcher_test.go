@@ -0,0 +1,288 @@
+// +build !integration
+
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type WatcherTestSuite struct {
+	suite.Suite
+	BaseReconfigure
+	Server       *httptest.Server
+	CatalogIndex string
+	mu           *sync.Mutex
+}
+
+func (s *WatcherTestSuite) SetupTest() {
+	s.TemplatesPath = "test_configs/tmpl"
+	s.ConfigsPath = "path/to/configs/dir"
+	s.ConsulAddress = s.Server.URL
+	s.mu.Lock()
+	s.CatalogIndex = "1"
+	s.mu.Unlock()
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		return nil
+	}
+	proxy = getProxyMock("")
+}
+
+// NewWatcher
+
+func (s WatcherTestSuite) Test_NewWatcher_AddsHttpIfNotPresent() {
+	s.ConsulAddress = strings.Replace(s.ConsulAddress, "http://", "", -1)
+
+	w := NewWatcher(s.BaseReconfigure, time.Second).(*Watcher)
+
+	s.Equal(s.Server.URL, w.address)
+}
+
+// Watch
+
+func (s WatcherTestSuite) Test_Watch_StartsWatchingCatalogServices() {
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	go w.Watch()
+	defer w.Stop()
+
+	s.Eventually(func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		_, ok1 := w.services["go-demo"]
+		_, ok2 := w.services["consul"]
+		return ok1 && ok2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Stop
+
+func (s WatcherTestSuite) Test_Stop_DoesNotPanic_WhenCalledTwice() {
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	w.updateServices(map[string]interface{}{"go-demo": []string{}})
+
+	w.Stop()
+
+	s.NotPanics(w.Stop)
+	s.Empty(w.services)
+}
+
+func (s WatcherTestSuite) Test_Stop_CancelsBlockingRequests() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	s.ConsulAddress = srv.URL
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	watchDone := make(chan bool)
+	serviceDone := make(chan bool)
+	go func() {
+		w.Watch()
+		close(watchDone)
+	}()
+	go func() {
+		w.watchService(w.ctx, "go-demo")
+		close(serviceDone)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	w.Stop()
+
+	for _, done := range []chan bool{watchDone, serviceDone} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			s.Fail("The blocking request was not canceled")
+		}
+	}
+}
+
+// updateServices
+
+func (s WatcherTestSuite) Test_UpdateServices_StopsWatchingRemovedServices() {
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	defer w.Stop()
+	w.updateServices(map[string]interface{}{"go-demo": []string{}, "consul": []string{}})
+
+	w.updateServices(map[string]interface{}{"consul": []string{}})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s.Len(w.services, 1)
+	s.Contains(w.services, "consul")
+}
+
+// updateService
+
+func (s WatcherTestSuite) Test_UpdateService_WritesServiceConfig() {
+	var actualFilename, actualData string
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		if strings.HasSuffix(filename, ".cfg") {
+			actualFilename = filename
+			actualData = string(data)
+		}
+		return nil
+	}
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	defer w.Stop()
+
+	w.updateService("go-demo")
+
+	s.Equal(fmt.Sprintf("%s/go-demo.cfg", s.TemplatesPath), actualFilename)
+	s.Contains(actualData, "server node1_0_1111 10.0.0.1:1111 check")
+}
+
+func (s WatcherTestSuite) Test_UpdateService_ResolvesServiceColor() {
+	var actualFilename string
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		if strings.HasSuffix(filename, ".cfg") {
+			actualFilename = filename
+		}
+		return nil
+	}
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	defer w.Stop()
+
+	w.updateService("books-ms-blue")
+
+	s.Equal(fmt.Sprintf("%s/books-ms.cfg", s.TemplatesPath), actualFilename)
+}
+
+func (s WatcherTestSuite) Test_UpdateService_ResolvesServiceWeightColor() {
+	var actualFilename string
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		if strings.HasSuffix(filename, ".cfg") {
+			actualFilename = filename
+		}
+		return nil
+	}
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	defer w.Stop()
+
+	w.updateService("go-api-green")
+
+	s.Equal(fmt.Sprintf("%s/go-api.cfg", s.TemplatesPath), actualFilename)
+}
+
+func (s WatcherTestSuite) Test_UpdateService_DoesNothing_WhenServiceIsNotRegistered() {
+	actual := false
+	writeServiceConfigFile = func(filename string, data []byte, perm os.FileMode) error {
+		actual = true
+		return nil
+	}
+	w := NewWatcher(s.BaseReconfigure, time.Hour).(*Watcher)
+	defer w.Stop()
+
+	w.updateService("consul")
+
+	s.False(actual)
+}
+
+// scheduleReload
+
+func (s WatcherTestSuite) Test_ScheduleReload_ReloadsProxyOnce() {
+	mockObj := getProxyMock("")
+	proxy = mockObj
+	w := NewWatcher(s.BaseReconfigure, 20*time.Millisecond).(*Watcher)
+	defer w.Stop()
+
+	w.scheduleReload()
+	w.scheduleReload()
+	w.scheduleReload()
+	time.Sleep(100 * time.Millisecond)
+
+	mockObj.AssertNumberOfCalls(s.T(), "CreateConfigFromTemplates", 1)
+	mockObj.AssertNumberOfCalls(s.T(), "Reload", 1)
+}
+
+func (s WatcherTestSuite) Test_ScheduleReload_DoesNotRecordHistory() {
+	orig := NewHistory
+	defer func() { NewHistory = orig }()
+	mockObj := getHistoryMock("")
+	NewHistory = func(baseData BaseReconfigure, limit int) Historyable {
+		return mockObj
+	}
+	w := NewWatcher(s.BaseReconfigure, time.Millisecond).(*Watcher)
+	defer w.Stop()
+
+	w.scheduleReload()
+	time.Sleep(50 * time.Millisecond)
+
+	mockObj.AssertNotCalled(s.T(), "Record", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Suite
+
+func TestWatcherTestSuite(t *testing.T) {
+	logPrintf = func(format string, v ...interface{}) {}
+	proxyOrig := proxy
+	defer func() { proxy = proxyOrig }()
+	s := new(WatcherTestSuite)
+	s.mu = &sync.Mutex{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		index := s.CatalogIndex
+		s.mu.Unlock()
+		if r.URL.Query().Get("index") == index {
+			time.Sleep(10 * time.Millisecond)
+		}
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			w.Header().Set("X-Consul-Index", index)
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `{"consul": [], "go-demo": [], "books-ms-blue": []}`)
+		case "/v1/health/service/go-demo":
+			w.Header().Set("X-Consul-Index", index)
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `[{"Node": {"Node": "node1", "Address": "10.0.0.1"}, "Service": {"Port": 1111}}]`)
+		case "/v1/kv/docker-flow/go-demo/path", "/v1/kv/docker-flow/books-ms/path", "/v1/kv/docker-flow/go-api/path":
+			fmt.Fprint(w, "/demo")
+		case "/v1/kv/docker-flow/books-ms/color":
+			fmt.Fprint(w, "blue")
+		case "/v1/kv/docker-flow/go-api/weights":
+			fmt.Fprint(w, "blue:90,green:10")
+		default:
+			if strings.HasPrefix(r.URL.Path, "/v1/health/service/") {
+				w.Header().Set("X-Consul-Index", index)
+				fmt.Fprint(w, "[]")
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+	}))
+	defer s.Server.Close()
+	suite.Run(t, s)
+}
+
+// Mock
+
+type WatcherMock struct {
+	mock.Mock
+}
+
+func (m *WatcherMock) Watch() error {
+	params := m.Called()
+	return params.Error(0)
+}
+
+func (m *WatcherMock) Stop() {
+	m.Called()
+}
+
+func getWatcherMock(skipMethod string) *WatcherMock {
+	mockObj := new(WatcherMock)
+	if skipMethod != "Watch" {
+		mockObj.On("Watch").Return(nil)
+	}
+	if skipMethod != "Stop" {
+		mockObj.On("Stop")
+	}
+	return mockObj
+}